@@ -3,8 +3,10 @@ package esxi
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 )
 
 func dataSourceGuest() *schema.Resource {
@@ -89,6 +91,22 @@ func dataSourceGuest() *schema.Resource {
 							Computed:    true,
 							Description: "NIC type.",
 						},
+						"connected": &schema.Schema{
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "Network interface is connected.",
+						},
+						"start_connected": &schema.Schema{
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "Network interface connects at power on.",
+						},
+						"ip_addresses": &schema.Schema{
+							Type:        schema.TypeList,
+							Computed:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: "The IPs of the network interface reported by VMware tools.",
+						},
 					},
 				},
 			},
@@ -100,7 +118,19 @@ func dataSourceGuest() *schema.Resource {
 			"ip_address": &schema.Schema{
 				Type:        schema.TypeString,
 				Computed:    true,
-				Description: "The IP address reported by VMware tools.",
+				Description: "The IP address reported by VMware tools, chosen by preferred_ip_cidrs.",
+			},
+			"ip_addresses": &schema.Schema{
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Every IP address reported by VMware tools, the guest's network interfaces first.",
+			},
+			"preferred_ip_cidrs": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: validation.CIDRNetwork(0, 128)},
+				Description: "Networks to choose ip_address from, in order of preference.  Default is the first IPv4 address.",
 			},
 			"virtual_disks": &schema.Schema{
 				Type:     schema.TypeList,
@@ -115,7 +145,53 @@ func dataSourceGuest() *schema.Resource {
 						"slot": &schema.Schema{
 							Type:        schema.TypeString,
 							Computed:    true,
-							Description: "SCSI slot (e.g., 0:1).",
+							Description: "Controller and unit (e.g., scsi0:1).",
+						},
+					},
+				},
+			},
+			"cdrom": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"iso_path": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "ISO path, empty for an empty drive.",
+						},
+						"controller_type": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Controller type, ide or sata.",
+						},
+						"connected": &schema.Schema{
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "Cdrom is connected.",
+						},
+						"start_connected": &schema.Schema{
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "Cdrom connects at power on.",
+						},
+					},
+				},
+			},
+			"controllers": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Controller type.",
+						},
+						"bus_number": &schema.Schema{
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Controller number on its bus.",
 						},
 					},
 				},
@@ -378,8 +454,8 @@ func dataSourceGuestRead(d *schema.ResourceData, m interface{}) error {
 	// Step 2: Read VM data (reuse existing function)
 	// Pass guest_startup_timeout=0 for data sources (don't wait for IP)
 	guest_name, disk_store, disk_size, boot_disk_type, resource_pool_name,
-		memsize, numvcpus, virthwver, guestos, ip_address, virtual_networks,
-		boot_firmware, virtual_disks, power, notes, guestinfo, err :=
+		memsize, numvcpus, virthwver, guestos, ips, virtual_networks,
+		boot_firmware, virtual_disks, power, notes, guestinfo, doc, err :=
 		guestREAD(c, vmid, 0)
 
 	if err != nil || guest_name == "" {
@@ -399,7 +475,12 @@ func dataSourceGuestRead(d *schema.ResourceData, m interface{}) error {
 	d.Set("numvcpus", numvcpus)
 	d.Set("virthwver", virthwver)
 	d.Set("guestos", guestos)
-	d.Set("ip_address", ip_address)
+	// Get every IP address (same logic as resourceGUESTRead)
+	for i, nic := range virtual_networks {
+		virtual_networks[i].IPAddresses = ips.ByMAC[strings.ToLower(nic.ActiveMacAddress)]
+	}
+	d.Set("ip_address", ips.preferred(guestPreferredIPCidrs(d)))
+	d.Set("ip_addresses", ips.All)
 	d.Set("power", power)
 	d.Set("notes", notes)
 	d.Set("boot_firmware", boot_firmware)
@@ -408,21 +489,11 @@ func dataSourceGuestRead(d *schema.ResourceData, m interface{}) error {
 	}
 
 	// Process network interfaces (same logic as resourceGUESTRead)
-	log.Printf("virtual_networks: %q\n", virtual_networks)
-	nics := make([]map[string]interface{}, 0, 1)
-
-	if virtual_networks[0][0] == "" {
-		nics = nil
-	}
-
-	for nic := 0; nic < 10; nic++ {
-		if virtual_networks[nic][0] != "" {
-			out := make(map[string]interface{})
-			out["virtual_network"] = virtual_networks[nic][0]
-			out["mac_address"] = virtual_networks[nic][1]
-			out["nic_type"] = virtual_networks[nic][2]
-			nics = append(nics, out)
-		}
+	log.Printf("virtual_networks: %+v\n", virtual_networks)
+	nics := guestNICsToResourceData(virtual_networks)
+	for i, nic := range virtual_networks {
+		//  Report generated MAC addresses too.
+		nics[i]["mac_address"] = nic.ActiveMacAddress
 	}
 	d.Set("network_interfaces", nics)
 
@@ -444,6 +515,20 @@ func dataSourceGuestRead(d *schema.ResourceData, m interface{}) error {
 	}
 	d.Set("virtual_disks", vdisks)
 
+	cdroms := guestCdromsFromVmx(doc)
+	if power == "on" {
+		if err := guestGetCdromConnected(c, vmid, cdroms); err != nil {
+			log.Printf("[dataSourceGuestRead] Warning: %s", err)
+		}
+	}
+	out := guestCdromsToResourceData(cdroms)
+	for i := range out {
+		delete(out[i], "local_iso_path")
+	}
+	d.Set("cdrom", out)
+
+	d.Set("controllers", guestControllersToResourceData(guestControllersFromVmx(doc)))
+
 	// Read device info
 	deviceInfo, err := guestReadDevices(c, vmid)
 	if err != nil {
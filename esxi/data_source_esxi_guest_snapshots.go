@@ -0,0 +1,109 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataSourceGuestSnapshots() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceGuestSnapshotsRead,
+
+		Schema: map[string]*schema.Schema{
+			"guest_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The VM ID of the guest whose snapshots to list.",
+			},
+			"current_snapshot_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The snapshot the guest is currently running from.",
+			},
+			"snapshots": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Snapshot ID.",
+						},
+						"name": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Snapshot name.",
+						},
+						"description": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Snapshot description.",
+						},
+						"parent_id": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Parent snapshot ID, empty for a root snapshot.",
+						},
+						"create_time": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Snapshot creation time (RFC3339).",
+						},
+						"power_state": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Guest power state when the snapshot was taken.",
+						},
+						"quiesced": &schema.Schema{
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "Whether the guest file systems were quiesced.",
+						},
+						"size": &schema.Schema{
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Snapshot size in bytes.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceGuestSnapshotsRead(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[dataSourceGuestSnapshotsRead]")
+
+	guest_id := d.Get("guest_id").(string)
+
+	snapshots, current, err := guestSnapshotList(c, guest_id)
+	if err != nil {
+		return fmt.Errorf("Failed to list snapshots of guest '%s': %s", guest_id, err)
+	}
+
+	list := make([]map[string]interface{}, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		list = append(list, map[string]interface{}{
+			"id":          snapshot.ID,
+			"name":        snapshot.Name,
+			"description": snapshot.Description,
+			"parent_id":   snapshot.ParentID,
+			"create_time": snapshot.CreateTime.Format(time.RFC3339),
+			"power_state": snapshot.PowerState,
+			"quiesced":    snapshot.Quiesced,
+			"size":        snapshot.Size,
+		})
+	}
+
+	d.SetId(guest_id)
+	d.Set("current_snapshot_id", current)
+	d.Set("snapshots", list)
+
+	log.Printf("[dataSourceGuestSnapshotsRead] Found %d snapshots of guest '%s'", len(snapshots), guest_id)
+	return nil
+}
@@ -81,6 +81,26 @@ func dataSourceEsxiHost() *schema.Resource {
 				Computed:    true,
 				Description: "Total memory size in MB.",
 			},
+			"numa_nodes": &schema.Schema{
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of NUMA nodes.",
+			},
+			"nested_hv_supported": &schema.Schema{
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "The host can run nested hypervisors.",
+			},
+			"vpmc_supported": &schema.Schema{
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "The host can expose cpu performance counters to guests.",
+			},
+			"latency_sensitivity_supported": &schema.Schema{
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "The host supports guest latency sensitivity.",
+			},
 			"datastores": &schema.Schema{
 				Type:     schema.TypeList,
 				Computed: true,
@@ -362,6 +382,23 @@ func dataSourceEsxiHostReadGovmomi(d *schema.ResourceData, c *Config) error {
 		}
 	}
 
+	// Capabilities checked by esxi_guest cpu settings
+	capabilities, err := getHostCapabilities(c)
+	if err != nil {
+		log.Printf("[dataSourceEsxiHostReadGovmomi] Warning: %s", err)
+	} else {
+		d.Set("numa_nodes", capabilities.NumaNodes)
+		if capabilities.NestedHVSupported != nil {
+			d.Set("nested_hv_supported", *capabilities.NestedHVSupported)
+		}
+		if capabilities.VPMCSupported != nil {
+			d.Set("vpmc_supported", *capabilities.VPMCSupported)
+		}
+		if capabilities.LatencySensitivitySupported != nil {
+			d.Set("latency_sensitivity_supported", *capabilities.LatencySensitivitySupported)
+		}
+	}
+
 	// Retrieve datastores using Finder (established pattern from govmomi_helpers_test.go)
 	var datastores []map[string]interface{}
 	dsList, err := gc.Finder.DatastoreList(ctx, "*")
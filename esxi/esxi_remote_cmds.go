@@ -91,12 +91,31 @@ func runRemoteSshCommand(esxiConnInfo ConnectionStruct, remoteSshCommand string,
 		return "Failed to ssh to esxi host or Management Agent has been restarted", err
 	}
 
-	log.Printf("[runRemoteSshCommand] cmd:/%s/\n stdout:/%s/\nstderr:/%s/\n", remoteSshCommand, stdout, err)
+	log.Printf("[runRemoteSshCommand] cmd:/%s/\n stdout:/%s/\nstderr:/%s/\n", remoteSshCommand, redactGuestinfo(stdout), err)
 
 	client.Close()
 	return stdout, err
 }
 
+//  Function to scp a local file to esxi host.
+func copyFileToRemote(esxiConnInfo ConnectionStruct, localPath string, path string, shortCmdDesc string) error {
+	log.Println("[copyFileToRemote] :" + shortCmdDesc)
+
+	client, session, err := connectToHost(esxiConnInfo, 10)
+	if err != nil {
+		log.Println("[copyFileToRemote] Failed err: " + err.Error())
+		return err
+	}
+	defer client.Close()
+
+	err = scp.CopyPath(localPath, path, session)
+	if err != nil {
+		log.Println("[copyFileToRemote] Failed err: " + err.Error())
+		return err
+	}
+	return nil
+}
+
 //  Function to scp file to esxi host.
 func writeContentToRemoteFile(esxiConnInfo ConnectionStruct, content string, path string, shortCmdDesc string) (string, error) {
 	log.Println("[writeContentToRemoteFile] :" + shortCmdDesc)
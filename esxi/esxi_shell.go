@@ -0,0 +1,33 @@
+package esxi
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Characters that never need quoting in the ESXi busybox shell.
+var shellSafeRe = regexp.MustCompile(`^[A-Za-z0-9_./:=@%+,-]+$`)
+
+// shellQuote quotes s so the ESXi busybox shell passes it to a command as a
+// single literal argument.  Inside single quotes nothing is expanded, so an
+// embedded single quote is closed, escaped and reopened.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if shellSafeRe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellCommand builds a command line from a program name and its arguments,
+// quoting every argument.  Pipelines are built by joining commands with " | ".
+func shellCommand(name string, args ...string) string {
+	words := make([]string, 0, len(args)+1)
+	words = append(words, shellQuote(name))
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,67 @@
+package esxi
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestShellQuote verifies quoting of safe and hostile arguments
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"":                        "''",
+		"vmsvc/power.on":          "vmsvc/power.on",
+		"/vmfs/volumes/ds1/vm1":   "/vmfs/volumes/ds1/vm1",
+		"My Datastore":            "'My Datastore'",
+		"it's":                    `'it'\''s'`,
+		`$(reboot)`:               `'$(reboot)'`,
+		`a"b`:                     `'a"b'`,
+		"web.*01":                 "'web.*01'",
+		"line1\nline2":            "'line1\nline2'",
+		"; rm -fr /vmfs/volumes/": "'; rm -fr /vmfs/volumes/'",
+	}
+
+	for in, expected := range tests {
+		if got := shellQuote(in); got != expected {
+			t.Errorf("shellQuote(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
+
+// TestShellCommand verifies every argument is quoted
+func TestShellCommand(t *testing.T) {
+	got := shellCommand("vim-cmd", "solo/registervm", "/vmfs/volumes/My DS/vm 1/vm 1.vmx", "vm 1", "ha-root-pool")
+	expected := "vim-cmd solo/registervm '/vmfs/volumes/My DS/vm 1/vm 1.vmx' 'vm 1' ha-root-pool"
+	if got != expected {
+		t.Errorf("shellCommand = %q, expected %q", got, expected)
+	}
+}
+
+// FuzzShellQuote proves a quoted argument reaches the command unchanged
+func FuzzShellQuote(f *testing.F) {
+	for _, seed := range []string{
+		"vm1", "My VM", "it's", `"quoted"`, "$HOME", "`id`", "$(id)", "a;b", "a|b", "a&b",
+		"web.*01", "[ds1] vm/vm.vmx", "back\\slash", "glob*?", "tab\there", "new\nline", "-n", "%s",
+	} {
+		f.Add(seed)
+	}
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		f.Skip("sh not available")
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		if strings.ContainsRune(s, 0) {
+			t.Skip("arguments cannot contain NUL")
+		}
+
+		out, err := exec.Command(sh, "-c", shellCommand("printf", "%s", s)).Output()
+		if err != nil {
+			t.Fatalf("command failed for %q: %s", s, err)
+		}
+		if string(out) != s {
+			t.Errorf("round-trip mismatch: sent %q, got %q", s, string(out))
+		}
+	})
+}
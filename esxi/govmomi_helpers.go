@@ -9,6 +9,8 @@ import (
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
@@ -40,6 +42,48 @@ func getVMByID(gc *GovmomiClient, id string) (*object.VirtualMachine, error) {
 	return object.NewVirtualMachine(gc.Client.Client, moRef), nil
 }
 
+// listVirtualMachines returns the given properties of every VM on the host
+func listVirtualMachines(ctx context.Context, client *vim25.Client, props []string) ([]mo.VirtualMachine, error) {
+	m := view.NewManager(client)
+	v, err := m.CreateContainerView(ctx, client.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container view: %w", err)
+	}
+	defer v.Destroy(ctx)
+
+	var vms []mo.VirtualMachine
+	err = v.Retrieve(ctx, []string{"VirtualMachine"}, props, &vms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list virtual machines: %w", err)
+	}
+	return vms, nil
+}
+
+// listDVSPortgroups returns the given properties of every distributed port group the
+// host is connected to
+func listDVSPortgroups(ctx context.Context, client *vim25.Client, props []string) ([]mo.DistributedVirtualPortgroup, error) {
+	m := view.NewManager(client)
+	v, err := m.CreateContainerView(ctx, client.ServiceContent.RootFolder, []string{"DistributedVirtualPortgroup"}, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container view: %w", err)
+	}
+	defer v.Destroy(ctx)
+
+	var portgroups []mo.DistributedVirtualPortgroup
+	err = v.Retrieve(ctx, []string{"DistributedVirtualPortgroup"}, props, &portgroups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list distributed port groups: %w", err)
+	}
+	return portgroups, nil
+}
+
+// unescapeEntityName reverses the escaping vSphere applies to '%', '/' and '\' in
+// managed entity names
+func unescapeEntityName(name string) string {
+	r := strings.NewReplacer("%2f", "/", "%2F", "/", "%5c", "\\", "%5C", "\\", "%25", "%")
+	return r.Replace(name)
+}
+
 // getPowerState returns the current power state of a VM
 func getPowerState(ctx context.Context, vm *object.VirtualMachine) (types.VirtualMachinePowerState, error) {
 	var mo mo.VirtualMachine
@@ -50,6 +94,17 @@ func getPowerState(ctx context.Context, vm *object.VirtualMachine) (types.Virtua
 	return mo.Runtime.PowerState, nil
 }
 
+// getConnectionState returns the connection state of a VM.  A VM whose vmx
+// cannot be loaded is reported as invalid.
+func getConnectionState(ctx context.Context, vm *object.VirtualMachine) (types.VirtualMachineConnectionState, error) {
+	var mo mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"runtime.connectionState"}, &mo)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connection state: %w", err)
+	}
+	return mo.Runtime.ConnectionState, nil
+}
+
 // getGuestIPAddress retrieves the IP address from VMware Tools
 func getGuestIPAddress(ctx context.Context, vm *object.VirtualMachine) (string, error) {
 	var mo mo.VirtualMachine
@@ -65,34 +120,34 @@ func getGuestIPAddress(ctx context.Context, vm *object.VirtualMachine) (string,
 	return mo.Guest.IpAddress, nil
 }
 
-// waitForGuestIPAddress waits for a VM to get an IP address with timeout
-func waitForGuestIPAddress(ctx context.Context, vm *object.VirtualMachine, timeout time.Duration) (string, error) {
+// waitForGuestIPAddresses waits up to timeout for VMware tools to report an IP
+// address.  The IPs reported when it returns are returned, even on timeout.
+func waitForGuestIPAddresses(ctx context.Context, vm *object.VirtualMachine, timeout time.Duration) (guestIPAddresses, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	var mo mo.VirtualMachine
+	guest := &types.GuestInfo{}
+	ips := guestIPAddressesFromGuestInfo(guest)
 	pc := property.DefaultCollector(vm.Client())
 
-	err := property.Wait(ctx, pc, vm.Reference(), []string{"guest.ipAddress"}, func(pc []types.PropertyChange) bool {
+	err := property.Wait(ctx, pc, vm.Reference(), []string{"guest.ipAddress", "guest.net"}, func(pc []types.PropertyChange) bool {
 		for _, c := range pc {
-			if c.Val == nil {
-				continue
-			}
-			if ip, ok := c.Val.(string); ok && ip != "" {
-				mo.Guest = &types.GuestInfo{
-					IpAddress: ip,
-				}
-				return true
+			switch c.Name {
+			case "guest.ipAddress":
+				guest.IpAddress, _ = c.Val.(string)
+			case "guest.net":
+				nics, _ := c.Val.(types.ArrayOfGuestNicInfo)
+				guest.Net = nics.GuestNicInfo
 			}
 		}
-		return false
+		ips = guestIPAddressesFromGuestInfo(guest)
+		return len(ips.All) > 0
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("timeout waiting for IP address: %w", err)
+		return ips, fmt.Errorf("timeout waiting for IP address: %w", err)
 	}
-
-	return mo.Guest.IpAddress, nil
+	return ips, nil
 }
 
 // getDatastoreByName finds a datastore by name
@@ -219,6 +274,19 @@ func getHostNetworkSystem(ctx context.Context, host *object.HostSystem) (*object
 	return ns, nil
 }
 
+// getHostAutoStartManager returns the autostart manager for the host
+func getHostAutoStartManager(ctx context.Context, host *object.HostSystem) (types.ManagedObjectReference, error) {
+	var hostMo mo.HostSystem
+	err := host.Properties(ctx, host.Reference(), []string{"configManager.autoStartManager"}, &hostMo)
+	if err != nil {
+		return types.ManagedObjectReference{}, fmt.Errorf("failed to get autostart manager: %w", err)
+	}
+	if hostMo.ConfigManager.AutoStartManager == nil {
+		return types.ManagedObjectReference{}, fmt.Errorf("host has no autostart manager")
+	}
+	return *hostMo.ConfigManager.AutoStartManager, nil
+}
+
 // getRootResourcePool returns the root resource pool for standalone ESXi
 func getRootResourcePool(ctx context.Context, finder *find.Finder) (*object.ResourcePool, error) {
 	pool, err := finder.DefaultResourcePool(ctx)
@@ -0,0 +1,259 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// guestAdoptState holds the guest settings that are rewritten when an existing guest is adopted.
+type guestAdoptState struct {
+	memsize          string
+	numvcpus         string
+	virthwver        string
+	guestos          string
+	boot_firmware    string
+	notes            string
+	virtual_networks []guestNIC
+	virtual_disks    [60][2]string
+	controllers      []guestController
+	cdroms           []guestCdrom
+	guestinfo        map[string]interface{}
+	extra_config     map[string]interface{}
+}
+
+// guestAdoptionChanges reads an existing guest and returns the changes that adopting it
+// with the given configuration will make.
+func guestAdoptionChanges(c *Config, vmid string, memsize string, numvcpus string, virthwver string,
+	guestos string, boot_firmware string, notes string, virtual_networks []guestNIC,
+	virtual_disks [60][2]string, controllers []guestController,
+	cdroms []guestCdrom, guestinfo map[string]interface{}, extra_config map[string]interface{}) ([]string, error) {
+	log.Printf("[guestAdoptionChanges]\n")
+
+	_, _, _, _, _, cur_memsize, cur_numvcpus, cur_virthwver, cur_guestos, _, cur_virtual_networks,
+		cur_boot_firmware, cur_virtual_disks, _, cur_notes, cur_guestinfo, doc, err := guestREAD(c, vmid, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(extra_config))
+	for key := range extra_config {
+		keys = append(keys, key)
+	}
+	cur_extra_config := extraConfigFromVmx(doc, keys)
+	cur_controllers := guestControllersFromVmx(doc)
+	cur_cdroms := guestCdromsFromVmx(doc)
+
+	current := guestAdoptState{
+		memsize:          cur_memsize,
+		numvcpus:         cur_numvcpus,
+		virthwver:        cur_virthwver,
+		guestos:          cur_guestos,
+		boot_firmware:    cur_boot_firmware,
+		notes:            cur_notes,
+		virtual_networks: cur_virtual_networks,
+		virtual_disks:    cur_virtual_disks,
+		controllers:      cur_controllers,
+		cdroms:           cur_cdroms,
+		guestinfo:        cur_guestinfo,
+		extra_config:     cur_extra_config,
+	}
+	desired := guestAdoptState{
+		memsize:          memsize,
+		numvcpus:         numvcpus,
+		virthwver:        virthwver,
+		guestos:          guestos,
+		boot_firmware:    boot_firmware,
+		notes:            notes,
+		virtual_networks: virtual_networks,
+		virtual_disks:    virtual_disks,
+		controllers:      controllers,
+		cdroms:           cdroms,
+		guestinfo:        guestinfo,
+		extra_config:     extra_config,
+	}
+
+	return current.changesTo(desired), nil
+}
+
+// guestAdoptCustomizeDiff plans adopt_changes, the changes a create with
+// on_conflict adopt makes to the existing guest, so they're shown before the
+// guest is powered off and reconfigured.
+func guestAdoptCustomizeDiff(d *schema.ResourceDiff, c *Config) error {
+	if d.Get("on_conflict").(string) != "adopt" || !d.NewValueKnown("guest_name") {
+		return d.SetNew("adopt_changes", []string{})
+	}
+
+	guest_name := d.Get("guest_name").(string)
+	vmid, err := guestGetVMID(c, guest_name)
+	if err != nil {
+		return fmt.Errorf("Failed to check if guest already exists: %s\n", err)
+	}
+	if vmid == "" {
+		return d.SetNew("adopt_changes", []string{})
+	}
+
+	virtual_networks, err := guestNICsFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	virtual_disks, err := guestDisksFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	controllers, err := guestControllersFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	cdroms, err := guestCdromsFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	guestinfo, _, err := guestinfoFromResourceData(d)
+	if err != nil {
+		return err
+	}
+
+	changes, err := guestAdoptionChanges(c, vmid, d.Get("memsize").(string), d.Get("numvcpus").(string),
+		d.Get("virthwver").(string), d.Get("guestos").(string), d.Get("boot_firmware").(string), d.Get("notes").(string),
+		virtual_networks, virtual_disks, controllers, cdroms, guestinfo, d.Get("extra_config").(map[string]interface{}))
+	if err != nil {
+		return fmt.Errorf("Failed to compare existing guest %s: %s\n", guest_name, err)
+	}
+	log.Printf("[guestAdoptCustomizeDiff] adopt %s (vmid: %s): %v\n", guest_name, vmid, changes)
+	if changes == nil {
+		changes = []string{}
+	}
+	return d.SetNew("adopt_changes", changes)
+}
+
+// changesTo lists the differences between the current and desired state.  Empty desired
+// values are left unchanged by updateVmx_contents, so they are not reported.
+func (cur guestAdoptState) changesTo(want guestAdoptState) []string {
+	var changes []string
+
+	scalar := func(name, from, to string) {
+		if to != "" && from != to {
+			changes = append(changes, fmt.Sprintf("%s: %q => %q", name, from, to))
+		}
+	}
+	scalar("memsize", cur.memsize, want.memsize)
+	scalar("numvcpus", cur.numvcpus, want.numvcpus)
+	scalar("virthwver", cur.virthwver, want.virthwver)
+	scalar("guestos", cur.guestos, want.guestos)
+	scalar("boot_firmware", cur.boot_firmware, want.boot_firmware)
+	scalar("notes", cur.notes, want.notes)
+
+	//  All network interfaces are rebuilt on create.
+	for i := 0; i < len(cur.virtual_networks) || i < len(want.virtual_networks); i++ {
+		switch {
+		case i >= len(want.virtual_networks):
+			changes = append(changes, fmt.Sprintf("network_interfaces.%d: remove %q", i, cur.virtual_networks[i].VirtualNetwork))
+		case i >= len(cur.virtual_networks):
+			changes = append(changes, fmt.Sprintf("network_interfaces.%d: add %q", i, want.virtual_networks[i].VirtualNetwork))
+		default:
+			from, to := cur.virtual_networks[i], want.virtual_networks[i]
+			scalar(fmt.Sprintf("network_interfaces.%d.virtual_network", i), from.VirtualNetwork, to.VirtualNetwork)
+			//  An empty mac_address switches a static MAC to a generated one.
+			if !strings.EqualFold(from.MacAddress, to.MacAddress) {
+				changes = append(changes, fmt.Sprintf("network_interfaces.%d.mac_address: %q => %q", i, from.MacAddress, to.MacAddress))
+			}
+			scalar(fmt.Sprintf("network_interfaces.%d.nic_type", i), from.NicType, to.NicType)
+			scalar(fmt.Sprintf("network_interfaces.%d.start_connected", i), fmt.Sprint(from.StartConnected), fmt.Sprint(to.StartConnected))
+		}
+	}
+
+	//  Additional disks are detached unless they are in the config.
+	wantDisks := make(map[string]string)
+	for i := 0; i < 60; i++ {
+		if want.virtual_disks[i][0] != "" {
+			wantDisks[want.virtual_disks[i][1]] = want.virtual_disks[i][0]
+		}
+	}
+	for i := 0; i < 60; i++ {
+		disk, slot := cur.virtual_disks[i][0], cur.virtual_disks[i][1]
+		if disk == "" {
+			continue
+		}
+		if wantDisks[slot] != disk {
+			changes = append(changes, fmt.Sprintf("virtual_disks: detach %q from slot %s", disk, slot))
+		}
+	}
+	for i := 0; i < 60; i++ {
+		disk, slot := want.virtual_disks[i][0], want.virtual_disks[i][1]
+		if disk == "" {
+			continue
+		}
+		attached := false
+		for j := 0; j < 60; j++ {
+			if cur.virtual_disks[j][0] == disk && cur.virtual_disks[j][1] == slot {
+				attached = true
+			}
+		}
+		if !attached {
+			changes = append(changes, fmt.Sprintf("virtual_disks: attach %q to slot %s", disk, slot))
+		}
+	}
+
+	//  Controllers are only added or changed, never removed.
+	for _, ctrl := range want.controllers {
+		from := ""
+		for _, cur_ctrl := range cur.controllers {
+			if cur_ctrl.name() == ctrl.name() {
+				from = cur_ctrl.Type
+			}
+		}
+		scalar("controllers."+ctrl.name(), from, ctrl.Type)
+	}
+
+	//  Cdroms are only changed if any are configured.
+	for i := 0; i < len(want.cdroms) || (len(want.cdroms) > 0 && i < len(cur.cdroms)); i++ {
+		switch {
+		case i >= len(want.cdroms):
+			changes = append(changes, fmt.Sprintf("cdrom.%d: remove %s", i, cur.cdroms[i].Slot))
+		case i >= len(cur.cdroms):
+			changes = append(changes, fmt.Sprintf("cdrom.%d: add %s %q", i, want.cdroms[i].ControllerType, want.cdroms[i].IsoPath+want.cdroms[i].LocalIsoPath))
+		default:
+			from, to := cur.cdroms[i], want.cdroms[i]
+			scalar(fmt.Sprintf("cdrom.%d.controller_type", i), from.ControllerType, to.ControllerType)
+			if to.LocalIsoPath != "" {
+				changes = append(changes, fmt.Sprintf("cdrom.%d.local_iso_path: upload %q", i, to.LocalIsoPath))
+			} else if from.IsoPath != to.IsoPath {
+				changes = append(changes, fmt.Sprintf("cdrom.%d.iso_path: %q => %q", i, from.IsoPath, to.IsoPath))
+			}
+			scalar(fmt.Sprintf("cdrom.%d.start_connected", i), fmt.Sprint(from.StartConnected), fmt.Sprint(to.StartConnected))
+		}
+	}
+
+	keys := make([]string, 0, len(want.guestinfo))
+	for k := range want.guestinfo {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		to := fmt.Sprint(want.guestinfo[k])
+		if from, ok := cur.guestinfo[k]; !ok || fmt.Sprint(from) != to {
+			changes = append(changes, fmt.Sprintf("guestinfo.%s: set", k))
+		}
+	}
+
+	keys = keys[:0]
+	for k := range want.extra_config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		from, ok := cur.extra_config[k]
+		if !ok {
+			from = ""
+		}
+		if fmt.Sprint(from) != fmt.Sprint(want.extra_config[k]) {
+			changes = append(changes, fmt.Sprintf("extra_config.%s: %q => %q", k, fmt.Sprint(from), fmt.Sprint(want.extra_config[k])))
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,53 @@
+package esxi
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestGuestAdoptStateChangesTo verifies the adoption report for an existing guest
+func TestGuestAdoptStateChangesTo(t *testing.T) {
+	current := guestAdoptState{
+		memsize:       "512",
+		numvcpus:      "1",
+		virthwver:     "13",
+		guestos:       "centos-64",
+		boot_firmware: "bios",
+		notes:         "hand built",
+		guestinfo:     map[string]interface{}{"userdata": "old"},
+	}
+	current.virtual_networks = []guestNIC{
+		{VirtualNetwork: "VM Network", NicType: "e1000", StartConnected: true},
+		{VirtualNetwork: "Backup", NicType: "e1000", StartConnected: true},
+	}
+	current.virtual_disks[0] = [2]string{"/vmfs/volumes/ds1/data/data.vmdk", "0:1"}
+
+	desired := guestAdoptState{
+		memsize:       "1024",
+		boot_firmware: "bios",
+		guestinfo:     map[string]interface{}{"userdata": "new", "metadata": "m"},
+	}
+	desired.virtual_networks = []guestNIC{
+		{VirtualNetwork: "VM Network", NicType: "vmxnet3", StartConnected: true},
+	}
+	desired.virtual_disks[0] = [2]string{"/vmfs/volumes/ds1/data/data.vmdk", "0:2"}
+
+	expected := []string{
+		`memsize: "512" => "1024"`,
+		`network_interfaces.0.nic_type: "e1000" => "vmxnet3"`,
+		`network_interfaces.1: remove "Backup"`,
+		`virtual_disks: detach "/vmfs/volumes/ds1/data/data.vmdk" from slot 0:1`,
+		`virtual_disks: attach "/vmfs/volumes/ds1/data/data.vmdk" to slot 0:2`,
+		`guestinfo.metadata: set`,
+		`guestinfo.userdata: set`,
+	}
+
+	changes := current.changesTo(desired)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes:\n got: %q\nwant: %q", changes, expected)
+	}
+
+	if changes := current.changesTo(current); len(changes) != 0 {
+		t.Errorf("expected no changes adopting an identical guest, got %q", changes)
+	}
+}
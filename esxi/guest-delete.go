@@ -3,6 +3,7 @@ package esxi
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
@@ -10,35 +11,88 @@ import (
 
 func resourceGUESTDelete(d *schema.ResourceData, m interface{}) error {
 	c := m.(*Config)
-	esxiConnInfo := getConnectionInfo(c)
 	log.Println("[resourceGUESTDelete]")
 
+	vmid := d.Id()
+	guest_shutdown_timeout := d.Get("guest_shutdown_timeout").(int)
+	shutdown_behavior := d.Get("shutdown_behavior").(string)
+
+	var virtual_disk_ids []string
+	for _, disk := range d.Get("virtual_disks").([]interface{}) {
+		if disk, ok := disk.(map[string]interface{}); ok {
+			virtual_disk_ids = append(virtual_disk_ids, disk["virtual_disk_id"].(string))
+		}
+	}
+
+	err := guestDESTROY(c, vmid, guest_shutdown_timeout, shutdown_behavior, virtual_disk_ids)
+	if err != nil {
+		return err
+	}
+
+	d.SetId("")
+
+	return nil
+}
+
+// guestDESTROY powers off and destroys a guest.  Additional storage is removed from the
+// vmx first, so only the guest's own files are deleted.  Disks built on virtual_disk_ids
+// are never deleted.
+func guestDESTROY(c *Config, vmid string, guest_shutdown_timeout int, shutdown_behavior string, virtual_disk_ids []string) error {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Println("[guestDESTROY]")
+
 	var remote_cmd, stdout string
 	var err error
 
-	vmid := d.Id()
-	guest_shutdown_timeout := d.Get("guest_shutdown_timeout").(int)
+	//  Linked clones are built on this guest's disks.
+	clones, err := guestDirLinkedClones(c, vmid)
+	if err != nil {
+		return fmt.Errorf("Failed to check for linked clones: %s\n", err)
+	}
+	if len(clones) > 0 {
+		return fmt.Errorf("Failed to destroy vm: guest has linked clones: %s\n", strings.Join(clones, ", "))
+	}
 
-	_, err = guestPowerOff(c, vmid, guest_shutdown_timeout)
+	_, err = guestPowerOff(c, vmid, guest_shutdown_timeout, shutdown_behavior)
 	if err != nil {
 		return fmt.Errorf("Failed to power off: %s\n", err)
 	}
 
-	// remove storage from vmx so it doesn't get deleted by the vim-cmd destroy
-	err = cleanStorageFromVmx(c, vmid)
+	//  A linked clone's delta disks are detached and deleted on their own, so the
+	//  vim-cmd destroy can't touch the disks they are built on.
+	deltas, err := guestDeltaDisks(c, vmid, virtual_disk_ids)
 	if err != nil {
-		log.Printf("[resourceGUESTDelete] Failed clean storage from vmid: %s (to be deleted)\n", vmid)
+		return fmt.Errorf("Failed to read guest disks: %s\n", err)
+	}
+	if len(deltas) > 0 {
+		err = guestDetachDisks(c, vmid)
+		if err != nil {
+			return fmt.Errorf("Failed to detach delta disks: %s\n", err)
+		}
+		for _, delta := range deltas {
+			err = removeRemoteDisk(c, delta)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// remove storage from vmx so it doesn't get deleted by the vim-cmd destroy.
+	// No vmx backups are kept, so they don't stop the guest's directory being removed.
+	// The destroy is aborted if that fails, so attached virtual disks are never deleted.
+	err = cleanStorageFromVmx(c, vmid, 0)
+	if err != nil {
+		log.Printf("[guestDESTROY] Failed clean storage from vmid: %s\n", vmid)
+		return fmt.Errorf("Failed to remove storage from vmx, not destroying vm: %s\n", err)
 	}
 
 	time.Sleep(5 * time.Second)
-	remote_cmd = fmt.Sprintf("vim-cmd vmsvc/destroy %s", vmid)
+	remote_cmd = shellCommand("vim-cmd", "vmsvc/destroy", vmid)
 	stdout, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/destroy")
 	if err != nil {
-		log.Printf("[resourceGUESTDelete] Failed destroy vmid: %s\n", stdout)
+		log.Printf("[guestDESTROY] Failed destroy vmid: %s\n", stdout)
 		return fmt.Errorf("Failed to destroy vm: %s\n", err)
 	}
 
-	d.SetId("")
-
 	return nil
 }
@@ -0,0 +1,42 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTEXECCreate(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTEXECCreate]")
+
+	guest_id := d.Get("guest_id").(string)
+
+	env := make(map[string]string)
+	for name, value := range d.Get("environment").(map[string]interface{}) {
+		env[name] = value.(string)
+	}
+
+	client, err := guestOperationsClient(c, guest_id, d.Get("username").(string), d.Get("password").(string))
+	if err != nil {
+		return fmt.Errorf("Failed to run command: %s\n", err)
+	}
+	result, err := guestExecRun(client, d.Get("command").(string), env, d.Get("working_directory").(string), d.Get("timeout").(int))
+	if err != nil {
+		return fmt.Errorf("Failed to run command: %s\n", err)
+	}
+
+	if result.ExitCode != 0 && d.Get("fail_on_error").(bool) {
+		return fmt.Errorf("Command exited with %d: %s\n", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+
+	//  The command runs once, the id is its guest process.
+	d.SetId(fmt.Sprintf("%s/%d", guest_id, result.Pid))
+	d.Set("exit_code", result.ExitCode)
+	d.Set("stdout", result.Stdout)
+	d.Set("stderr", result.Stderr)
+
+	return resourceGUESTEXECRead(d, m)
+}
@@ -0,0 +1,15 @@
+package esxi
+
+import (
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTEXECDelete(d *schema.ResourceData, m interface{}) error {
+	log.Println("[resourceGUESTEXECDelete]")
+
+	//  Nothing to undo in the guest.
+	d.SetId("")
+	return nil
+}
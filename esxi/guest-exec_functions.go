@@ -0,0 +1,152 @@
+package esxi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/vmware/govmomi/guest/toolbox"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ============================================================================
+// Guest Operations (VMware Tools)
+// ============================================================================
+
+// guestExecPollInterval is how often a running command is checked
+var guestExecPollInterval = 500 * time.Millisecond
+
+// guestExecResult is the outcome of a command run in a guest
+type guestExecResult struct {
+	Pid      int64
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// guestOperationsClient returns a client for the guest operations of a guest,
+// authenticated with the guest credentials.  VMware tools must be running.
+func guestOperationsClient(c *Config, vmid, username, password string) (*toolbox.Client, error) {
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+	if !guestToolsRunning(c, vmid) {
+		return nil, fmt.Errorf("VMware tools are not running on guest %s", vmid)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &types.NamePasswordAuthentication{Username: username, Password: password}
+	client, err := toolbox.NewClient(gc.Context(), gc.Client.Client, vm.Reference(), auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start guest operations: %w", err)
+	}
+	return client, nil
+}
+
+// guestExecSpec returns the program spec that runs command with the guest's
+// shell, writing its output to the stdout and stderr files.  VMware tools
+// start programs through the shell, so the redirections are arguments.
+func guestExecSpec(family types.VirtualMachineGuestOsFamily, command string, env map[string]string, dir, stdout, stderr string) *types.GuestProgramSpec {
+	spec := &types.GuestProgramSpec{WorkingDirectory: dir}
+
+	if family == types.VirtualMachineGuestOsFamilyWindowsGuest {
+		spec.ProgramPath = `C:\Windows\System32\cmd.exe`
+		spec.Arguments = fmt.Sprintf(`/c %s 1> "%s" 2> "%s"`, command, stdout, stderr)
+	} else {
+		spec.ProgramPath = "/bin/sh"
+		spec.Arguments = fmt.Sprintf("-c %s 1> %s 2> %s", shellQuote(command), shellQuote(stdout), shellQuote(stderr))
+	}
+
+	for name, value := range env {
+		spec.EnvVariables = append(spec.EnvVariables, name+"="+value)
+	}
+	sort.Strings(spec.EnvVariables)
+	return spec
+}
+
+// guestReadFile returns the contents of a file in the guest
+func guestReadFile(ctx context.Context, client *toolbox.Client, path string) (string, error) {
+	f, _, err := client.Download(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, f); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// guestExecRun runs command in the guest and waits for it to exit.  Its output
+// is captured in temporary files in the guest, which are removed.  A command
+// still running after timeout seconds is terminated.
+func guestExecRun(client *toolbox.Client, command string, env map[string]string, dir string, timeout int) (guestExecResult, error) {
+	//  The command line often carries credentials, so it isn't logged.
+	log.Printf("[guestExecRun]\n")
+
+	var result guestExecResult
+	ctx := context.Background()
+	fm := client.FileManager
+
+	var outputs [2]string
+	for i := range outputs {
+		path, err := fm.CreateTemporaryFile(ctx, client.Authentication, "terraform-", ".out", "")
+		if err != nil {
+			return result, fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer func() {
+			if err := fm.DeleteFile(ctx, client.Authentication, path); err != nil {
+				log.Printf("[guestExecRun] Failed to remove %s: %s\n", path, err)
+			}
+		}()
+		outputs[i] = path
+	}
+
+	spec := guestExecSpec(client.GuestFamily, command, env, dir, outputs[0], outputs[1])
+	log.Printf("[guestExecRun] Starting %s in the guest\n", spec.ProgramPath)
+	pid, err := client.ProcessManager.StartProgram(ctx, client.Authentication, spec)
+	if err != nil {
+		return result, fmt.Errorf("failed to start command: %w", err)
+	}
+	result.Pid = pid
+
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		procs, err := client.ProcessManager.ListProcesses(ctx, client.Authentication, []int64{pid})
+		if err != nil {
+			return result, fmt.Errorf("failed to get command status: %w", err)
+		}
+		if len(procs) == 0 {
+			return result, fmt.Errorf("command %d not found", pid)
+		}
+		if procs[0].EndTime != nil {
+			result.ExitCode = int(procs[0].ExitCode)
+			break
+		}
+		if time.Now().After(deadline) {
+			if err := client.ProcessManager.TerminateProcess(ctx, client.Authentication, pid); err != nil {
+				log.Printf("[guestExecRun] Failed to terminate %d: %s\n", pid, err)
+			}
+			return result, fmt.Errorf("command timed out after %ds", timeout)
+		}
+		time.Sleep(guestExecPollInterval)
+	}
+
+	if result.Stdout, err = guestReadFile(ctx, client, outputs[0]); err != nil {
+		return result, fmt.Errorf("failed to read stdout: %w", err)
+	}
+	if result.Stderr, err = guestReadFile(ctx, client, outputs[1]); err != nil {
+		return result, fmt.Errorf("failed to read stderr: %w", err)
+	}
+	return result, nil
+}
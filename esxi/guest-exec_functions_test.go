@@ -0,0 +1,280 @@
+package esxi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// testGuest stands in for the guest operations of VMware tools, which vcsim
+// runs in docker containers.  Files are kept in memory and served for the
+// transfers, and programs are echoed into their output files.
+type testGuest struct {
+	sync.Mutex
+	server   *httptest.Server
+	password string
+	files    map[string][]byte
+	dirs     []string
+	procs    map[int64]*types.GuestProcessInfo
+}
+
+type testGuestFileManager struct {
+	mo.GuestFileManager
+	guest *testGuest
+}
+
+type testGuestProcessManager struct {
+	mo.GuestProcessManager
+	guest *testGuest
+}
+
+// testGuestRedirectRe matches the shell command of guestExecSpec
+var testGuestRedirectRe = regexp.MustCompile(`^-c (.*) 1> (\S+) 2> (\S+)$`)
+
+// newTestGuest registers the stand-in with the simulator
+func newTestGuest(t *testing.T, model *simulator.Model, password string) *testGuest {
+	g := &testGuest{password: password, files: make(map[string][]byte), procs: make(map[int64]*types.GuestProcessInfo)}
+	g.server = httptest.NewServer(http.HandlerFunc(g.transfer))
+	t.Cleanup(g.server.Close)
+
+	fm := &testGuestFileManager{guest: g}
+	fm.Self = types.ManagedObjectReference{Type: "GuestFileManager", Value: "guestOperationsFileManager"}
+	model.Map().Put(fm)
+
+	pm := &testGuestProcessManager{guest: g}
+	pm.Self = types.ManagedObjectReference{Type: "GuestProcessManager", Value: "guestOperationsProcessManager"}
+	model.Map().Put(pm)
+	return g
+}
+
+func (g *testGuest) transfer(w http.ResponseWriter, r *http.Request) {
+	g.Lock()
+	defer g.Unlock()
+
+	path := r.URL.Query().Get("path")
+	switch r.Method {
+	case http.MethodPut:
+		g.files[path], _ = io.ReadAll(r.Body)
+	case http.MethodGet:
+		w.Write(g.files[path])
+	}
+}
+
+func (g *testGuest) url(path string) string {
+	return g.server.URL + "/guestFile?" + url.Values{"path": []string{path}}.Encode()
+}
+
+// login checks the guest credentials
+func (g *testGuest) login(auth types.BaseGuestAuthentication) types.BaseMethodFault {
+	if auth.(*types.NamePasswordAuthentication).Password != g.password {
+		return &types.InvalidGuestLogin{}
+	}
+	return nil
+}
+
+func (m *testGuestFileManager) CreateTemporaryFileInGuest(req *types.CreateTemporaryFileInGuest) soap.HasFault {
+	m.guest.Lock()
+	defer m.guest.Unlock()
+	if fault := m.guest.login(req.Auth); fault != nil {
+		return &methods.CreateTemporaryFileInGuestBody{Fault_: simulator.Fault("", fault)}
+	}
+	path := fmt.Sprintf("/tmp/%s%d%s", req.Prefix, len(m.guest.files), req.Suffix)
+	m.guest.files[path] = nil
+	return &methods.CreateTemporaryFileInGuestBody{Res: &types.CreateTemporaryFileInGuestResponse{Returnval: path}}
+}
+
+func (m *testGuestFileManager) DeleteFileInGuest(req *types.DeleteFileInGuest) soap.HasFault {
+	m.guest.Lock()
+	defer m.guest.Unlock()
+	if _, ok := m.guest.files[req.FilePath]; !ok {
+		return &methods.DeleteFileInGuestBody{Fault_: simulator.Fault("", &types.FileNotFound{FileFault: types.FileFault{File: req.FilePath}})}
+	}
+	delete(m.guest.files, req.FilePath)
+	return &methods.DeleteFileInGuestBody{Res: &types.DeleteFileInGuestResponse{}}
+}
+
+func (m *testGuestFileManager) ListFilesInGuest(req *types.ListFilesInGuest) soap.HasFault {
+	m.guest.Lock()
+	defer m.guest.Unlock()
+	content, ok := m.guest.files[req.FilePath]
+	if !ok {
+		return &methods.ListFilesInGuestBody{Fault_: simulator.Fault("", &types.FileNotFound{FileFault: types.FileFault{File: req.FilePath}})}
+	}
+	files := []types.GuestFileInfo{{Path: req.FilePath, Type: "file", Size: int64(len(content))}}
+	return &methods.ListFilesInGuestBody{Res: &types.ListFilesInGuestResponse{Returnval: types.GuestListFileInfo{Files: files}}}
+}
+
+func (m *testGuestFileManager) MakeDirectoryInGuest(req *types.MakeDirectoryInGuest) soap.HasFault {
+	m.guest.Lock()
+	defer m.guest.Unlock()
+	m.guest.dirs = append(m.guest.dirs, req.DirectoryPath)
+	return &methods.MakeDirectoryInGuestBody{Res: &types.MakeDirectoryInGuestResponse{}}
+}
+
+func (m *testGuestFileManager) InitiateFileTransferToGuest(req *types.InitiateFileTransferToGuest) soap.HasFault {
+	if fault := m.guest.login(req.Auth); fault != nil {
+		return &methods.InitiateFileTransferToGuestBody{Fault_: simulator.Fault("", fault)}
+	}
+	return &methods.InitiateFileTransferToGuestBody{Res: &types.InitiateFileTransferToGuestResponse{Returnval: m.guest.url(req.GuestFilePath)}}
+}
+
+func (m *testGuestFileManager) InitiateFileTransferFromGuest(req *types.InitiateFileTransferFromGuest) soap.HasFault {
+	m.guest.Lock()
+	defer m.guest.Unlock()
+	info := types.FileTransferInformation{Size: int64(len(m.guest.files[req.GuestFilePath])), Url: m.guest.url(req.GuestFilePath)}
+	return &methods.InitiateFileTransferFromGuestBody{Res: &types.InitiateFileTransferFromGuestResponse{Returnval: info}}
+}
+
+// StartProgramInGuest echoes the command and its environment to stdout.  A
+// command "fail" exits with 3, and "sleep" never exits.
+func (m *testGuestProcessManager) StartProgramInGuest(req *types.StartProgramInGuest) soap.HasFault {
+	m.guest.Lock()
+	defer m.guest.Unlock()
+
+	spec := req.Spec.(*types.GuestProgramSpec)
+	match := testGuestRedirectRe.FindStringSubmatch(spec.Arguments)
+	if match == nil {
+		return &methods.StartProgramInGuestBody{Fault_: simulator.Fault("", &types.InvalidArgument{InvalidProperty: "arguments"})}
+	}
+
+	pid := int64(len(m.guest.procs) + 100)
+	proc := &types.GuestProcessInfo{Pid: pid, EndTime: types.NewTime(time.Now())}
+	switch match[1] {
+	case "fail":
+		proc.ExitCode = 3
+		m.guest.files[match[3]] = []byte("failed\n")
+	case "sleep":
+		proc.EndTime = nil
+	default:
+		m.guest.files[match[2]] = []byte(match[1] + " " + strings.Join(spec.EnvVariables, " ") + "\n")
+	}
+	m.guest.procs[pid] = proc
+	return &methods.StartProgramInGuestBody{Res: &types.StartProgramInGuestResponse{Returnval: pid}}
+}
+
+func (m *testGuestProcessManager) ListProcessesInGuest(req *types.ListProcessesInGuest) soap.HasFault {
+	m.guest.Lock()
+	defer m.guest.Unlock()
+	res := &types.ListProcessesInGuestResponse{}
+	for _, pid := range req.Pids {
+		if proc, ok := m.guest.procs[pid]; ok {
+			res.Returnval = append(res.Returnval, *proc)
+		}
+	}
+	return &methods.ListProcessesInGuestBody{Res: res}
+}
+
+func (m *testGuestProcessManager) TerminateProcessInGuest(req *types.TerminateProcessInGuest) soap.HasFault {
+	m.guest.Lock()
+	defer m.guest.Unlock()
+	m.guest.procs[req.Pid].EndTime = types.NewTime(time.Now())
+	return &methods.TerminateProcessInGuestBody{Res: &types.TerminateProcessInGuestResponse{}}
+}
+
+// newTestGuestConfig starts the simulator with the guest stand-in and returns
+// the provider config and the id of a running guest.
+func newTestGuestConfig(t *testing.T) (*Config, *testGuest, string) {
+	model := simulator.ESX()
+	t.Cleanup(model.Remove)
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	t.Cleanup(s.Close)
+
+	guest := newTestGuest(t, model, "secret")
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	t.Cleanup(func() { config.CloseGovmomiClient() })
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("Failed to find a guest: %v", err)
+	}
+	vm := model.Map().Get(vms[0].Reference()).(*simulator.VirtualMachine)
+	vm.Guest.ToolsRunningStatus = string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+	return config, guest, vm.Self.Value
+}
+
+// TestGuestExecSpec verifies commands are run with the guest's shell
+func TestGuestExecSpec(t *testing.T) {
+	spec := guestExecSpec("linuxGuest", "echo 'hi' > /tmp/x", map[string]string{"B": "2", "A": "1"}, "/root", "/tmp/out", "/tmp/err")
+	if spec.ProgramPath != "/bin/sh" || spec.Arguments != `-c 'echo '\''hi'\'' > /tmp/x' 1> /tmp/out 2> /tmp/err` {
+		t.Errorf("Unexpected linux spec %s %s", spec.ProgramPath, spec.Arguments)
+	}
+	if strings.Join(spec.EnvVariables, " ") != "A=1 B=2" || spec.WorkingDirectory != "/root" {
+		t.Errorf("Unexpected environment %q in %q", spec.EnvVariables, spec.WorkingDirectory)
+	}
+
+	spec = guestExecSpec(types.VirtualMachineGuestOsFamilyWindowsGuest, "dir C:\\", nil, "", `C:\Temp\out`, `C:\Temp\err`)
+	if spec.ProgramPath != `C:\Windows\System32\cmd.exe` || spec.Arguments != `/c dir C:\ 1> "C:\Temp\out" 2> "C:\Temp\err"` {
+		t.Errorf("Unexpected windows spec %s %s", spec.ProgramPath, spec.Arguments)
+	}
+}
+
+// TestGuestExecRunGovmomi verifies running commands in a guest with the vcsim simulator
+func TestGuestExecRunGovmomi(t *testing.T) {
+	config, guest, vmid := newTestGuestConfig(t)
+	interval := guestExecPollInterval
+	guestExecPollInterval = 10 * time.Millisecond
+	defer func() { guestExecPollInterval = interval }()
+
+	client, err := guestOperationsClient(config, vmid, "root", "secret")
+	if err != nil {
+		t.Fatalf("Failed to get guest operations client: %v", err)
+	}
+
+	result, err := guestExecRun(client, "hostname", map[string]string{"ROLE": "web"}, "", 60)
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if result.ExitCode != 0 || result.Stdout != "hostname ROLE=web\n" || result.Stderr != "" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	result, err = guestExecRun(client, "fail", nil, "", 60)
+	if err != nil || result.ExitCode != 3 || result.Stderr != "failed\n" {
+		t.Errorf("Expected exit code 3, got %+v, %v", result, err)
+	}
+
+	if _, err = guestExecRun(client, "sleep", nil, "", 0); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+
+	//  The output files are removed.
+	if len(guest.files) != 0 {
+		t.Errorf("Expected the output files to be removed, got %d", len(guest.files))
+	}
+
+	client, _ = guestOperationsClient(config, vmid, "root", "wrong")
+	if _, err = guestExecRun(client, "hostname", nil, "", 60); err == nil {
+		t.Error("Expected wrong guest credentials to be rejected")
+	}
+}
@@ -0,0 +1,14 @@
+package esxi
+
+import (
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTEXECRead(d *schema.ResourceData, m interface{}) error {
+	log.Println("[resourceGUESTEXECRead]")
+
+	//  The command's results are only in the state.
+	return nil
+}
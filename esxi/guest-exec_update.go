@@ -0,0 +1,15 @@
+package esxi
+
+import (
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTEXECUpdate(d *schema.ResourceData, m interface{}) error {
+	log.Println("[resourceGUESTEXECUpdate]")
+
+	//  Only the credentials, timeout and fail_on_error can change in place.
+	//  They are used the next time the command runs.
+	return resourceGUESTEXECRead(d, m)
+}
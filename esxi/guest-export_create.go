@@ -0,0 +1,39 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTEXPORTCreate(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTEXPORTCreate]")
+
+	guest_id := d.Get("guest_id").(string)
+	path := d.Get("path").(string)
+	format := d.Get("format").(string)
+	name := d.Get("name").(string)
+
+	files, err := guestExport(c, guest_id, path, format, name, d.Get("manifest").(bool),
+		d.Get("strip_properties").(bool), d.Get("strip_networks").(bool))
+	if err != nil {
+		d.SetId("")
+		return fmt.Errorf("Failed to export guest: %s\n", err)
+	}
+
+	checksums := make(map[string]string)
+	for _, file := range files {
+		checksums[file.Name] = file.SHA256
+	}
+
+	//  The descriptor is the first file, named after the export.
+	d.SetId(path)
+	d.Set("name", strings.TrimSuffix(files[0].Name, ".ovf"))
+	d.Set("files", exportOutputs(path, format, files))
+	d.Set("checksums", checksums)
+
+	return resourceGUESTEXPORTRead(d, m)
+}
@@ -0,0 +1,34 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTEXPORTDelete(d *schema.ResourceData, m interface{}) error {
+	log.Println("[resourceGUESTEXPORTDelete]")
+
+	if d.Get("keep_on_destroy").(bool) {
+		log.Printf("[resourceGUESTEXPORTDelete] keep_on_destroy is set, keeping %s\n", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	for _, file := range d.Get("files").([]interface{}) {
+		err := os.Remove(file.(string))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to delete exported file: %s\n", err)
+		}
+	}
+
+	//  The ovf directory is removed if the export was all it held.
+	if d.Get("format").(string) == "ovf" {
+		os.Remove(d.Id())
+	}
+
+	d.SetId("")
+	return nil
+}
@@ -0,0 +1,384 @@
+package esxi
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/nfc"
+	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/progress"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ============================================================================
+// Guest Export Operations
+// ============================================================================
+
+// exportFile is a file of an export: the ovf descriptor, its manifest or a disk
+type exportFile struct {
+	Name   string // file name in the ovf directory or ova
+	Path   string // local path
+	Size   int64
+	SHA256 string
+}
+
+var ovfBlankLineRe = regexp.MustCompile(`\n[ \t]*\n`)
+
+// ovfElement is an element of an ovf descriptor, being read by removeOvfElements
+type ovfElement struct {
+	Name     xml.Name
+	Parent   xml.Name
+	Start    int64
+	Ethernet bool // an Item with ResourceType 10
+}
+
+// isOvfEnvelopeName tells if name is in the ovf envelope namespace, of any
+// ovf version, with any prefix
+func isOvfEnvelopeName(name xml.Name, local string) bool {
+	return name.Local == local && strings.HasPrefix(name.Space, "http://schemas.dmtf.org/ovf/envelope/")
+}
+
+// removeOvfElements removes the elements of an ovf descriptor that remove
+// selects.  The descriptor is parsed, so namespaces are matched whatever their
+// prefix, but only the selected elements are cut from it; the rest is kept as
+// it is.
+func removeOvfElements(descriptor string, remove func(element ovfElement) bool) (string, error) {
+	dec := xml.NewDecoder(strings.NewReader(descriptor))
+
+	type span struct{ start, end int64 }
+	var spans []span
+	var stack []ovfElement
+	for {
+		offset := dec.InputOffset()
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse ovf descriptor: %w", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			element := ovfElement{Name: token.Name, Start: offset}
+			if len(stack) > 0 {
+				element.Parent = stack[len(stack)-1].Name
+			}
+			stack = append(stack, element)
+		case xml.CharData:
+			//  The resource type of a hardware item is its ResourceType child.
+			n := len(stack)
+			if n >= 2 && stack[n-1].Name.Local == "ResourceType" && stack[n-2].Name.Local == "Item" &&
+				strings.TrimSpace(string(token)) == "10" {
+				stack[n-2].Ethernet = true
+			}
+		case xml.EndElement:
+			element := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if remove(element) {
+				spans = append(spans, span{element.Start, dec.InputOffset()})
+			}
+		}
+	}
+
+	//  Children end before their parents, so an element inside a removed
+	//  element is dropped with it.
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var out strings.Builder
+	var pos int64
+	for _, span := range spans {
+		if span.start < pos {
+			continue
+		}
+		out.WriteString(descriptor[pos:span.start])
+		pos = span.end
+	}
+	out.WriteString(descriptor[pos:])
+	return ovfBlankLineRe.ReplaceAllString(out.String(), "\n"), nil
+}
+
+// stripOvfProperties removes the vApp properties from an ovf descriptor.  The
+// product information of the ProductSection is kept.
+func stripOvfProperties(descriptor string) (string, error) {
+	return removeOvfElements(descriptor, func(element ovfElement) bool {
+		return isOvfEnvelopeName(element.Name, "Property") && isOvfEnvelopeName(element.Parent, "ProductSection")
+	})
+}
+
+// stripOvfNetworks removes the networks, and the ethernet adapters connected to
+// them, from an ovf descriptor.  Adapters are Items with ResourceType 10, or
+// the EthernetPortItems of ovf 2.
+func stripOvfNetworks(descriptor string) (string, error) {
+	return removeOvfElements(descriptor, func(element ovfElement) bool {
+		return isOvfEnvelopeName(element.Name, "NetworkSection") ||
+			isOvfEnvelopeName(element.Name, "EthernetPortItem") ||
+			(isOvfEnvelopeName(element.Name, "Item") && element.Ethernet)
+	})
+}
+
+// ovfManifest returns the manifest of an export, in the format ovftool writes
+func ovfManifest(files []exportFile) string {
+	var manifest strings.Builder
+	for _, file := range files {
+		fmt.Fprintf(&manifest, "SHA256(%s)= %s\n", file.Name, file.SHA256)
+	}
+	return manifest.String()
+}
+
+// writeExportFile writes contents to dir/name and returns the written file
+func writeExportFile(dir, name, contents string) (exportFile, error) {
+	file := exportFile{Name: name, Path: filepath.Join(dir, name), Size: int64(len(contents))}
+	if err := os.WriteFile(file.Path, []byte(contents), 0644); err != nil {
+		return file, err
+	}
+	sum := sha256.Sum256([]byte(contents))
+	file.SHA256 = hex.EncodeToString(sum[:])
+	return file, nil
+}
+
+// writeOva packs the files of an export into an ova, in order.  The descriptor
+// must be the first file, and the manifest the second if there is one.
+func writeOva(ova_path string, files []exportFile) (err error) {
+	f, err := os.Create(ova_path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if close_err := f.Close(); err == nil {
+			err = close_err
+		}
+	}()
+
+	tw := tar.NewWriter(f)
+	for _, file := range files {
+		src, err := os.Open(file.Path)
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{Name: file.Name, Mode: 0644, Size: file.Size, Format: tar.FormatUSTAR})
+		if err == nil {
+			_, err = io.Copy(tw, src)
+		}
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("failed to add %s to %s: %w", file.Name, ova_path, err)
+		}
+	}
+	return tw.Close()
+}
+
+// exportOutputs returns the local files an export writes: the ova, or the
+// descriptor, manifest and disks in the ovf directory
+func exportOutputs(path, format string, files []exportFile) []string {
+	if format == "ova" {
+		return []string{path}
+	}
+	var outputs []string
+	for _, file := range files {
+		outputs = append(outputs, filepath.Join(path, file.Name))
+	}
+	return outputs
+}
+
+// exportDownload downloads a disk of an export through the NFC lease to dir
+func exportDownload(gc *GovmomiClient, lease *nfc.Lease, item nfc.FileItem, dir string) (exportFile, error) {
+	file := exportFile{Name: item.Path, Path: filepath.Join(dir, item.Path)}
+
+	logger := progress.NewProgressLogger(func(msg string) (int, error) {
+		log.Printf("[exportDownload] %s", strings.TrimLeft(msg, "\r"))
+		return len(msg), nil
+	}, fmt.Sprintf("Downloading %s... ", item.Path))
+	defer logger.Wait()
+
+	hash := sha256.New()
+	err := lease.DownloadFile(gc.Context(), file.Path, item, soap.Download{Progress: logger, Writer: hash})
+	if err != nil {
+		return file, fmt.Errorf("failed to download %s: %w", item.Path, err)
+	}
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return file, err
+	}
+	file.Size = info.Size()
+	return file, nil
+}
+
+// removeExportFiles removes the files a failed export wrote to an ovf
+// directory, and the directory itself if the export created it
+func removeExportFiles(dir string, created_dir bool, paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[removeExportFiles] Failed to remove %s: %s\n", path, err)
+		}
+	}
+	if created_dir {
+		os.Remove(dir)
+	}
+}
+
+// guestExport exports a powered off guest to an ovf directory or an ova at path,
+// without ovftool.  The disks are downloaded through the NFC lease and the
+// descriptor is created by the host's OvfManager.  The files of the export are
+// named after name, the guest's name if it's empty.  It returns the files of
+// the export; for an ova, the files packed in it.  A failed export removes the
+// files it wrote.
+func guestExport(c *Config, vmid, path, format, name string, manifest, strip_properties, strip_networks bool) (files []exportFile, err error) {
+	log.Printf("[guestExport] Exporting vmid %s to %s %s\n", vmid, format, path)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return nil, err
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(gc.Context(), vm.Reference(), []string{"name", "runtime.powerState"}, &vmMo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest properties: %w", err)
+	}
+	if vmMo.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+		return nil, fmt.Errorf("guest %s must be powered off to export it, it is %s", vmMo.Name, vmMo.Runtime.PowerState)
+	}
+	if name == "" {
+		name = unescapeEntityName(vmMo.Name)
+	}
+
+	//  An ova is packed from a directory next to it.
+	dir := path
+	record := func(name string) {}
+	if format == "ova" {
+		if _, err = os.Stat(path); err == nil {
+			return nil, fmt.Errorf("file already exists: %s", path)
+		}
+		dir, err = os.MkdirTemp(filepath.Dir(path), "."+name+"-export-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+	} else {
+		if _, err = os.Stat(filepath.Join(path, name+".ovf")); err == nil {
+			return nil, fmt.Errorf("file already exists: %s", filepath.Join(path, name+".ovf"))
+		}
+		_, stat_err := os.Stat(path)
+		if err = os.MkdirAll(path, 0750); err != nil {
+			return nil, err
+		}
+
+		//  The files are recorded before they are written, a failed download
+		//  leaves a partial disk.
+		var written []string
+		defer func() {
+			if err != nil {
+				removeExportFiles(path, os.IsNotExist(stat_err), written)
+			}
+		}()
+		record = func(name string) { written = append(written, filepath.Join(path, name)) }
+	}
+
+	lease, err := vm.Export(gc.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to export guest: %w", err)
+	}
+	info, err := lease.Wait(gc.Context(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export guest: %w", err)
+	}
+
+	updater := lease.StartUpdater(gc.Context(), info)
+	var disks []exportFile
+	params := types.OvfCreateDescriptorParams{Name: name}
+	for _, item := range info.Items {
+		//  The nvram and other files are recreated by the host on import.
+		if filepath.Ext(item.Path) != ".vmdk" {
+			continue
+		}
+		if !strings.HasPrefix(item.Path, name) {
+			item.Path = name + "-" + item.Path
+		}
+
+		record(item.Path)
+		disk, err := exportDownload(gc, lease, item, dir)
+		if err != nil {
+			updater.Done()
+			_ = lease.Abort(gc.Context(), &types.LocalizedMethodFault{
+				Fault:            &types.FileFault{File: item.Path},
+				LocalizedMessage: err.Error(),
+			})
+			return nil, err
+		}
+		disks = append(disks, disk)
+
+		ovf_file := item.File()
+		ovf_file.Size = disk.Size
+		params.OvfFiles = append(params.OvfFiles, ovf_file)
+	}
+	updater.Done()
+
+	if err = lease.Complete(gc.Context()); err != nil {
+		return nil, fmt.Errorf("failed to export guest: %w", err)
+	}
+
+	result, err := ovf.NewManager(gc.Client.Client).CreateDescriptor(gc.Context(), vm, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ovf descriptor: %w", err)
+	}
+	if len(result.Error) > 0 {
+		return nil, fmt.Errorf("failed to create ovf descriptor: %s", result.Error[0].LocalizedMessage)
+	}
+
+	descriptor := result.OvfDescriptor
+	if strip_properties {
+		if descriptor, err = stripOvfProperties(descriptor); err != nil {
+			return nil, err
+		}
+	}
+	if strip_networks {
+		if descriptor, err = stripOvfNetworks(descriptor); err != nil {
+			return nil, err
+		}
+	}
+
+	record(name + ".ovf")
+	ovf_file, err := writeExportFile(dir, name+".ovf", descriptor)
+	if err != nil {
+		return nil, err
+	}
+	files = []exportFile{ovf_file}
+
+	if manifest {
+		record(name + ".mf")
+		mf_file, err := writeExportFile(dir, name+".mf", ovfManifest(append([]exportFile{ovf_file}, disks...)))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, mf_file)
+	}
+	files = append(files, disks...)
+
+	if format == "ova" {
+		if err = writeOva(path, files); err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
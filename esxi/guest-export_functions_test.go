@@ -0,0 +1,240 @@
+package esxi
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testExportDescriptor is trimmed from a descriptor created by an esxi host
+const testExportDescriptor = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData">
+  <References>
+    <File ovf:href="golden-disk-0.vmdk" ovf:id="file1" ovf:size="1024"/>
+  </References>
+  <NetworkSection>
+    <Info>The list of logical networks</Info>
+    <Network ovf:name="VM Network">
+      <Description>The VM Network network</Description>
+    </Network>
+  </NetworkSection>
+  <VirtualSystem ovf:id="golden">
+    <Info>A virtual machine</Info>
+    <VirtualHardwareSection>
+      <Item>
+        <rasd:ElementName>SCSI controller 0</rasd:ElementName>
+        <rasd:InstanceID>3</rasd:InstanceID>
+        <rasd:ResourceType>6</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>7</rasd:AddressOnParent>
+        <rasd:Connection>VM Network</rasd:Connection>
+        <rasd:ElementName>Network adapter 1</rasd:ElementName>
+        <rasd:InstanceID>4</rasd:InstanceID>
+        <rasd:ResourceType>10</rasd:ResourceType>
+      </Item>
+    </VirtualHardwareSection>
+    <ProductSection>
+      <Info>Information about the installed software</Info>
+      <Product>golden</Product>
+      <Property ovf:key="hostname" ovf:type="string" ovf:userConfigurable="true" ovf:value="web1"/>
+      <Property ovf:key="password" ovf:type="string" ovf:password="true">
+        <Label>Password</Label>
+      </Property>
+    </ProductSection>
+  </VirtualSystem>
+</Envelope>
+`
+
+// testExportDescriptor2 is an ovf 2 descriptor with other namespace prefixes
+const testExportDescriptor2 = `<?xml version="1.0" encoding="UTF-8"?>
+<ovf:Envelope xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/2" xmlns:r="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:epasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_EthernetPortAllocationSettingData">
+  <ovf:NetworkSection>
+    <ovf:Info>The list of logical networks</ovf:Info>
+    <ovf:Network ovf:name="VM Network"/>
+  </ovf:NetworkSection>
+  <ovf:VirtualSystem ovf:id="golden">
+    <ovf:VirtualHardwareSection>
+      <ovf:Item>
+        <r:ElementName>Network adapter 1</r:ElementName>
+        <r:ResourceType>10</r:ResourceType>
+      </ovf:Item>
+      <ovf:Item>
+        <r:ElementName>SCSI controller 0</r:ElementName>
+        <r:ResourceType>6</r:ResourceType>
+      </ovf:Item>
+      <ovf:EthernetPortItem>
+        <epasd:Connection>VM Network</epasd:Connection>
+        <epasd:ElementName>Network adapter 2</epasd:ElementName>
+        <epasd:ResourceType>10</epasd:ResourceType>
+      </ovf:EthernetPortItem>
+    </ovf:VirtualHardwareSection>
+    <ovf:ProductSection>
+      <ovf:Product>golden</ovf:Product>
+      <ovf:Property ovf:key="hostname" ovf:type="string" ovf:value="web1"/>
+    </ovf:ProductSection>
+  </ovf:VirtualSystem>
+</ovf:Envelope>
+`
+
+// TestStripOvf verifies properties and networks are removed from a descriptor,
+// whatever its namespace prefixes
+func TestStripOvf(t *testing.T) {
+	stripped, err := stripOvfProperties(testExportDescriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stripped, "<Property") || strings.Contains(stripped, "Password") {
+		t.Errorf("Expected properties to be removed:\n%s", stripped)
+	}
+	if !strings.Contains(stripped, "<Product>golden</Product>") || !strings.Contains(stripped, "<NetworkSection>") {
+		t.Errorf("Expected the product and networks to be kept:\n%s", stripped)
+	}
+
+	stripped, err = stripOvfNetworks(testExportDescriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stripped, "NetworkSection") || strings.Contains(stripped, "Network adapter 1") {
+		t.Errorf("Expected networks to be removed:\n%s", stripped)
+	}
+	if !strings.Contains(stripped, "SCSI controller 0") || !strings.Contains(stripped, `ovf:key="hostname"`) {
+		t.Errorf("Expected the controller and properties to be kept:\n%s", stripped)
+	}
+	if strings.Contains(stripped, "\n\n") {
+		t.Errorf("Expected no blank lines:\n%s", stripped)
+	}
+
+	stripped, err = stripOvfNetworks(testExportDescriptor2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, removed := range []string{"NetworkSection", "Network adapter 1", "Network adapter 2", "EthernetPortItem"} {
+		if strings.Contains(stripped, removed) {
+			t.Errorf("Expected %s to be removed:\n%s", removed, stripped)
+		}
+	}
+	if !strings.Contains(stripped, "SCSI controller 0") || !strings.Contains(stripped, "<ovf:Envelope xmlns:ovf=") {
+		t.Errorf("Expected the rest of the descriptor to be kept as it is:\n%s", stripped)
+	}
+
+	stripped, err = stripOvfProperties(testExportDescriptor2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stripped, "ovf:Property") || !strings.Contains(stripped, "<ovf:Product>golden</ovf:Product>") {
+		t.Errorf("Expected only the properties to be removed:\n%s", stripped)
+	}
+
+	if _, err = stripOvfNetworks("<Envelope><Item>"); err == nil {
+		t.Error("Expected an invalid descriptor to fail")
+	}
+}
+
+// TestWriteOva verifies the ova holds the descriptor, manifest and disks in order
+func TestWriteOva(t *testing.T) {
+	dir := t.TempDir()
+
+	ovf_file, err := writeExportFile(dir, "golden.ovf", testExportDescriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := writeExportFile(dir, "golden-disk-0.vmdk", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf_file, err := writeExportFile(dir, "golden.mf", ovfManifest([]exportFile{ovf_file, disk}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mf, _ := os.ReadFile(mf_file.Path)
+	expected := "SHA256(golden.ovf)= " + ovf_file.SHA256 + "\n" +
+		"SHA256(golden-disk-0.vmdk)= 1044dec7206e8d7c9fbb4ae8f766668406d2567fc7fc1a160a9d4700fcf8f8e9\n"
+	if string(mf) != expected {
+		t.Errorf("Unexpected manifest %q", mf)
+	}
+
+	ova_path := filepath.Join(dir, "golden.ova")
+	if err = writeOva(ova_path, []exportFile{ovf_file, mf_file, disk}); err != nil {
+		t.Fatalf("Failed to write ova: %v", err)
+	}
+
+	f, err := os.Open(ova_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		if h.Name == "golden-disk-0.vmdk" {
+			contents, _ := io.ReadAll(tr)
+			if string(contents) != "disk" {
+				t.Errorf("Unexpected disk contents %q", contents)
+			}
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"golden.ovf", "golden.mf", "golden-disk-0.vmdk"}) {
+		t.Errorf("Unexpected ova files %q", names)
+	}
+
+	outputs := exportOutputs(dir, "ovf", []exportFile{ovf_file, mf_file, disk})
+	if len(outputs) != 3 || outputs[0] != filepath.Join(dir, "golden.ovf") {
+		t.Errorf("Unexpected ovf outputs %q", outputs)
+	}
+	if outputs = exportOutputs(ova_path, "ova", nil); !reflect.DeepEqual(outputs, []string{ova_path}) {
+		t.Errorf("Unexpected ova outputs %q", outputs)
+	}
+}
+
+// TestRemoveExportFiles verifies a failed export removes the files it wrote, and only those
+func TestRemoveExportFiles(t *testing.T) {
+	for _, created_dir := range []bool{false, true} {
+		dir := filepath.Join(t.TempDir(), "export")
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		var written []string
+		for _, name := range []string{"golden.ovf", "golden-disk-0.vmdk"} {
+			written = append(written, filepath.Join(dir, name))
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("partial"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		//  A disk whose download failed before it was created
+		written = append(written, filepath.Join(dir, "golden-disk-1.vmdk"))
+
+		removeExportFiles(dir, created_dir, written)
+		for _, path := range written {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("%s should be removed", path)
+			}
+		}
+		if _, err := os.Stat(dir); os.IsNotExist(err) != created_dir {
+			t.Errorf("created_dir %v: unexpected directory state: %v", created_dir, err)
+		}
+	}
+
+	//  Other files keep the directory
+	dir := t.TempDir()
+	other := filepath.Join(dir, "notes.txt")
+	os.WriteFile(other, []byte("keep"), 0644)
+	removeExportFiles(dir, true, []string{filepath.Join(dir, "golden.ovf")})
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("%s should be kept: %v", other, err)
+	}
+}
@@ -0,0 +1,23 @@
+package esxi
+
+import (
+	"log"
+	"os"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTEXPORTRead(d *schema.ResourceData, m interface{}) error {
+	log.Println("[resourceGUESTEXPORTRead]")
+
+	//  Removed outside of terraform, export again.
+	for _, file := range d.Get("files").([]interface{}) {
+		if _, err := os.Stat(file.(string)); os.IsNotExist(err) {
+			log.Printf("[resourceGUESTEXPORTRead] Exported file %s not found\n", file)
+			d.SetId("")
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package esxi
+
+import (
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTEXPORTUpdate(d *schema.ResourceData, m interface{}) error {
+	log.Println("[resourceGUESTEXPORTUpdate]")
+
+	//  Only keep_on_destroy can change in place.  It's used when the export is
+	//  destroyed.
+	return resourceGUESTEXPORTRead(d, m)
+}
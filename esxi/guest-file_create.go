@@ -0,0 +1,43 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// guestFileCopy copies the file to the guest and sets its size and checksum
+func guestFileCopy(d *schema.ResourceData, c *Config) error {
+	content, err := guestFileContent(d)
+	if err != nil {
+		return fmt.Errorf("Failed to read source: %s\n", err)
+	}
+
+	client, err := guestOperationsClient(c, d.Get("guest_id").(string), d.Get("username").(string), d.Get("password").(string))
+	if err != nil {
+		return fmt.Errorf("Failed to copy file: %s\n", err)
+	}
+	err = guestFileUpload(client, d.Get("destination").(string), content, d.Get("permissions").(string), d.Get("create_directories").(bool))
+	if err != nil {
+		return fmt.Errorf("Failed to copy file: %s\n", err)
+	}
+
+	d.Set("size", len(content))
+	d.Set("sha256", guestFileSHA256(content))
+	return nil
+}
+
+func resourceGUESTFILECreate(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTFILECreate]")
+
+	err := guestFileCopy(d, c)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%s:%s", d.Get("guest_id").(string), d.Get("destination").(string)))
+
+	return resourceGUESTFILERead(d, m)
+}
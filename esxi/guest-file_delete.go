@@ -0,0 +1,39 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTFILEDelete(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTFILEDelete]")
+
+	guest_id := d.Get("guest_id").(string)
+
+	if d.Get("keep_on_destroy").(bool) {
+		log.Printf("[resourceGUESTFILEDelete] keep_on_destroy is set, keeping %s\n", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	//  A guest that isn't running can't remove the file, it's left behind.
+	if !guestToolsRunning(c, guest_id) {
+		log.Printf("[resourceGUESTFILEDelete] VMware tools are not running, keeping %s\n", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	client, err := guestOperationsClient(c, guest_id, d.Get("username").(string), d.Get("password").(string))
+	if err == nil {
+		err = guestFileDelete(client, d.Get("destination").(string))
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to delete file: %s\n", err)
+	}
+
+	d.SetId("")
+	return nil
+}
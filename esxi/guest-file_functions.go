@@ -0,0 +1,129 @@
+package esxi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/fault"
+	"github.com/vmware/govmomi/guest/toolbox"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guestFileSHA256 returns the checksum of a file's contents
+func guestFileSHA256(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// guestFileContent returns the contents of the file, from content or the local
+// source file.
+func guestFileContent(d resourceChanges) ([]byte, error) {
+	if source := d.Get("source").(string); source != "" {
+		return os.ReadFile(source)
+	}
+	return []byte(d.Get("content").(string)), nil
+}
+
+// guestFileDir returns the directory of a guest path, with / or \ separators
+func guestFileDir(path string) string {
+	i := strings.LastIndexAny(path, `/\`)
+	if i <= 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// guestFileAttributes returns the attributes of an uploaded file.  permissions
+// is an octal mode, and is only used by posix guests.
+func guestFileAttributes(family types.VirtualMachineGuestOsFamily, permissions string) (types.BaseGuestFileAttributes, error) {
+	if family == types.VirtualMachineGuestOsFamilyWindowsGuest {
+		return &types.GuestWindowsFileAttributes{}, nil
+	}
+	attr := &types.GuestPosixFileAttributes{}
+	if permissions != "" {
+		mode, err := strconv.ParseInt(permissions, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permissions %q", permissions)
+		}
+		attr.Permissions = mode
+	}
+	return attr, nil
+}
+
+// guestFileUpload writes content to path in the guest, replacing the file if it
+// exists.  With create_directories, the missing parent directories are created.
+func guestFileUpload(client *toolbox.Client, path string, content []byte, permissions string, create_directories bool) error {
+	log.Printf("[guestFileUpload] %s (%d bytes)\n", path, len(content))
+
+	ctx := context.Background()
+
+	attr, err := guestFileAttributes(client.GuestFamily, permissions)
+	if err != nil {
+		return err
+	}
+
+	if dir := guestFileDir(path); create_directories && dir != "" {
+		err = client.FileManager.MakeDirectory(ctx, client.Authentication, dir, true)
+		if err != nil && !fault.Is(err, &types.FileAlreadyExists{}) {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	upload := soap.DefaultUpload
+	upload.ContentLength = int64(len(content))
+	err = client.Upload(ctx, bytes.NewReader(content), path, upload, attr, true)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", path, err)
+	}
+	return nil
+}
+
+// guestFileStat returns the size of a file in the guest, and whether it exists.
+func guestFileStat(client *toolbox.Client, path string) (int64, bool, error) {
+	info, err := client.FileManager.ListFiles(context.Background(), client.Authentication, path, 0, 1, "")
+	if err != nil {
+		if fault.Is(err, &types.FileNotFound{}) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to get %s: %w", path, err)
+	}
+	if len(info.Files) == 0 {
+		return 0, false, nil
+	}
+	return info.Files[0].Size, true, nil
+}
+
+// guestFileChecksum returns the sha256 checksum of a file in the guest.
+func guestFileChecksum(client *toolbox.Client, path string) (string, error) {
+	f, _, err := client.Download(context.Background(), path)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// guestFileDelete removes a file from the guest.  A missing file isn't an error.
+func guestFileDelete(client *toolbox.Client, path string) error {
+	log.Printf("[guestFileDelete] %s\n", path)
+
+	err := client.FileManager.DeleteFile(context.Background(), client.Authentication, path)
+	if err != nil && !fault.Is(err, &types.FileNotFound{}) {
+		return fmt.Errorf("failed to delete %s: %w", path, err)
+	}
+	return nil
+}
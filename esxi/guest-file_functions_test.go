@@ -0,0 +1,70 @@
+package esxi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// TestGuestFileAttributes verifies permissions are only set on posix guests
+func TestGuestFileAttributes(t *testing.T) {
+	attr, err := guestFileAttributes("linuxGuest", "0640")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attr, &types.GuestPosixFileAttributes{Permissions: 0640}) {
+		t.Errorf("Expected mode 0640, got %+v", attr)
+	}
+	if _, err = guestFileAttributes("linuxGuest", "rw"); err == nil {
+		t.Error("Expected invalid permissions to be rejected")
+	}
+	if dir := guestFileDir(`C:\ProgramData\app\config.ini`); dir != `C:\ProgramData\app` {
+		t.Errorf("Expected the windows directory, got %q", dir)
+	}
+}
+
+// TestGuestFileGovmomi verifies copying files to a guest with the vcsim simulator
+func TestGuestFileGovmomi(t *testing.T) {
+	config, guest, vmid := newTestGuestConfig(t)
+
+	client, err := guestOperationsClient(config, vmid, "root", "secret")
+	if err != nil {
+		t.Fatalf("Failed to get guest operations client: %v", err)
+	}
+
+	path := "/etc/app/app.conf"
+	if _, exists, err := guestFileStat(client, path); err != nil || exists {
+		t.Errorf("Expected no file, got %v, %v", exists, err)
+	}
+
+	content := []byte("listen 8080\n")
+	if err = guestFileUpload(client, path, content, "0600", true); err != nil {
+		t.Fatalf("Failed to upload file: %v", err)
+	}
+	if string(guest.files[path]) != string(content) {
+		t.Errorf("Expected %q, got %q", content, guest.files[path])
+	}
+	if !reflect.DeepEqual(guest.dirs, []string{"/etc/app"}) {
+		t.Errorf("Expected /etc/app to be created, got %q", guest.dirs)
+	}
+	if size, exists, err := guestFileStat(client, path); err != nil || !exists || size != int64(len(content)) {
+		t.Errorf("Expected a %d byte file, got %d, %v, %v", len(content), size, exists, err)
+	}
+	if sum, err := guestFileChecksum(client, path); err != nil || sum != guestFileSHA256(content) {
+		t.Errorf("Expected checksum %s, got %s, %v", guestFileSHA256(content), sum, err)
+	}
+
+	//  A change of the same size is found by the checksum.
+	guest.files[path] = []byte("listen 9090\n")
+	if sum, err := guestFileChecksum(client, path); err != nil || sum == guestFileSHA256(content) {
+		t.Errorf("Expected the checksum to change, got %s, %v", sum, err)
+	}
+
+	if err = guestFileDelete(client, path); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+	if err = guestFileDelete(client, path); err != nil {
+		t.Errorf("Expected a missing file to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package esxi
+
+import (
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTFILERead(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTFILERead]")
+
+	//  The file can only be checked while VMware tools are running.
+	client, err := guestOperationsClient(c, d.Get("guest_id").(string), d.Get("username").(string), d.Get("password").(string))
+	if err != nil {
+		log.Printf("[resourceGUESTFILERead] Can't check %s: %s\n", d.Id(), err)
+		return nil
+	}
+
+	size, exists, err := guestFileStat(client, d.Get("destination").(string))
+	if err != nil {
+		log.Printf("[resourceGUESTFILERead] Can't check %s: %s\n", d.Id(), err)
+		return nil
+	}
+
+	//  Removed or changed in the guest, copy it again.  A file of the same size is
+	//  compared by its checksum.
+	changed := !exists || size != int64(d.Get("size").(int))
+	if !changed {
+		sha256, err := guestFileChecksum(client, d.Get("destination").(string))
+		if err != nil {
+			log.Printf("[resourceGUESTFILERead] Can't check %s: %s\n", d.Id(), err)
+			return nil
+		}
+		changed = sha256 != d.Get("sha256").(string)
+	}
+	if changed {
+		log.Printf("[resourceGUESTFILERead] %s was removed or changed in the guest\n", d.Id())
+		d.SetId("")
+	}
+
+	return nil
+}
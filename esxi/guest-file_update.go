@@ -0,0 +1,45 @@
+package esxi
+
+import (
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// guestFileCopyKeys are the attributes that copy the file again when they change
+var guestFileCopyKeys = []string{"content", "source", "permissions", "create_directories", "triggers", "sha256"}
+
+func resourceGUESTFILEUpdate(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTFILEUpdate]")
+
+	for _, key := range guestFileCopyKeys {
+		if d.HasChange(key) {
+			err := guestFileCopy(d, c)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return resourceGUESTFILERead(d, m)
+}
+
+// resourceGUESTFILECustomizeDiff copies the file again when the local source
+// file changed.
+func resourceGUESTFILECustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" || d.Get("source").(string) == "" {
+		return nil
+	}
+
+	content, err := guestFileContent(d)
+	if err != nil {
+		//  Reported when the file is copied.
+		return nil
+	}
+	if guestFileSHA256(content) != d.Get("sha256").(string) {
+		d.SetNewComputed("sha256")
+	}
+	return nil
+}
@@ -25,6 +25,10 @@ func resourceGUESTImport(d *schema.ResourceData, m interface{}) ([]*schema.Resou
 
 	if vmid == d.Id() {
 		d.SetId(vmid)
+		d.Set("on_conflict", "fail")
+		d.Set("vmx_backup_retention", 3)
+		d.Set("keep_on_failure", false)
+		d.Set("shutdown_behavior", shutdownBehaviorGuestThenHard)
 	} else {
 		return results, fmt.Errorf("Failed to validate vmid: %s\n", err)
 	}
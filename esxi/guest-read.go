@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
@@ -19,7 +20,7 @@ func resourceGUESTRead(d *schema.ResourceData, m interface{}) error {
 
 	var power string
 
-	guest_name, disk_store, disk_size, boot_disk_type, resource_pool_name, memsize, numvcpus, virthwver, guestos, ip_address, virtual_networks, boot_firmware, virtual_disks, power, notes, guestinfo, err := guestREAD(c, d.Id(), guest_startup_timeout)
+	guest_name, disk_store, disk_size, boot_disk_type, resource_pool_name, memsize, numvcpus, virthwver, guestos, ips, virtual_networks, boot_firmware, virtual_disks, power, notes, guestinfo, doc, err := guestREAD(c, d.Id(), guest_startup_timeout)
 	if err != nil || guest_name == "" {
 		d.SetId("")
 		return nil
@@ -36,32 +37,95 @@ func resourceGUESTRead(d *schema.ResourceData, m interface{}) error {
 	d.Set("numvcpus", numvcpus)
 	d.Set("virthwver", virthwver)
 	d.Set("guestos", guestos)
-	d.Set("ip_address", ip_address)
+
+	guestCPUToResourceData(d, guestCPUFromVmx(doc))
+
+	var boot_order []string
+	for _, device := range d.Get("boot_order").([]interface{}) {
+		boot_order = append(boot_order, device.(string))
+	}
+	boot_options, err := guestGetBootOptions(c, d.Id(), boot_order)
+	if err != nil {
+		log.Printf("[resourceGUESTRead] %s\n", err)
+	} else {
+		guestBootOptionsToResourceData(d, boot_options)
+	}
+
+	autostart, err := guestGetAutoStart(c, d.Id())
+	if err != nil {
+		log.Printf("[resourceGUESTRead] %s\n", err)
+	} else {
+		guestAutoStartToResourceData(d, autostart)
+	}
+
+	allocation, err := guestGetResourceAllocation(c, d.Id())
+	if err != nil {
+		log.Printf("[resourceGUESTRead] %s\n", err)
+	} else {
+		guestResourceAllocationToResourceData(d, allocation)
+	}
+
+	//  VMware tools report every IP address, ip_address is the preferred one.
+	for i, nic := range virtual_networks {
+		virtual_networks[i].IPAddresses = ips.ByMAC[strings.ToLower(nic.ActiveMacAddress)]
+	}
+	d.Set("ip_address", ips.preferred(guestPreferredIPCidrs(d)))
+	d.Set("ip_addresses", ips.All)
+
 	d.Set("power", power)
+	d.Set("requires_reboot", false)
 	d.Set("notes", notes)
 	d.Set("boot_firmware", boot_firmware)
-	if len(guestinfo) != 0 {
-		d.Set("guestinfo", guestinfo)
+	if err = guestinfoToResourceData(d, guestinfo); err != nil {
+		return err
 	}
 
-	// Do network interfaces
-	log.Printf("virtual_networks: %q\n", virtual_networks)
-	nics := make([]map[string]interface{}, 0, 1)
+	//  Cdroms are only read back if terraform manages them.
+	if configured := d.Get("cdrom").([]interface{}); len(configured) > 0 {
+		cdroms := guestCdromsFromVmx(doc)
+		if power == "on" {
+			if err := guestGetCdromConnected(c, d.Id(), cdroms); err != nil {
+				log.Printf("[resourceGUESTRead] %s\n", err)
+			}
+		}
+		for i := range cdroms {
+			if i < len(configured) {
+				cdroms[i].LocalIsoPath = d.Get(fmt.Sprintf("cdrom.%d.local_iso_path", i)).(string)
+			}
+		}
+		d.Set("cdrom", guestCdromsToResourceData(cdroms))
+	}
 
-	if virtual_networks[0][0] == "" {
-		nics = nil
+	//  Only the controllers terraform manages are read back.
+	if configured := d.Get("controllers").([]interface{}); len(configured) > 0 {
+		current := guestControllersFromVmx(doc)
+		var controllers []guestController
+		for i := range configured {
+			name := guestController{
+				Type:      d.Get(fmt.Sprintf("controllers.%d.type", i)).(string),
+				BusNumber: d.Get(fmt.Sprintf("controllers.%d.bus_number", i)).(int),
+			}.name()
+			for _, ctrl := range current {
+				if ctrl.name() == name {
+					controllers = append(controllers, ctrl)
+				}
+			}
+		}
+		d.Set("controllers", guestControllersToResourceData(controllers))
 	}
 
-	for nic := 0; nic < 10; nic++ {
-		if virtual_networks[nic][0] != "" {
-			out := make(map[string]interface{})
-			out["virtual_network"] = virtual_networks[nic][0]
-			out["mac_address"] = virtual_networks[nic][1]
-			out["nic_type"] = virtual_networks[nic][2]
-			nics = append(nics, out)
+	//  Only the extra_config keys terraform manages are read back.
+	if extra_config_keys := d.Get("extra_config").(map[string]interface{}); len(extra_config_keys) > 0 {
+		keys := make([]string, 0, len(extra_config_keys))
+		for key := range extra_config_keys {
+			keys = append(keys, key)
 		}
+		d.Set("extra_config", extraConfigFromVmx(doc, keys))
 	}
-	d.Set("network_interfaces", nics)
+
+	// Do network interfaces
+	log.Printf("virtual_networks: %+v\n", virtual_networks)
+	d.Set("network_interfaces", guestNICsToResourceData(virtual_networks))
 
 	// Do virtual disks
 	log.Printf("virtual_disks: %q\n", virtual_disks)
@@ -84,26 +148,30 @@ func resourceGUESTRead(d *schema.ResourceData, m interface{}) error {
 	return nil
 }
 
-func guestREAD(c *Config, vmid string, guest_startup_timeout int) (string, string, string, string, string, string, string, string, string, string, [10][3]string, string, [60][2]string, string, string, map[string]interface{}, error) {
+// guestREAD reads a guest's settings from its summary and vmx file, and the IPs
+// of a running guest.  The parsed vmx file is returned too, for the settings
+// read from it by the caller.
+func guestREAD(c *Config, vmid string, guest_startup_timeout int) (string, string, string, string, string, string, string, string, string, guestIPAddresses, []guestNIC, string, [60][2]string, string, string, map[string]interface{}, *vmx.Document, error) {
 	esxiConnInfo := getConnectionInfo(c)
 	log.Println("[guestREAD]")
 
-	var guest_name, disk_store, virtual_disk_type, resource_pool_name, guestos, ip_address, notes string
+	var guest_name, disk_store, virtual_disk_type, resource_pool_name, guestos, notes string
 	var dst_vmx_ds, dst_vmx, dst_vmx_file, vmx_contents, power string
 	var disk_size, vdiskindex int
 	var memsize, numvcpus, virthwver string
-	var virtual_networks [10][3]string
+	var virtual_networks []guestNIC
 	var boot_firmware string = "bios"
 	var virtual_disks [60][2]string
 	var guestinfo map[string]interface{}
+	var ips guestIPAddresses
 
 	r, _ := regexp.Compile("")
 
-	remote_cmd := fmt.Sprintf("vim-cmd  vmsvc/get.summary %s", vmid)
+	remote_cmd := shellCommand("vim-cmd", "vmsvc/get.summary", vmid)
 	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "Get Guest summary")
 
 	if strings.Contains(stdout, "Unable to find a VM corresponding") {
-		return "", "", "", "", "", "", "", "", "", "", virtual_networks, "", virtual_disks, "", "", nil, nil
+		return "", "", "", "", "", "", "", "", "", ips, virtual_networks, "", virtual_disks, "", "", nil, vmx.Parse(""), nil
 	}
 
 	scanner := bufio.NewScanner(strings.NewReader(stdout))
@@ -123,7 +191,7 @@ func guestREAD(c *Config, vmid string, guest_startup_timeout int) (string, strin
 	}
 
 	//  Get resource pool that this VM is located
-	remote_cmd = fmt.Sprintf(`grep -A2 'objID>%s</objID' /etc/vmware/hostd/pools.xml | grep -o resourcePool.*resourcePool`, vmid)
+	remote_cmd = shellCommand("grep", "-F", "-A2", "objID>"+vmid+"</objID", "/etc/vmware/hostd/pools.xml") + " | grep -o 'resourcePool.*resourcePool'"
 	stdout, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "check if guest is in resource pool")
 	nr := strings.NewReplacer("resourcePool>", "", "</resourcePool", "")
 	vm_resource_pool_id := nr.Replace(stdout)
@@ -135,13 +203,13 @@ func guestREAD(c *Config, vmid string, guest_startup_timeout int) (string, strin
 	//  Read vmx file into memory to read settings
 	//
 	//      -Get location of vmx file on esxi host
-	remote_cmd = fmt.Sprintf("vim-cmd vmsvc/get.config %s | grep vmPathName|grep -oE \"\\[.*\\]\"", vmid)
+	remote_cmd = shellCommand("vim-cmd", "vmsvc/get.config", vmid) + " | grep vmPathName|grep -oE \"\\[.*\\]\""
 	stdout, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "get dst_vmx_ds")
 	dst_vmx_ds = stdout
 	dst_vmx_ds = strings.Trim(dst_vmx_ds, "[")
 	dst_vmx_ds = strings.Trim(dst_vmx_ds, "]")
 
-	remote_cmd = fmt.Sprintf("vim-cmd vmsvc/get.config %s | grep vmPathName|awk '{print $NF}'|sed 's/[\"|,]//g'", vmid)
+	remote_cmd = shellCommand("vim-cmd", "vmsvc/get.config", vmid) + " | grep vmPathName|awk '{print $NF}'|sed 's/[\"|,]//g'"
 	stdout, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "get dst_vmx")
 	dst_vmx = stdout
 
@@ -150,149 +218,85 @@ func guestREAD(c *Config, vmid string, guest_startup_timeout int) (string, strin
 	log.Printf("[guestREAD] dst_vmx_file: %s\n", dst_vmx_file)
 	log.Printf("[guestREAD] disk_store: %s  dst_vmx_ds:%s\n", disk_store, dst_vmx_file)
 
-	remote_cmd = fmt.Sprintf("cat \"%s\"", dst_vmx_file)
+	remote_cmd = shellCommand("cat", dst_vmx_file)
 	vmx_contents, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "read guest_name.vmx file")
 
-	// Used to keep track if a network interface is using static or generated macs.
-	var isGeneratedMAC [10]bool
+	//  Read settings from the vmx file.
+	doc := vmx.Parse(vmx_contents)
 
-	//  Read vmx_contents line-by-line to get current settings.
+	memsize = doc.Value("memSize")
+	numvcpus = doc.Value("numvcpus")
+	virthwver = doc.Value("virtualHW.version")
+	guestos = doc.Value("guestOS")
+	if value, ok := doc.Get("firmware"); ok {
+		boot_firmware = value
+	}
+	notes = doc.Value("annotation")
+	log.Printf("[guestREAD] memsize: %s numvcpus: %s virthwver: %s guestos: %s firmware: %s\n",
+		memsize, numvcpus, virthwver, guestos, boot_firmware)
+
+	//  Additional disks, skipping the boot disk.
+	boot_disk_vmdkPATH, boot_slot, err := guestBootDisk(c, vmid)
+	if err != nil {
+		log.Printf("[guestREAD] %s, assuming scsi0:0\n", err)
+		boot_slot = vmx.Slot{Bus: "scsi"}
+	}
 	vdiskindex = 0
-	scanner = bufio.NewScanner(strings.NewReader(vmx_contents))
-	for scanner.Scan() {
-
-		switch {
-		case strings.Contains(scanner.Text(), "memSize = "):
-			r, _ = regexp.Compile(`\".*\"`)
-			stdout = r.FindString(scanner.Text())
-			nr = strings.NewReplacer(`"`, "", `"`, "")
-			memsize = nr.Replace(stdout)
-			log.Printf("[guestREAD] memsize found: %s\n", memsize)
-
-		case strings.Contains(scanner.Text(), "numvcpus = "):
-			r, _ = regexp.Compile(`\".*\"`)
-			stdout = r.FindString(scanner.Text())
-			nr = strings.NewReplacer(`"`, "", `"`, "")
-			numvcpus = nr.Replace(stdout)
-			log.Printf("[guestREAD] numvcpus found: %s\n", numvcpus)
-
-		case strings.Contains(scanner.Text(), "numa.autosize.vcpu."):
-			r, _ = regexp.Compile(`\".*\"`)
-			stdout = r.FindString(scanner.Text())
-			nr = strings.NewReplacer(`"`, "", `"`, "")
-			numvcpus = nr.Replace(stdout)
-			log.Printf("[guestREAD] numa.vcpu (numvcpus) found: %s\n", numvcpus)
-
-		case strings.Contains(scanner.Text(), "virtualHW.version = "):
-			r, _ = regexp.Compile(`\".*\"`)
-			stdout = r.FindString(scanner.Text())
-			virthwver = strings.Replace(stdout, `"`, "", -1)
-			log.Printf("[guestREAD] virthwver found: %s\n", virthwver)
-
-		case strings.Contains(scanner.Text(), "guestOS = "):
-			r, _ = regexp.Compile(`\".*\"`)
-			stdout = r.FindString(scanner.Text())
-			guestos = strings.Replace(stdout, `"`, "", -1)
-			log.Printf("[guestREAD] guestos found: %s\n", guestos)
-
-		case strings.Contains(scanner.Text(), "scsi"):
-			re := regexp.MustCompile("scsi([0-3]):([0-9]{1,2}).(.*) = \"(.*)\"")
-			results := re.FindStringSubmatch(scanner.Text())
-			if len(results) > 4 {
-				log.Printf("[guestREAD] %s : %s . %s = %s\n", results[1], results[2], results[3], results[4])
-
-				if (results[1] == "0") && (results[2] == "0") {
-					// Skip boot disk
-				} else {
-					if strings.Contains(results[3], "fileName") == true {
-						log.Printf("[guestREAD] %s : %s\n", results[0], results[4])
-						virtual_disks[vdiskindex][0] = results[4]
-						virtual_disks[vdiskindex][1] = fmt.Sprintf("%s:%s", results[1], results[2])
-						vdiskindex += 1
-					}
-				}
-			}
-
-		case strings.Contains(scanner.Text(), "ethernet"):
-			re := regexp.MustCompile("ethernet(.).(.*) = \"(.*)\"")
-			results := re.FindStringSubmatch(scanner.Text())
-			index, _ := strconv.Atoi(results[1])
-
-			switch results[2] {
-			case "networkName":
-				virtual_networks[index][0] = results[3]
-				log.Printf("[guestREAD] %s : %s\n", results[0], results[3])
-
-			case "addressType":
-				if results[3] == "generated" {
-					isGeneratedMAC[index] = true
-				}
-
-				//  Done't save generatedAddress...   It should not be saved because it
-			//  should be considered dynamic & is breaks the update MAC address code.
-			//case "generatedAddress":
-			//	if isGeneratedMAC[index] == true {
-			//		virtual_networks[index][1] = results[3]
-			//		log.Printf("[guestREAD] %s : %s\n", results[0], results[3])
-			//	}
-
-			case "address":
-				if isGeneratedMAC[index] == false {
-					virtual_networks[index][1] = results[3]
-					log.Printf("[guestREAD] %s : %s\n", results[0], results[3])
-				}
-
-			case "virtualDev":
-				virtual_networks[index][2] = results[3]
-				log.Printf("[guestREAD] %s : %s\n", results[0], results[3])
-			}
-
-		case strings.Contains(scanner.Text(), "firmware = "):
-			r, _ = regexp.Compile(`\".*\"`)
-			stdout = r.FindString(scanner.Text())
-			boot_firmware = strings.Replace(stdout, `"`, "", -1)
-			log.Printf("[guestREAD] firmware found: %s\n", boot_firmware)
-
-		case strings.Contains(scanner.Text(), "annotation = "):
-			r, _ = regexp.Compile(`\".*\"`)
-			stdout = r.FindString(scanner.Text())
-			notes = strings.Replace(stdout, `"`, "", -1)
-			notes = strings.Replace(notes, "|22", "\"", -1)
-			log.Printf("[guestREAD] annotation found: %s\n", notes)
-
+	for _, disk := range doc.Disks() {
+		slot := disk.Slot
+		if _, ok := diskBusLimits[slot.Bus]; !ok || disk.IsCdrom() || slot == boot_slot {
+			continue
+		}
+		if _, ok := doc.Get(slot.Key("fileName")); ok && vdiskindex < len(virtual_disks) {
+			log.Printf("[guestREAD] %s : %s\n", slot, disk.FileName)
+			virtual_disks[vdiskindex][0] = disk.FileName
+			virtual_disks[vdiskindex][1] = slot.String()
+			vdiskindex += 1
 		}
 	}
 
-	parsed_vmx := ParseVMX(vmx_contents)
+	virtual_networks = guestNICsFromVmx(doc, guestDVSPortgroups(c))
 
 	//  Get power state
 	log.Println("guestREAD: guestPowerGetState")
 	power = guestPowerGetState(c, vmid)
 
+	//  The vmx only has the connected state at power on.
+	if power == "on" {
+		connected, err := guestGetNICConnected(c, vmid)
+		if err != nil {
+			log.Printf("[guestREAD] %s\n", err)
+		}
+		for i, nic := range virtual_networks {
+			if state, ok := connected[strings.ToLower(nic.ActiveMacAddress)]; ok {
+				virtual_networks[i].Connected = state
+			}
+		}
+	}
+
 	//
 	// Get IP address (need vmware tools installed)
 	//
 	if power == "on" {
-		ip_address = guestGetIpAddress(c, vmid, guest_startup_timeout)
-		log.Printf("[guestREAD] guestGetIpAddress: %s\n", ip_address)
-	} else {
-		ip_address = ""
+		ips, err = guestGetIPAddresses(c, vmid, guest_startup_timeout)
+		if err != nil {
+			log.Printf("[guestREAD] %s\n", err)
+		}
+		log.Printf("[guestREAD] ip_addresses: %q\n", ips.All)
 	}
 
 	// Get boot disk size
-	boot_disk_vmdkPATH, _ := getBootDiskPath(c, vmid)
 	_, _, _, disk_size, virtual_disk_type, err = virtualDiskREAD(c, boot_disk_vmdkPATH)
 	str_disk_size := strconv.Itoa(disk_size)
 
 	// Get guestinfo value
 	guestinfo = make(map[string]interface{})
-	for key, value := range parsed_vmx {
-		if strings.Contains(key, "guestinfo") {
-			short_key := strings.Replace(key, "guestinfo.", "", -1)
-			guestinfo[short_key] = value
+	for _, key := range doc.Keys() {
+		if strings.HasPrefix(strings.ToLower(key), "guestinfo.") {
+			guestinfo[key[len("guestinfo."):]] = doc.Value(key)
 		}
 	}
 
 	// return results
-	return guest_name, disk_store, str_disk_size, virtual_disk_type, resource_pool_name, memsize, numvcpus, virthwver, guestos, ip_address, virtual_networks, boot_firmware, virtual_disks, power, notes, guestinfo, err
+	return guest_name, disk_store, str_disk_size, virtual_disk_type, resource_pool_name, memsize, numvcpus, virthwver, guestos, ips, virtual_networks, boot_firmware, virtual_disks, power, notes, guestinfo, doc, err
 }
\ No newline at end of file
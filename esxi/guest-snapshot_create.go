@@ -0,0 +1,41 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTSNAPSHOTCreate(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTSNAPSHOTCreate]")
+
+	guest_id := d.Get("guest_id").(string)
+	snapshot_name := d.Get("snapshot_name").(string)
+	description := d.Get("description").(string)
+	include_memory := d.Get("include_memory").(bool)
+	quiesce := d.Get("quiesce").(bool)
+	parent_snapshot_id := d.Get("parent_snapshot_id").(string)
+
+	//  A new snapshot is always a child of the current snapshot.
+	if parent_snapshot_id != "" {
+		_, current, err := guestSnapshotList(c, guest_id)
+		if err != nil {
+			return fmt.Errorf("Failed to list snapshots: %s\n", err)
+		}
+		if current != parent_snapshot_id {
+			return fmt.Errorf("Failed to create snapshot: parent_snapshot_id %s is not the current snapshot of guest %s (current: %q)\n", parent_snapshot_id, guest_id, current)
+		}
+	}
+
+	snapshot_id, err := guestSnapshotCreate(c, guest_id, snapshot_name, description, include_memory, quiesce)
+	if err != nil {
+		d.SetId("")
+		return fmt.Errorf("Failed to create snapshot: %s\n", err)
+	}
+
+	d.SetId(snapshot_id)
+
+	return resourceGUESTSNAPSHOTRead(d, m)
+}
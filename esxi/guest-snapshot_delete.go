@@ -0,0 +1,32 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTSNAPSHOTDelete(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTSNAPSHOTDelete]")
+
+	guest_id := d.Get("guest_id").(string)
+	snapshot_id := d.Id()
+
+	if d.Get("revert_on_destroy").(bool) {
+		err := guestSnapshotRevert(c, guest_id, snapshot_id)
+		if err != nil {
+			return fmt.Errorf("Failed to revert to snapshot: %s\n", err)
+		}
+	}
+
+	err := guestSnapshotRemove(c, guest_id, snapshot_id, d.Get("remove_children").(bool))
+	if err != nil {
+		log.Printf("[resourceGUESTSNAPSHOTDelete] Failed destroy snapshot id: %s\n", snapshot_id)
+		return fmt.Errorf("Failed to delete snapshot: %s\n", err)
+	}
+
+	d.SetId("")
+	return nil
+}
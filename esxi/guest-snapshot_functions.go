@@ -0,0 +1,200 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ============================================================================
+// Guest Snapshot Operations
+// ============================================================================
+
+// guestSnapshot is one node of a guest's snapshot tree
+type guestSnapshot struct {
+	ID          string
+	Name        string
+	Description string
+	ParentID    string
+	CreateTime  time.Time
+	PowerState  string
+	Quiesced    bool
+	Size        int64
+}
+
+// guestSnapshotCreate takes a snapshot of a guest and returns its ID
+func guestSnapshotCreate(c *Config, vmid, name, description string, memory, quiesce bool) (string, error) {
+	log.Printf("[guestSnapshotCreate] Creating snapshot %s of vmid %s\n", name, vmid)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return "", err
+	}
+
+	task, err := vm.CreateSnapshot(gc.Context(), name, description, memory, quiesce)
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	info, err := task.WaitForResult(gc.Context(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	ref, ok := info.Result.(types.ManagedObjectReference)
+	if !ok {
+		return "", fmt.Errorf("failed to create snapshot: unexpected task result %T", info.Result)
+	}
+
+	return ref.Value, nil
+}
+
+// guestSnapshotList returns a guest's snapshot tree, parents before their
+// children, and the ID of the current snapshot
+func guestSnapshotList(c *Config, vmid string) ([]guestSnapshot, string, error) {
+	log.Printf("[guestSnapshotList] Listing snapshots of vmid %s\n", vmid)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(gc.Context(), vm.Reference(), []string{"snapshot", "layoutEx"}, &vmMo)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get guest properties: %w", err)
+	}
+
+	if vmMo.Snapshot == nil {
+		return nil, "", nil
+	}
+
+	var current string
+	if vmMo.Snapshot.CurrentSnapshot != nil {
+		current = vmMo.Snapshot.CurrentSnapshot.Value
+	}
+
+	return flattenSnapshotTree(vmMo.Snapshot.RootSnapshotList, nil, vmMo.LayoutEx, current), current, nil
+}
+
+// flattenSnapshotTree walks a snapshot tree depth first
+func flattenSnapshotTree(tree []types.VirtualMachineSnapshotTree, parent *types.ManagedObjectReference, layout *types.VirtualMachineFileLayoutEx, current string) []guestSnapshot {
+	var snapshots []guestSnapshot
+
+	for _, node := range tree {
+		snapshot := guestSnapshot{
+			ID:          node.Snapshot.Value,
+			Name:        node.Name,
+			Description: node.Description,
+			CreateTime:  node.CreateTime,
+			PowerState:  string(node.State),
+			Quiesced:    node.Quiesced,
+		}
+		if parent != nil {
+			snapshot.ParentID = parent.Value
+		}
+		if layout != nil {
+			snapshot.Size = int64(object.SnapshotSize(node.Snapshot, parent, layout, node.Snapshot.Value == current))
+		}
+
+		snapshots = append(snapshots, snapshot)
+		ref := node.Snapshot
+		snapshots = append(snapshots, flattenSnapshotTree(node.ChildSnapshotList, &ref, layout, current)...)
+	}
+
+	return snapshots
+}
+
+// guestSnapshotRead returns one snapshot of a guest, or nil if it doesn't exist
+func guestSnapshotRead(c *Config, vmid, snapshot_id string) (*guestSnapshot, error) {
+	snapshots, _, err := guestSnapshotList(c, vmid)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range snapshots {
+		if snapshots[i].ID == snapshot_id {
+			return &snapshots[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// guestSnapshotRevert reverts a guest to a snapshot.  The guest is left in
+// the power state the snapshot was taken in.
+func guestSnapshotRevert(c *Config, vmid, snapshot_id string) error {
+	log.Printf("[guestSnapshotRevert] Reverting vmid %s to snapshot %s\n", vmid, snapshot_id)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+
+	task, err := vm.RevertToSnapshot(gc.Context(), snapshot_id, false)
+	if err != nil {
+		return fmt.Errorf("failed to revert to snapshot: %w", err)
+	}
+
+	if err = waitForTask(gc.Context(), task); err != nil {
+		return fmt.Errorf("failed to revert to snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// guestSnapshotRemove removes a snapshot and consolidates its disks into the
+// parent.  Children are kept and re-parented unless remove_children is set.
+func guestSnapshotRemove(c *Config, vmid, snapshot_id string, remove_children bool) error {
+	log.Printf("[guestSnapshotRemove] Removing snapshot %s of vmid %s\n", snapshot_id, vmid)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+
+	//  Removing the snapshot would change the disks linked clones are built on.
+	clones, err := guestSnapshotLinkedClones(c, vmid, snapshot_id, remove_children)
+	if err != nil {
+		return err
+	}
+	if len(clones) > 0 {
+		return fmt.Errorf("snapshot %s has linked clones: %s", snapshot_id, strings.Join(clones, ", "))
+	}
+
+	consolidate := true
+	task, err := vm.RemoveSnapshot(gc.Context(), snapshot_id, remove_children, &consolidate)
+	if err != nil {
+		return fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+
+	if err = waitForTask(gc.Context(), task); err != nil {
+		return fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+
+	return nil
+}
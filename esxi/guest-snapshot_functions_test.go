@@ -0,0 +1,100 @@
+package esxi
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+)
+
+// TestGuestSnapshotTreeGovmomi tests snapshot create, tree listing, revert and remove
+func TestGuestSnapshotTreeGovmomi(t *testing.T) {
+	model := simulator.ESX()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatal("Failed to find VMs in simulator")
+	}
+	vmid := vms[0].Reference().Value
+
+	snapshots, current, err := guestSnapshotList(config, vmid)
+	if err != nil || len(snapshots) != 0 || current != "" {
+		t.Fatalf("Expected no snapshots, got %+v, %q, %v", snapshots, current, err)
+	}
+
+	// Create a parent and a child snapshot
+	parentID, err := guestSnapshotCreate(config, vmid, "pre-upgrade", "before the upgrade", false, false)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	childID, err := guestSnapshotCreate(config, vmid, "post-upgrade", "", false, false)
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+
+	snapshots, current, err = guestSnapshotList(config, vmid)
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 2 || current != childID {
+		t.Fatalf("Expected 2 snapshots with current %s, got %+v, %q", childID, snapshots, current)
+	}
+	if snapshots[0].ID != parentID || snapshots[0].ParentID != "" || snapshots[0].Description != "before the upgrade" {
+		t.Errorf("Unexpected root snapshot %+v", snapshots[0])
+	}
+	if snapshots[1].ID != childID || snapshots[1].ParentID != parentID || snapshots[1].Name != "post-upgrade" {
+		t.Errorf("Unexpected child snapshot %+v", snapshots[1])
+	}
+	if snapshots[0].CreateTime.IsZero() {
+		t.Error("Snapshot create time should be set")
+	}
+
+	// Revert to the parent
+	if err = guestSnapshotRevert(config, vmid, parentID); err != nil {
+		t.Fatalf("Failed to revert to snapshot: %v", err)
+	}
+	if _, current, _ = guestSnapshotList(config, vmid); current != parentID {
+		t.Errorf("Expected current snapshot %s after revert, got %q", parentID, current)
+	}
+
+	if err = guestSnapshotRevert(config, vmid, childID); err != nil {
+		t.Fatalf("Failed to revert to snapshot: %v", err)
+	}
+
+	// Removing the parent keeps the child
+	if err = guestSnapshotRemove(config, vmid, parentID, false); err != nil {
+		t.Fatalf("Failed to remove snapshot: %v", err)
+	}
+	snapshot, err := guestSnapshotRead(config, vmid, parentID)
+	if err != nil || snapshot != nil {
+		t.Errorf("Expected the snapshot to be removed, got %+v, %v", snapshot, err)
+	}
+	snapshot, err = guestSnapshotRead(config, vmid, childID)
+	if err != nil || snapshot == nil {
+		t.Fatalf("Expected the child snapshot to be kept, got %v", err)
+	}
+	if snapshot.ParentID != "" {
+		t.Errorf("Expected the child to become a root snapshot, got parent %q", snapshot.ParentID)
+	}
+}
@@ -0,0 +1,41 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTSNAPSHOTImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	c := m.(*Config)
+
+	log.Println("[resourceGUESTSNAPSHOTImport]")
+
+	results := make([]*schema.ResourceData, 1, 1)
+	results[0] = d
+
+	//  Import ID is <guest_id>/<snapshot_id>
+	parts := strings.SplitN(d.Id(), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return results, fmt.Errorf("Invalid import ID %q, expected <guest_id>/<snapshot_id>\n", d.Id())
+	}
+
+	snapshot, err := guestSnapshotRead(c, parts[0], parts[1])
+	if err != nil {
+		return results, fmt.Errorf("Failed to read snapshot: %s\n", err)
+	}
+	if snapshot == nil {
+		return results, fmt.Errorf("Snapshot %s of guest %s not found\n", parts[1], parts[0])
+	}
+
+	d.SetId(parts[1])
+	d.Set("guest_id", parts[0])
+	d.Set("include_memory", false)
+	d.Set("quiesce", snapshot.Quiesced)
+	d.Set("revert_on_destroy", false)
+	d.Set("remove_children", false)
+
+	return results, nil
+}
@@ -0,0 +1,37 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTSNAPSHOTRead(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceGUESTSNAPSHOTRead]")
+
+	guest_id := d.Get("guest_id").(string)
+
+	snapshot, err := guestSnapshotRead(c, guest_id, d.Id())
+	if err != nil {
+		return fmt.Errorf("Failed to refresh snapshot: %s\n", err)
+	}
+
+	//  Removed outside of terraform.
+	if snapshot == nil {
+		log.Printf("[resourceGUESTSNAPSHOTRead] Snapshot %s of guest %s not found\n", d.Id(), guest_id)
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("snapshot_name", snapshot.Name)
+	d.Set("description", snapshot.Description)
+	d.Set("parent_snapshot_id", snapshot.ParentID)
+	d.Set("create_time", snapshot.CreateTime.Format(time.RFC3339))
+	d.Set("power_state", snapshot.PowerState)
+	d.Set("size", snapshot.Size)
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package esxi
+
+import (
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTSNAPSHOTUpdate(d *schema.ResourceData, m interface{}) error {
+	log.Println("[resourceGUESTSNAPSHOTUpdate]")
+
+	//  Only revert_on_destroy and remove_children can change in place.  They
+	//  are used when the snapshot is destroyed.
+	return resourceGUESTSNAPSHOTRead(d, m)
+}
@@ -3,42 +3,16 @@ package esxi
 import (
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
 )
 
 func validateVirtualDiskSlot(slot string) string {
 	log.Printf("[validateVirtualDiskSlot]\n")
 
-	var result string
-
-	// Split on comma.
-	fields := strings.Split(slot+":UnSet", ":")
-
-	// if using simple format
-	if fields[1] == "UnSet" {
-		fields[1] = fields[0]
-		fields[0] = "0"
-	}
-
-	field0i, _ := strconv.Atoi(fields[0])
-	field1i, _ := strconv.Atoi(fields[1])
-	result = "ok"
-
-	if field0i < 0 || field0i > 3 {
-		result = "scsi controller id out of range"
+	if _, err := parseDiskSlot(slot); err != nil {
+		return err.Error()
 	}
-	if field1i < 0 || field1i > 15 {
-		result = "scsi id out of range"
-	}
-	if field0i == 0 && field1i == 0 {
-		result = "scsi id used by boot disk"
-	}
-	if field1i == 7 {
-		result = "scsi id 7 not allowed"
-	}
-
-	return result
+	return "ok"
 }
 
 func validateNICType(nictype string) bool {
@@ -0,0 +1,120 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guestAutoStart is the autostart entry of a guest on the host.
+type guestAutoStart struct {
+	Enabled          bool
+	StartOrder       int // 0 is any order
+	StartDelay       int // seconds, -1 is the host default
+	StopAction       string
+	WaitForHeartbeat string // yes, no or systemDefault
+}
+
+// Convert the autostart block.  It returns nil if it's not set.
+func guestAutoStartFromResourceData(d *schema.ResourceData) *guestAutoStart {
+	if d.Get("autostart.#").(int) == 0 {
+		return nil
+	}
+	return &guestAutoStart{
+		Enabled:          d.Get("autostart.0.enabled").(bool),
+		StartOrder:       d.Get("autostart.0.start_order").(int),
+		StartDelay:       d.Get("autostart.0.start_delay").(int),
+		StopAction:       d.Get("autostart.0.stop_action").(string),
+		WaitForHeartbeat: d.Get("autostart.0.wait_for_heartbeat").(string),
+	}
+}
+
+// Set the autostart block.  A guest without an autostart entry has none.
+func guestAutoStartToResourceData(d *schema.ResourceData, autostart *guestAutoStart) {
+	if autostart == nil {
+		d.Set("autostart", nil)
+		return
+	}
+	d.Set("autostart", []map[string]interface{}{{
+		"enabled":            autostart.Enabled,
+		"start_order":        autostart.StartOrder,
+		"start_delay":        autostart.StartDelay,
+		"stop_action":        autostart.StopAction,
+		"wait_for_heartbeat": autostart.WaitForHeartbeat,
+	}})
+}
+
+// guestAutoStartFromPowerInfo reads the autostart entry of a guest.
+func guestAutoStartFromPowerInfo(info types.AutoStartPowerInfo) guestAutoStart {
+	autostart := guestAutoStart{
+		Enabled:          info.StartAction == "powerOn",
+		StartDelay:       int(info.StartDelay),
+		StopAction:       autoStartStopAction(info.StopAction),
+		WaitForHeartbeat: string(info.WaitForHeartbeat),
+	}
+	if info.StartOrder > 0 {
+		autostart.StartOrder = int(info.StartOrder)
+	}
+	return autostart
+}
+
+// powerInfo returns the autostart entry of the guest vm.  A disabled guest
+// keeps no start order.
+func (autostart guestAutoStart) powerInfo(vm types.ManagedObjectReference) types.AutoStartPowerInfo {
+	info := types.AutoStartPowerInfo{
+		Key:              vm,
+		StartOrder:       -1,
+		StartDelay:       int32(autostart.StartDelay),
+		WaitForHeartbeat: types.AutoStartWaitHeartbeatSetting(autostart.WaitForHeartbeat),
+		StartAction:      "none",
+		StopDelay:        -1,
+		StopAction:       autostart.StopAction,
+	}
+	if autostart.Enabled {
+		info.StartAction = "powerOn"
+		if autostart.StartOrder > 0 {
+			info.StartOrder = int32(autostart.StartOrder)
+		}
+	}
+	return info
+}
+
+// guestGetAutoStart returns the autostart entry of a guest, or nil if it has none.
+func guestGetAutoStart(c *Config, vmid string) (*guestAutoStart, error) {
+	log.Printf("[guestGetAutoStart]\n")
+
+	_, _, config, err := hostAutoStartConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get guest autostart: %s\n", err)
+	}
+	for _, info := range config.PowerInfo {
+		if info.Key.Value == vmid {
+			autostart := guestAutoStartFromPowerInfo(info)
+			return &autostart, nil
+		}
+	}
+	return nil, nil
+}
+
+// guestSetAutoStart sets the autostart entry of a guest.
+func guestSetAutoStart(c *Config, vmid string, autostart guestAutoStart) error {
+	log.Printf("[guestSetAutoStart] %+v\n", autostart)
+
+	gc, manager, _, err := hostAutoStartConfig(c)
+	if err != nil {
+		return fmt.Errorf("Failed to set guest autostart: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+
+	spec := types.HostAutoStartManagerConfig{PowerInfo: []types.AutoStartPowerInfo{autostart.powerInfo(vm.Reference())}}
+	err = hostAutoStartReconfigure(gc, manager, spec)
+	if err != nil {
+		return fmt.Errorf("Failed to set guest autostart: %s\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guestBootOptionsKeys are the esxi_guest attributes of a guest's boot options.
+var guestBootOptionsKeys = []string{
+	"boot_order", "boot_delay_ms", "efi_secure_boot_enabled", "boot_retry_enabled", "boot_retry_delay_ms", "enter_bios_setup_once",
+}
+
+// bootDeviceRe matches the entries of boot_order
+var bootDeviceRe = regexp.MustCompile(`^(disk|cdrom|network|floppy|ethernet\d+|(scsi|sata|ide|nvme)\d+:\d+)$`)
+
+// secureBootMinVirthwver is the first hardware version with EFI secure boot.
+const secureBootMinVirthwver = 13
+
+// guestBootOptions are the boot options of a guest.
+type guestBootOptions struct {
+	BootOrder            []string
+	BootDelay            int // ms
+	EfiSecureBootEnabled bool
+	BootRetryEnabled     bool
+	BootRetryDelay       int // ms
+	EnterBIOSSetupOnce   bool
+	SetEnterBIOSSetup    bool // EnterBIOSSetupOnce is only sent when it changed
+}
+
+// Get the boot options from the resource config.
+func guestBootOptionsFromResourceData(d *schema.ResourceData) guestBootOptions {
+	options := guestBootOptions{
+		BootDelay:            d.Get("boot_delay_ms").(int),
+		EfiSecureBootEnabled: d.Get("efi_secure_boot_enabled").(bool),
+		BootRetryEnabled:     d.Get("boot_retry_enabled").(bool),
+		BootRetryDelay:       d.Get("boot_retry_delay_ms").(int),
+		EnterBIOSSetupOnce:   d.Get("enter_bios_setup_once").(bool),
+		SetEnterBIOSSetup:    d.HasChange("enter_bios_setup_once"),
+	}
+	for _, device := range d.Get("boot_order").([]interface{}) {
+		options.BootOrder = append(options.BootOrder, device.(string))
+	}
+	return options
+}
+
+// Set the boot options attributes.  enter_bios_setup_once isn't read back, as
+// the guest clears it when it boots.
+func guestBootOptionsToResourceData(d *schema.ResourceData, options guestBootOptions) {
+	d.Set("boot_order", options.BootOrder)
+	d.Set("boot_delay_ms", options.BootDelay)
+	d.Set("efi_secure_boot_enabled", options.EfiSecureBootEnabled)
+	d.Set("boot_retry_enabled", options.BootRetryEnabled)
+	d.Set("boot_retry_delay_ms", options.BootRetryDelay)
+}
+
+// guestBootOptionsConfigured tells if any boot option is set.  Otherwise a new
+// guest keeps the boot options of its source.
+func guestBootOptionsConfigured(d *schema.ResourceData) bool {
+	for _, key := range guestBootOptionsKeys {
+		if _, ok := d.GetOk(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// guestBootOptionsChanged tells if any boot option changed.
+func guestBootOptionsChanged(d *schema.ResourceData) bool {
+	for _, key := range guestBootOptionsKeys {
+		if d.HasChange(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that secure boot has EFI firmware and a hardware version that
+// supports it.  A virthwver of 0 isn't known yet, and isn't checked.
+func (options guestBootOptions) validate(boot_firmware string, virthwver int) error {
+	var errs []string
+
+	if options.EfiSecureBootEnabled {
+		if boot_firmware != "efi" {
+			errs = append(errs, "efi_secure_boot_enabled requires boot_firmware efi")
+		}
+		if virthwver > 0 && virthwver < secureBootMinVirthwver {
+			errs = append(errs, fmt.Sprintf("efi_secure_boot_enabled requires virthwver %d or later", secureBootMinVirthwver))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, device := range options.BootOrder {
+		if seen[device] {
+			errs = append(errs, fmt.Sprintf("boot_order: %s is listed more than once", device))
+		}
+		seen[device] = true
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("Error: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// guestEthernetCards returns a guest's network adapters in key order, which is
+// the order of network_interfaces.
+func guestEthernetCards(devices object.VirtualDeviceList) []types.BaseVirtualDevice {
+	cards := devices.SelectByType((*types.VirtualEthernetCard)(nil))
+	sort.Slice(cards, func(i, j int) bool { return cards[i].GetVirtualDevice().Key < cards[j].GetVirtualDevice().Key })
+	return cards
+}
+
+// bootableDevice resolves a boot_order entry to a device of the guest.  disk is
+// the boot disk, network is the first network interface and ethernetN the Nth.
+func bootableDevice(name string, devices object.VirtualDeviceList) (types.BaseVirtualMachineBootOptionsBootableDevice, error) {
+	switch {
+	case name == "cdrom":
+		return &types.VirtualMachineBootOptionsBootableCdromDevice{}, nil
+	case name == "floppy":
+		return &types.VirtualMachineBootOptionsBootableFloppyDevice{}, nil
+	case name == "disk":
+		disk := bootVirtualDisk(devices)
+		if disk == nil {
+			return nil, fmt.Errorf("boot_order: the guest has no disks")
+		}
+		return &types.VirtualMachineBootOptionsBootableDiskDevice{DeviceKey: disk.Key}, nil
+	case name == "network" || strings.HasPrefix(name, "ethernet"):
+		index := 0
+		if name != "network" {
+			index, _ = strconv.Atoi(strings.TrimPrefix(name, "ethernet"))
+		}
+		cards := guestEthernetCards(devices)
+		if index >= len(cards) {
+			return nil, fmt.Errorf("boot_order: the guest has no network interface %d", index)
+		}
+		return &types.VirtualMachineBootOptionsBootableEthernetDevice{DeviceKey: cards[index].GetVirtualDevice().Key}, nil
+	}
+
+	slot, err := vmx.ParseSlot(name)
+	if err != nil {
+		return nil, fmt.Errorf("boot_order: %s", err)
+	}
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		if s, ok := deviceSlot(devices, device); ok && s == slot {
+			return &types.VirtualMachineBootOptionsBootableDiskDevice{DeviceKey: device.GetVirtualDevice().Key}, nil
+		}
+	}
+	return nil, fmt.Errorf("boot_order: the guest has no disk %s", slot)
+}
+
+// bootableDeviceName names a boot order device.  The configured name is kept
+// when it's the same device, so network and ethernet0 don't show a diff.
+func bootableDeviceName(bootable types.BaseVirtualMachineBootOptionsBootableDevice, devices object.VirtualDeviceList, configured string) string {
+	if configured != "" {
+		if device, err := bootableDevice(configured, devices); err == nil && reflect.DeepEqual(device, bootable) {
+			return configured
+		}
+	}
+
+	switch device := bootable.(type) {
+	case *types.VirtualMachineBootOptionsBootableCdromDevice:
+		return "cdrom"
+	case *types.VirtualMachineBootOptionsBootableFloppyDevice:
+		return "floppy"
+	case *types.VirtualMachineBootOptionsBootableDiskDevice:
+		if disk := bootVirtualDisk(devices); disk != nil && disk.Key == device.DeviceKey {
+			return "disk"
+		}
+		if disk := devices.FindByKey(device.DeviceKey); disk != nil {
+			if slot, ok := deviceSlot(devices, disk); ok {
+				return slot.String()
+			}
+		}
+	case *types.VirtualMachineBootOptionsBootableEthernetDevice:
+		for i, card := range guestEthernetCards(devices) {
+			if card.GetVirtualDevice().Key == device.DeviceKey {
+				return vmx.EthernetName(i)
+			}
+		}
+	}
+	return ""
+}
+
+// configSpec returns the reconfigure spec that sets the boot options.  A boot
+// delay of 0 is left out of the spec, so it's set with its vmx key.  Entering the
+// BIOS setup is only set when enter_bios_setup_once changed, so other boot option
+// changes don't send the guest to the BIOS setup again.
+func (options guestBootOptions) configSpec(devices object.VirtualDeviceList) (types.VirtualMachineConfigSpec, error) {
+	boot := &types.VirtualMachineBootOptions{
+		BootDelay:            int64(options.BootDelay),
+		EfiSecureBootEnabled: types.NewBool(options.EfiSecureBootEnabled),
+		BootRetryEnabled:     types.NewBool(options.BootRetryEnabled),
+		BootRetryDelay:       int64(options.BootRetryDelay),
+		BootOrder:            []types.BaseVirtualMachineBootOptionsBootableDevice{},
+	}
+	for _, name := range options.BootOrder {
+		device, err := bootableDevice(name, devices)
+		if err != nil {
+			return types.VirtualMachineConfigSpec{}, err
+		}
+		boot.BootOrder = append(boot.BootOrder, device)
+	}
+	if options.SetEnterBIOSSetup {
+		boot.EnterBIOSSetup = types.NewBool(options.EnterBIOSSetupOnce)
+	}
+
+	spec := types.VirtualMachineConfigSpec{BootOptions: boot}
+	if options.BootDelay == 0 {
+		spec.ExtraConfig = []types.BaseOptionValue{&types.OptionValue{Key: "bios.bootDelay", Value: "0"}}
+	}
+	return spec, nil
+}
+
+// guestBootOptionsFromConfig reads the boot options of a guest.  Boot order
+// devices take their configured names where they match.
+func guestBootOptionsFromConfig(config *types.VirtualMachineConfigInfo, configured []string) guestBootOptions {
+	var options guestBootOptions
+	if config == nil || config.BootOptions == nil {
+		return options
+	}
+	boot := config.BootOptions
+	devices := object.VirtualDeviceList(config.Hardware.Device)
+
+	options.BootDelay = int(boot.BootDelay)
+	options.BootRetryDelay = int(boot.BootRetryDelay)
+	options.EfiSecureBootEnabled = boot.EfiSecureBootEnabled != nil && *boot.EfiSecureBootEnabled
+	options.BootRetryEnabled = boot.BootRetryEnabled != nil && *boot.BootRetryEnabled
+	options.EnterBIOSSetupOnce = boot.EnterBIOSSetup != nil && *boot.EnterBIOSSetup
+	for i, bootable := range boot.BootOrder {
+		name := ""
+		if i < len(configured) {
+			name = configured[i]
+		}
+		if name = bootableDeviceName(bootable, devices, name); name != "" {
+			options.BootOrder = append(options.BootOrder, name)
+		}
+	}
+	return options
+}
+
+// guestGetBootOptions returns the boot options of a guest.
+func guestGetBootOptions(c *Config, vmid string, configured []string) (guestBootOptions, error) {
+	log.Printf("[guestGetBootOptions]\n")
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return guestBootOptions{}, fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return guestBootOptions{}, err
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(gc.Context(), vm.Reference(), []string{"config.bootOptions", "config.hardware.device"}, &vmMo)
+	if err != nil {
+		return guestBootOptions{}, fmt.Errorf("Failed to get guest boot options: %s\n", err)
+	}
+	return guestBootOptionsFromConfig(vmMo.Config, configured), nil
+}
+
+// guestSetBootOptions sets the boot options of a guest.
+func guestSetBootOptions(c *Config, vmid string, options guestBootOptions) error {
+	log.Printf("[guestSetBootOptions] %+v\n", options)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+	devices, err := vm.Device(gc.Context())
+	if err != nil {
+		return fmt.Errorf("Failed to get guest devices: %s\n", err)
+	}
+
+	spec, err := options.configSpec(devices)
+	if err != nil {
+		return fmt.Errorf("Error: %s", err)
+	}
+	task, err := vm.Reconfigure(gc.Context(), spec)
+	if err == nil {
+		err = waitForTask(gc.Context(), task)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to set guest boot options: %s\n", err)
+	}
+	return nil
+}
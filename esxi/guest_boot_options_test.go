@@ -0,0 +1,116 @@
+package esxi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+)
+
+// TestGuestBootOptionsValidate verifies secure boot requires EFI and a recent hardware version
+func TestGuestBootOptionsValidate(t *testing.T) {
+	secure := guestBootOptions{EfiSecureBootEnabled: true}
+
+	if err := secure.validate("efi", 14); err != nil {
+		t.Errorf("Expected secure boot with efi to be valid, got %v", err)
+	}
+	if err := secure.validate("efi", 0); err != nil {
+		t.Errorf("Expected an unknown virthwver not to be checked, got %v", err)
+	}
+	if err := secure.validate("bios", 14); err == nil || !strings.Contains(err.Error(), "boot_firmware efi") {
+		t.Errorf("Expected secure boot with bios to be rejected, got %v", err)
+	}
+	if err := secure.validate("efi", 10); err == nil || !strings.Contains(err.Error(), "virthwver 13") {
+		t.Errorf("Expected secure boot with virthwver 10 to be rejected, got %v", err)
+	}
+	if err := (guestBootOptions{BootOrder: []string{"network", "disk", "network"}}).validate("bios", 0); err == nil {
+		t.Error("Expected a repeated boot device to be rejected")
+	}
+}
+
+// TestGuestBootOptionsEnterBIOSSetup verifies entering the BIOS setup is only sent when it changed
+func TestGuestBootOptionsEnterBIOSSetup(t *testing.T) {
+	options := guestBootOptions{BootDelay: 500, EnterBIOSSetupOnce: true}
+	spec, err := options.configSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.BootOptions.EnterBIOSSetup != nil {
+		t.Errorf("Expected EnterBIOSSetup to be left out, got %v", *spec.BootOptions.EnterBIOSSetup)
+	}
+
+	for _, enter := range []bool{true, false} {
+		options = guestBootOptions{EnterBIOSSetupOnce: enter, SetEnterBIOSSetup: true}
+		spec, err = options.configSpec(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.BootOptions.EnterBIOSSetup == nil || *spec.BootOptions.EnterBIOSSetup != enter {
+			t.Errorf("Expected EnterBIOSSetup %t, got %v", enter, spec.BootOptions.EnterBIOSSetup)
+		}
+	}
+}
+
+// TestGuestBootOptionsGovmomi verifies setting a guest's boot options with the vcsim simulator
+func TestGuestBootOptionsGovmomi(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("Failed to find a guest: %v", err)
+	}
+	vmid := vms[0].Reference().Value
+
+	//  PXE first, then the boot disk.
+	options := guestBootOptions{BootOrder: []string{"network", "cdrom", "disk"}, BootDelay: 2000, BootRetryEnabled: true, BootRetryDelay: 5000}
+	if err = guestSetBootOptions(config, vmid, options); err != nil {
+		t.Fatalf("Failed to set boot options: %v", err)
+	}
+	got, err := guestGetBootOptions(config, vmid, options.BootOrder)
+	if err != nil {
+		t.Fatalf("Failed to get boot options: %v", err)
+	}
+	if !reflect.DeepEqual(got, options) {
+		t.Errorf("Expected %+v, got %+v", options, got)
+	}
+
+	//  Without a configured boot order, devices get their own names.
+	got, err = guestGetBootOptions(config, vmid, nil)
+	if err != nil {
+		t.Fatalf("Failed to get boot options: %v", err)
+	}
+	if expected := []string{"ethernet0", "cdrom", "disk"}; !reflect.DeepEqual(got.BootOrder, expected) {
+		t.Errorf("Expected boot order %q, got %q", expected, got.BootOrder)
+	}
+
+	if err = guestSetBootOptions(config, vmid, guestBootOptions{BootOrder: []string{"ethernet5"}}); err == nil {
+		t.Error("Expected a missing network interface to be rejected")
+	}
+	if err = guestSetBootOptions(config, vmid, guestBootOptions{EfiSecureBootEnabled: true}); err == nil {
+		t.Error("Expected secure boot on a bios guest to be rejected")
+	}
+}
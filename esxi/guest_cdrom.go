@@ -0,0 +1,388 @@
+package esxi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var cdromControllerTypes = map[string]bool{"ide": true, "sata": true}
+
+// freeCdromSlot returns the first free slot for a cdrom.  ide1 is used before ide0, which
+// usually has the boot disk of ide guests.
+func freeCdromSlot(used map[vmx.Slot]bool, bus string) (vmx.Slot, bool) {
+	var slots []vmx.Slot
+	switch bus {
+	case "ide":
+		slots = []vmx.Slot{{Bus: "ide", Controller: 1, Unit: 0}, {Bus: "ide", Controller: 1, Unit: 1}, {Bus: "ide", Controller: 0, Unit: 0}, {Bus: "ide", Controller: 0, Unit: 1}}
+	case "sata":
+		for controller := 0; controller < diskBusLimits["sata"].controllers; controller++ {
+			for unit := 0; unit < diskBusLimits["sata"].units; unit++ {
+				slots = append(slots, vmx.Slot{Bus: "sata", Controller: controller, Unit: unit})
+			}
+		}
+	}
+	for _, slot := range slots {
+		if !used[slot] {
+			return slot, true
+		}
+	}
+	return vmx.Slot{}, false
+}
+
+// guestCdrom is a guest cdrom drive.
+type guestCdrom struct {
+	IsoPath        string // datastore path of the ISO, empty for an empty drive
+	LocalIsoPath   string // local ISO that is uploaded to the guest's directory
+	ControllerType string // ide or sata
+	Connected      bool
+	StartConnected bool
+
+	Slot vmx.Slot // read only
+}
+
+// Get cdroms from the resource config.
+func guestCdromsFromResourceData(d resourceChanges) ([]guestCdrom, error) {
+	count := d.Get("cdrom.#").(int)
+	if count == 0 {
+		return nil, nil
+	}
+	cdroms := make([]guestCdrom, 0, count)
+	for i := 0; i < count; i++ {
+		prefix := fmt.Sprintf("cdrom.%d.", i)
+
+		cdrom := guestCdrom{
+			IsoPath:        isoDatastorePath(d.Get(prefix + "iso_path").(string)),
+			LocalIsoPath:   d.Get(prefix + "local_iso_path").(string),
+			ControllerType: d.Get(prefix + "controller_type").(string),
+			Connected:      d.Get(prefix + "connected").(bool),
+			StartConnected: d.Get(prefix + "start_connected").(bool),
+		}
+		if cdrom.LocalIsoPath != "" {
+			//  iso_path is computed from local_iso_path, so it's only an error if it changed.
+			if d.HasChange(prefix+"iso_path") && cdrom.IsoPath != "" {
+				return nil, fmt.Errorf("Error: cdrom.%d: iso_path and local_iso_path can't both be set", i)
+			}
+			cdrom.IsoPath = ""
+		} else if d.HasChange(prefix+"local_iso_path") && !d.HasChange(prefix+"iso_path") {
+			//  Removing local_iso_path empties the drive, unless iso_path is set too.
+			cdrom.IsoPath = ""
+		}
+		if !cdromControllerTypes[cdrom.ControllerType] {
+			return nil, fmt.Errorf("Error: cdrom.%d: invalid controller_type %q, must be ide or sata", i, cdrom.ControllerType)
+		}
+		cdroms = append(cdroms, cdrom)
+	}
+	return cdroms, nil
+}
+
+// Convert cdroms to the cdrom attribute.
+func guestCdromsToResourceData(cdroms []guestCdrom) []map[string]interface{} {
+	if len(cdroms) == 0 {
+		return nil
+	}
+	out := make([]map[string]interface{}, 0, len(cdroms))
+	for _, cdrom := range cdroms {
+		out = append(out, map[string]interface{}{
+			"iso_path":        cdrom.IsoPath,
+			"local_iso_path":  cdrom.LocalIsoPath,
+			"controller_type": cdrom.ControllerType,
+			"connected":       cdrom.Connected,
+			"start_connected": cdrom.StartConnected,
+		})
+	}
+	return out
+}
+
+// isoDatastorePath converts "[datastore] path" to /vmfs/volumes/datastore/path.
+func isoDatastorePath(s string) string {
+	var p object.DatastorePath
+	if strings.HasPrefix(s, "[") && p.FromString(s) {
+		return "/vmfs/volumes/" + p.Datastore + "/" + p.Path
+	}
+	return s
+}
+
+// Suppress the diff between the two forms of the same ISO path.
+func isoPathDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	return isoDatastorePath(old) == isoDatastorePath(new)
+}
+
+func slotLess(a, b vmx.Slot) bool {
+	if a.Bus != b.Bus {
+		return a.Bus < b.Bus
+	}
+	if a.Controller != b.Controller {
+		return a.Controller < b.Controller
+	}
+	return a.Unit < b.Unit
+}
+
+// guestCdromsFromVmx reads the ide and sata cdroms from a vmx file, ordered by slot.  The
+// runtime connected state isn't in the vmx, so Connected is set to StartConnected.
+func guestCdromsFromVmx(doc *vmx.Document) []guestCdrom {
+	var cdroms []guestCdrom
+	for _, disk := range doc.Disks() {
+		if !cdromControllerTypes[disk.Slot.Bus] || !disk.IsCdrom() || !disk.Present {
+			continue
+		}
+		cdrom := guestCdrom{
+			ControllerType: disk.Slot.Bus,
+			Connected:      disk.StartConnected,
+			StartConnected: disk.StartConnected,
+			Slot:           disk.Slot,
+		}
+		if strings.EqualFold(disk.DeviceType, "cdrom-image") {
+			cdrom.IsoPath = disk.FileName
+		}
+		cdroms = append(cdroms, cdrom)
+	}
+	sort.Slice(cdroms, func(i, j int) bool { return slotLess(cdroms[i].Slot, cdroms[j].Slot) })
+	return cdroms
+}
+
+// guestCdromsToVmx updates the cdroms in a vmx file.  Cdroms are matched to the
+// existing drives in slot order, extra drives are removed and new ones are added to
+// the first free slot on their controller type.
+func guestCdromsToVmx(doc *vmx.Document, cdroms []guestCdrom) error {
+	existing := guestCdromsFromVmx(doc)
+
+	used := make(map[vmx.Slot]bool)
+	for _, disk := range doc.Disks() {
+		used[disk.Slot] = true
+	}
+
+	//  Remove drives that are no longer configured, or are moving to another controller type.
+	for i, cur := range existing {
+		if i >= len(cdroms) || cdroms[i].ControllerType != cur.ControllerType {
+			log.Printf("[guestCdromsToVmx] Delete %s\n", cur.Slot)
+			doc.DeleteDevice(cur.Slot.String())
+			used[cur.Slot] = false
+		}
+	}
+
+	for i, cdrom := range cdroms {
+		var slot vmx.Slot
+		if i < len(existing) && existing[i].ControllerType == cdrom.ControllerType {
+			slot = existing[i].Slot
+		} else {
+			var found bool
+			slot, found = freeCdromSlot(used, cdrom.ControllerType)
+			if !found {
+				return fmt.Errorf("no free %s slot for cdrom.%d", cdrom.ControllerType, i)
+			}
+			used[slot] = true
+			log.Printf("[guestCdromsToVmx] Create %s\n", slot)
+		}
+
+		if cdrom.IsoPath != "" {
+			log.Printf("[guestCdromsToVmx] %s: %s\n", slot, cdrom.IsoPath)
+			doc.Set(slot.Key("deviceType"), "cdrom-image")
+			doc.Set(slot.Key("fileName"), cdrom.IsoPath)
+			doc.Delete(slot.Key("clientDevice"))
+		} else {
+			log.Printf("[guestCdromsToVmx] %s: empty\n", slot)
+			doc.Set(slot.Key("deviceType"), "atapi-cdrom")
+			doc.Set(slot.Key("fileName"), "emptyBackingString")
+			doc.Set(slot.Key("clientDevice"), "TRUE")
+		}
+		if doc.Bool(slot.Key("startConnected"), true) != cdrom.StartConnected {
+			doc.SetBool(slot.Key("startConnected"), cdrom.StartConnected)
+		}
+		doc.Set(slot.Key("present"), "TRUE")
+
+		if slot.Bus == "sata" && !doc.Bool(slot.ControllerName()+".present", false) {
+			doc.Set(slot.ControllerName()+".present", "TRUE")
+		}
+	}
+	return nil
+}
+
+// guestReadCdroms reads a guest's cdroms from its vmx file.
+func guestReadCdroms(c *Config, vmid string) ([]guestCdrom, error) {
+	log.Printf("[guestReadCdroms]\n")
+
+	vmx_contents, err := readVmx_contents(c, vmid)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get vmx contents: %s\n", err)
+	}
+	return guestCdromsFromVmx(vmx.Parse(vmx_contents)), nil
+}
+
+// guestUploadIsos copies local ISOs to the guest's directory, unless a file with the
+// same size and sha256 checksum is already there, and sets their IsoPath.
+func guestUploadIsos(c *Config, vmid string, cdroms []guestCdrom) error {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Printf("[guestUploadIsos]\n")
+
+	for i := range cdroms {
+		if cdroms[i].LocalIsoPath == "" {
+			continue
+		}
+		info, err := os.Stat(cdroms[i].LocalIsoPath)
+		if err != nil {
+			return fmt.Errorf("Failed to read local_iso_path: %s\n", err)
+		}
+
+		dst_vmx_file, err := getDst_vmx_file(c, vmid)
+		if err != nil {
+			return fmt.Errorf("Failed to get guest path: %s\n", err)
+		}
+		dst := path.Join(path.Dir(dst_vmx_file), filepath.Base(cdroms[i].LocalIsoPath))
+		cdroms[i].IsoPath = dst
+
+		remote_cmd := shellCommand("stat", "-c", "%s", dst)
+		stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "get iso size")
+		if size, _ := strconv.ParseInt(strings.TrimSpace(stdout), 10, 64); err == nil && size == info.Size() {
+			//  Only hash the ISOs when the sizes match.
+			local_sum, err := localFileSHA256(cdroms[i].LocalIsoPath)
+			if err != nil {
+				return fmt.Errorf("Failed to read local_iso_path: %s\n", err)
+			}
+			remote_cmd = shellCommand("sha256sum", dst)
+			stdout, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "get iso sha256")
+			if err == nil && sha256sumOutput(stdout) == local_sum {
+				log.Printf("[guestUploadIsos] %s is already uploaded\n", dst)
+				continue
+			}
+		}
+
+		log.Printf("[guestUploadIsos] Upload %s to %s\n", cdroms[i].LocalIsoPath, dst)
+		err = copyFileToRemote(esxiConnInfo, cdroms[i].LocalIsoPath, dst, "upload iso")
+		if err != nil {
+			return fmt.Errorf("Failed to upload %s: %s\n", cdroms[i].LocalIsoPath, err)
+		}
+	}
+	return nil
+}
+
+// localFileSHA256 returns the sha256 checksum of a local file.
+func localFileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sha256sumOutput returns the checksum printed by sha256sum, or "".
+func sha256sumOutput(stdout string) string {
+	fields := strings.Fields(stdout)
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// guestGetCdromConnected sets the Connected state of cdroms read from a running guest's
+// vmx file.
+func guestGetCdromConnected(c *Config, vmid string, cdroms []guestCdrom) error {
+	log.Printf("[guestGetCdromConnected]\n")
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+	devices, err := vm.Device(gc.Context())
+	if err != nil {
+		return fmt.Errorf("Failed to get guest devices: %s\n", err)
+	}
+
+	connected := make(map[vmx.Slot]bool)
+	for _, device := range devices.SelectByType((*types.VirtualCdrom)(nil)) {
+		base := device.GetVirtualDevice()
+		if slot, ok := deviceSlot(devices, device); ok && base.Connectable != nil {
+			connected[slot] = base.Connectable.Connected
+		}
+	}
+	for i := range cdroms {
+		if state, ok := connected[cdroms[i].Slot]; ok {
+			cdroms[i].Connected = state
+		}
+	}
+	return nil
+}
+
+// guestSetCdromConnected connects or disconnects a running guest's ISO backed cdroms.
+// cdroms must be in slot order, as returned by guestCdromsFromVmx.
+func guestSetCdromConnected(c *Config, vmid string, cdroms []guestCdrom) error {
+	log.Printf("[guestSetCdromConnected]\n")
+
+	current, err := guestReadCdroms(c, vmid)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[vmx.Slot]bool)
+	for i, cdrom := range current {
+		if i < len(cdroms) && cdrom.IsoPath != "" {
+			wanted[cdrom.Slot] = cdroms[i].Connected
+		}
+	}
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+	devices, err := vm.Device(gc.Context())
+	if err != nil {
+		return fmt.Errorf("Failed to get guest devices: %s\n", err)
+	}
+
+	var errs []string
+	for _, device := range devices.SelectByType((*types.VirtualCdrom)(nil)) {
+		base := device.GetVirtualDevice()
+		slot, ok := deviceSlot(devices, device)
+		if !ok {
+			continue
+		}
+		connected, ok := wanted[slot]
+		if !ok || base.Connectable == nil || base.Connectable.Connected == connected {
+			continue
+		}
+
+		log.Printf("[guestSetCdromConnected] %s connected: %t\n", slot, connected)
+		if connected {
+			err = devices.Connect(device)
+		} else {
+			err = devices.Disconnect(device)
+		}
+		if err == nil {
+			err = vm.EditDevice(gc.Context(), device)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", slot, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("Failed to set cdrom connected state: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package esxi
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+)
+
+// TestIsoDatastorePath verifies both forms of an ISO path are accepted
+func TestIsoDatastorePath(t *testing.T) {
+	tests := map[string]string{
+		"[datastore1] iso/os.iso":         "/vmfs/volumes/datastore1/iso/os.iso",
+		"[data store] os.iso":             "/vmfs/volumes/data store/os.iso",
+		"/vmfs/volumes/datastore1/os.iso": "/vmfs/volumes/datastore1/os.iso",
+		"":                                "",
+	}
+	for in, expected := range tests {
+		if got := isoDatastorePath(in); got != expected {
+			t.Errorf("isoDatastorePath(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+	if !isoPathDiffSuppress("", "/vmfs/volumes/ds1/a.iso", "[ds1] a.iso", nil) {
+		t.Error("the same ISO in both forms should not be a diff")
+	}
+}
+
+// TestGuestCdromsToVmx verifies cdroms are matched by position, moved between controllers and removed
+func TestGuestCdromsToVmx(t *testing.T) {
+	doc := vmx.Parse("ide0:0.fileName = \"boot.vmdk\"\n" +
+		"ide0:0.present = \"TRUE\"\n" +
+		"ide1:0.present = \"TRUE\"\n" +
+		"ide1:0.fileName = \"emptyBackingString\"\n" +
+		"ide1:0.deviceType = \"atapi-cdrom\"\n" +
+		"ide1:0.startConnected = \"FALSE\"\n" +
+		"ide1:0.clientDevice = \"TRUE\"\n")
+
+	err := guestCdromsToVmx(doc, []guestCdrom{
+		{IsoPath: "/vmfs/volumes/ds1/iso/os.iso", ControllerType: "ide", StartConnected: true},
+		{IsoPath: "/vmfs/volumes/ds1/iso/tools.iso", ControllerType: "ide", StartConnected: false},
+		{ControllerType: "sata", StartConnected: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []guestCdrom{
+		{IsoPath: "/vmfs/volumes/ds1/iso/os.iso", ControllerType: "ide", Connected: true, StartConnected: true, Slot: vmx.Slot{Bus: "ide", Controller: 1, Unit: 0}},
+		{IsoPath: "/vmfs/volumes/ds1/iso/tools.iso", ControllerType: "ide", Slot: vmx.Slot{Bus: "ide", Controller: 1, Unit: 1}},
+		{ControllerType: "sata", Connected: true, StartConnected: true, Slot: vmx.Slot{Bus: "sata", Controller: 0, Unit: 0}},
+	}
+	if cdroms := guestCdromsFromVmx(doc); !reflect.DeepEqual(cdroms, expected) {
+		t.Errorf("guestCdromsFromVmx =\n%+v\nexpected\n%+v", cdroms, expected)
+	}
+	if doc.Has("ide1:0.clientDevice") || doc.Value("sata0.present") != "TRUE" || doc.Value("sata0:0.clientDevice") != "TRUE" {
+		t.Errorf("unexpected vmx:\n%s", doc)
+	}
+	if doc.Value("ide0:0.fileName") != "boot.vmdk" {
+		t.Error("the boot disk should not be changed")
+	}
+
+	//  Move the first cdrom to sata and remove the others.
+	err = guestCdromsToVmx(doc, []guestCdrom{{IsoPath: "/vmfs/volumes/ds1/iso/os.iso", ControllerType: "sata", StartConnected: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []guestCdrom{
+		{IsoPath: "/vmfs/volumes/ds1/iso/os.iso", ControllerType: "sata", Connected: true, StartConnected: true, Slot: vmx.Slot{Bus: "sata", Controller: 0, Unit: 0}},
+	}
+	if cdroms := guestCdromsFromVmx(doc); !reflect.DeepEqual(cdroms, expected) {
+		t.Errorf("guestCdromsFromVmx =\n%+v\nexpected\n%+v", cdroms, expected)
+	}
+	if doc.HasDevice("ide1:0") || doc.HasDevice("ide1:1") {
+		t.Errorf("ide cdroms should be removed:\n%s", doc)
+	}
+
+	//  Adopting a guest reports cdrom changes.
+	cur := guestAdoptState{cdroms: expected}
+	want := guestAdoptState{cdroms: []guestCdrom{{IsoPath: "/vmfs/volumes/ds1/iso/new.iso", ControllerType: "sata", StartConnected: true}}}
+	changes := cur.changesTo(want)
+	if !reflect.DeepEqual(changes, []string{`cdrom.0.iso_path: "/vmfs/volumes/ds1/iso/os.iso" => "/vmfs/volumes/ds1/iso/new.iso"`}) {
+		t.Errorf("unexpected adopt changes %q", changes)
+	}
+	if changes := cur.changesTo(guestAdoptState{}); len(changes) != 0 {
+		t.Errorf("cdroms should be left alone when none are configured, got %q", changes)
+	}
+}
+
+// TestIsoChecksum verifies a local ISO's checksum matches the sha256sum output of the host
+func TestIsoChecksum(t *testing.T) {
+	iso := filepath.Join(t.TempDir(), "os.iso")
+	if err := ioutil.WriteFile(iso, []byte("iso contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := localFileSHA256(iso)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := strings.ToUpper(sum) + "  /vmfs/volumes/datastore1/vm/os.iso\n"
+	if got := sha256sumOutput(stdout); got != sum {
+		t.Errorf("sha256sumOutput = %q, expected %q", got, sum)
+	}
+	for _, stdout := range []string{"", "sha256sum: can't open 'os.iso': No such file or directory"} {
+		if got := sha256sumOutput(stdout); got != "" {
+			t.Errorf("sha256sumOutput(%q) = %q, expected none", stdout, got)
+		}
+	}
+}
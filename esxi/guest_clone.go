@@ -0,0 +1,455 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// cloneDisk is a disk of a clone source and the file it becomes in the clone.
+// Source is a datastore path ("[ds] dir/name.vmdk"), File is relative to the
+// clone's directory.
+type cloneDisk struct {
+	Slot        vmx.Slot
+	Source      string
+	File        string
+	AdapterType types.VirtualDiskAdapterType
+}
+
+// cloneDiskAdapterType returns the adapter type a copy of a disk on a controller is
+// created with.  The api only has ide, busLogic and lsiLogic, so sata disks keep
+// the ide geometry and nvme disks the lsiLogic one, as esxi gives them.
+func cloneDiskAdapterType(controller types.BaseVirtualDevice) types.VirtualDiskAdapterType {
+	switch controller.(type) {
+	case *types.VirtualIDEController, types.BaseVirtualSATAController:
+		return types.VirtualDiskAdapterTypeIde
+	case *types.VirtualBusLogicController:
+		return types.VirtualDiskAdapterTypeBusLogic
+	}
+	return types.VirtualDiskAdapterTypeLsiLogic
+}
+
+// cloneDisksFromDevices returns the disks of a source guest or snapshot, and names
+// their files in the clone after guest_name.  The boot disk comes first, as
+// <guest_name>.vmdk, then the other disks in slot order.
+func cloneDisksFromDevices(devices object.VirtualDeviceList, guest_name string) []cloneDisk {
+	var boot_key int32 = -1
+	if boot := bootVirtualDisk(devices); boot != nil {
+		boot_key = boot.Key
+	}
+
+	var disks []cloneDisk
+	var boot_disk *cloneDisk
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		slot, ok := deviceSlot(devices, device)
+		if !ok {
+			continue
+		}
+		backing, ok := device.(*types.VirtualDisk).Backing.(types.BaseVirtualDeviceFileBackingInfo)
+		if !ok {
+			continue
+		}
+		disk := cloneDisk{
+			Slot:        slot,
+			Source:      backing.GetVirtualDeviceFileBackingInfo().FileName,
+			AdapterType: cloneDiskAdapterType(devices.FindByKey(device.GetVirtualDevice().ControllerKey)),
+		}
+		if device.GetVirtualDevice().Key == boot_key {
+			boot_disk = &disk
+			continue
+		}
+		disks = append(disks, disk)
+	}
+	sort.Slice(disks, func(i, j int) bool { return slotLess(disks[i].Slot, disks[j].Slot) })
+	if boot_disk != nil {
+		disks = append([]cloneDisk{*boot_disk}, disks...)
+	}
+	nameCloneDisks(disks, guest_name)
+	return disks
+}
+
+// nameCloneDisks names the files of the disks in the clone: the first disk is
+// <guest_name>.vmdk, the others <guest_name>_<n>.vmdk.
+func nameCloneDisks(disks []cloneDisk, guest_name string) {
+	for i := range disks {
+		if i == 0 {
+			disks[i].File = guest_name + ".vmdk"
+		} else {
+			disks[i].File = fmt.Sprintf("%s_%d.vmdk", guest_name, i)
+		}
+	}
+}
+
+// cloneVmx turns a copy of the source's vmx into the clone's vmx.  The identity of
+// the source (uuids, MACs, the vm's own files) is removed so esxi generates new
+// ones, and its disks are replaced by the clone's disks.
+func cloneVmx(doc *vmx.Document, guest_name string, disks []cloneDisk) {
+	doc.Set("displayName", guest_name)
+	doc.Set("nvram", guest_name+".nvram")
+
+	for _, key := range []string{"uuid.bios", "uuid.location", "vc.uuid", "vmci0.id", "migrate.hostLog",
+		"sched.swap.derivedName", "checkpoint.vmState", "extendedConfigFile", "vmotion.checkpointFBSize"} {
+		doc.Delete(key)
+	}
+
+	//  Static MACs are set again from network_interfaces.
+	for _, ethernet := range doc.Ethernets() {
+		name := vmx.EthernetName(ethernet.Index)
+		doc.Delete(name + ".generatedAddress")
+		doc.Delete(name + ".generatedAddressOffset")
+		doc.Delete(name + ".address")
+		if ethernet.AddressType != "" {
+			doc.Set(name+".addressType", "generated")
+		}
+	}
+
+	vmxRemoveDisks(doc, vmx.Slot{})
+	for _, disk := range disks {
+		if disk.Slot.Bus == "scsi" {
+			doc.Set(disk.Slot.Key("deviceType"), "scsi-hardDisk")
+		}
+		doc.Set(disk.Slot.Key("fileName"), disk.File)
+		doc.Set(disk.Slot.Key("present"), "TRUE")
+	}
+}
+
+// snapshotControllers returns the disk controllers of a snapshot's devices
+func snapshotControllers(devices object.VirtualDeviceList) []guestController {
+	var controllers []guestController
+	for _, device := range devices {
+		var ctrl guestController
+		switch controller := device.(type) {
+		case *types.ParaVirtualSCSIController:
+			ctrl = guestController{Type: "pvscsi", BusNumber: int(controller.BusNumber)}
+		case *types.VirtualLsiLogicController:
+			ctrl = guestController{Type: "lsilogic", BusNumber: int(controller.BusNumber)}
+		case *types.VirtualLsiLogicSASController:
+			ctrl = guestController{Type: "lsilogic-sas", BusNumber: int(controller.BusNumber)}
+		case *types.VirtualBusLogicController:
+			ctrl = guestController{Type: "buslogic", BusNumber: int(controller.BusNumber)}
+		case types.BaseVirtualSATAController:
+			ctrl = guestController{Type: "sata", BusNumber: int(controller.GetVirtualSATAController().BusNumber)}
+		case *types.VirtualNVMEController:
+			ctrl = guestController{Type: "nvme", BusNumber: int(controller.BusNumber)}
+		default:
+			continue
+		}
+		controllers = append(controllers, ctrl)
+	}
+	return controllers
+}
+
+// snapshotEthernetsToVmx sets the ethernets of a vmx to the ethernet cards of a
+// snapshot.  An ethernet card with key 4000+N is ethernetN.  Ethernets still on
+// the same network keep their settings, the others are created again.
+func snapshotEthernetsToVmx(doc *vmx.Document, devices object.VirtualDeviceList) {
+	cards := make(map[int]types.BaseVirtualEthernetCard)
+	for _, device := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		card := device.(types.BaseVirtualEthernetCard)
+		cards[int(card.GetVirtualEthernetCard().Key-4000)] = card
+	}
+	for _, ethernet := range doc.Ethernets() {
+		if _, ok := cards[ethernet.Index]; !ok {
+			doc.DeleteDevice(vmx.EthernetName(ethernet.Index))
+		}
+	}
+
+	indexes := make([]int, 0, len(cards))
+	for index := range cards {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		card := cards[index]
+		name := vmx.EthernetName(index)
+		eth := card.GetVirtualEthernetCard()
+
+		virtualDev := doc.Value(name + ".virtualDev")
+		switch card.(type) {
+		case *types.VirtualE1000:
+			virtualDev = "e1000"
+		case *types.VirtualE1000e:
+			virtualDev = "e1000e"
+		case *types.VirtualVmxnet3:
+			virtualDev = "vmxnet3"
+		case *types.VirtualVmxnet2, *types.VirtualVmxnet:
+			virtualDev = "vmxnet"
+		case *types.VirtualPCNet32:
+			virtualDev = "vlance"
+		}
+		if virtualDev == "" {
+			virtualDev = "e1000"
+		}
+
+		switch backing := eth.Backing.(type) {
+		case *types.VirtualEthernetCardNetworkBackingInfo:
+			if doc.Value(name+".networkName") != backing.DeviceName || doc.Has(name+".dvs.portgroupId") {
+				doc.DeleteDevice(name)
+				doc.Set(name+".networkName", backing.DeviceName)
+			}
+		case *types.VirtualEthernetCardDistributedVirtualPortBackingInfo:
+			if doc.Value(name+".dvs.portgroupId") != backing.Port.PortgroupKey {
+				doc.DeleteDevice(name)
+				doc.Set(name+".dvs.switchId", backing.Port.SwitchUuid)
+				doc.Set(name+".dvs.portgroupId", backing.Port.PortgroupKey)
+			}
+		}
+		doc.Set(name+".virtualDev", virtualDev)
+		doc.Set(name+".present", "TRUE")
+		if !doc.Has(name + ".addressType") {
+			doc.Set(name+".addressType", "generated")
+		}
+		if eth.Connectable != nil {
+			doc.SetBool(name+".startConnected", eth.Connectable.StartConnected)
+		}
+	}
+}
+
+// snapshotVmx reconciles a copy of the source's current vmx with the config of
+// the snapshot a clone is made from: its hardware, disk controllers and
+// ethernets.  The disks are set by cloneVmx.
+func snapshotVmx(doc *vmx.Document, config *types.VirtualMachineConfigInfo) {
+	doc.Set("memsize", strconv.Itoa(int(config.Hardware.MemoryMB)))
+	doc.Set("numvcpus", strconv.Itoa(int(config.Hardware.NumCPU)))
+	if config.Hardware.NumCoresPerSocket > 0 {
+		doc.Set("cpuid.coresPerSocket", strconv.Itoa(int(config.Hardware.NumCoresPerSocket)))
+	}
+	if config.GuestId != "" {
+		doc.Set("guestOS", config.GuestId)
+	}
+	if version := strings.TrimPrefix(config.Version, "vmx-"); version != "" {
+		doc.Set("virtualHW.version", version)
+	}
+	if config.Firmware != "" {
+		doc.Set("firmware", config.Firmware)
+	}
+
+	devices := object.VirtualDeviceList(config.Hardware.Device)
+	guestControllersToVmx(doc, snapshotControllers(devices), [60][2]string{})
+	snapshotEthernetsToVmx(doc, devices)
+}
+
+// guestCloneDisks copies the source disks into the clone's directory on
+// disk_store.  For a linked clone, delta disks are created over them instead.
+func guestCloneDisks(gc *GovmomiClient, disks []cloneDisk, disk_store, guest_name string, linked_clone bool, boot_disk_type string) error {
+	var diskType string
+	switch boot_disk_type {
+	case "zeroedthick":
+		diskType = string(types.VirtualDiskTypeThick)
+	case "eagerzeroedthick":
+		diskType = string(types.VirtualDiskTypeEagerZeroedThick)
+	default:
+		diskType = string(types.VirtualDiskTypeThin)
+	}
+
+	dm := object.NewVirtualDiskManager(gc.Client.Client)
+	for _, disk := range disks {
+		dst_path := object.DatastorePath{Datastore: disk_store, Path: guest_name + "/" + disk.File}
+		dst := dst_path.String()
+
+		var task *object.Task
+		var err error
+		if linked_clone {
+			log.Printf("[guestCloneDisks] %s: %s over %s\n", disk.Slot, dst, disk.Source)
+			task, err = dm.CreateChildDisk(gc.Context(), disk.Source, nil, dst, nil, true)
+		} else {
+			log.Printf("[guestCloneDisks] %s: copy %s to %s\n", disk.Slot, disk.Source, dst)
+			spec := &types.VirtualDiskSpec{
+				DiskType:    diskType,
+				AdapterType: string(disk.AdapterType),
+			}
+			task, err = dm.CopyVirtualDisk(gc.Context(), disk.Source, nil, dst, nil, spec, false)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create disk %s: %w", dst, err)
+		}
+		if err = waitForTask(gc.Context(), task); err != nil {
+			return fmt.Errorf("failed to create disk %s: %w", dst, err)
+		}
+	}
+	return nil
+}
+
+// guestRegisterVmx registers a vmx file in a resource pool and returns the new vmid
+func guestRegisterVmx(c *Config, vmx_path, guest_name, resource_pool_name string) (string, error) {
+	log.Printf("[guestRegisterVmx] %s\n", vmx_path)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	poolID, err := getPoolID(c, resource_pool_name)
+	if err != nil {
+		return "", fmt.Errorf("failed to use resource pool %s: %w", resource_pool_name, err)
+	}
+	pool := object.NewResourcePool(gc.Client.Client, types.ManagedObjectReference{Type: "ResourcePool", Value: poolID})
+
+	folders, err := gc.Datacenter.Folders(gc.Context())
+	if err != nil {
+		return "", fmt.Errorf("failed to get vm folder: %w", err)
+	}
+
+	task, err := folders.VmFolder.RegisterVM(gc.Context(), vmx_path, guest_name, false, pool, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to register guest: %w", err)
+	}
+	info, err := task.WaitForResult(gc.Context(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to register guest: %w", err)
+	}
+
+	ref, ok := info.Result.(types.ManagedObjectReference)
+	if !ok {
+		return "", fmt.Errorf("failed to register guest: unexpected task result %T", info.Result)
+	}
+	return ref.Value, nil
+}
+
+// guestClone creates a guest on the esxi host from clone_from_vm, without
+// ovftool.  The disks and hardware are copied from clone_snapshot if it's set,
+// otherwise from the source's current disks and vmx.  A powered on source is snapshotted first, so its
+// disks are copied in a consistent state.  For a linked clone, delta disks are
+// created over clone_snapshot instead of copies.
+func guestClone(c *Config, guest_name, disk_store, clone_from_vm, clone_snapshot string, linked_clone bool,
+	boot_disk_type, resource_pool_name string, rollback *guestRollback) error {
+	log.Printf("[guestClone] %s from %s snapshot %q linked %t\n", guest_name, clone_from_vm, clone_snapshot, linked_clone)
+
+	//  The source may be given with its resource pool path.
+	src_name := clone_from_vm[strings.LastIndex(clone_from_vm, "/")+1:]
+	src_vmid, err := guestGetVMID(c, src_name)
+	if err != nil {
+		return err
+	}
+	if src_vmid == "" {
+		return fmt.Errorf("Source guest %s not found\n", clone_from_vm)
+	}
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	src_vm, err := getVMByID(gc, src_vmid)
+	if err != nil {
+		return err
+	}
+
+	var srcMo mo.VirtualMachine
+	err = src_vm.Properties(gc.Context(), src_vm.Reference(), []string{"runtime.powerState", "config.hardware.device"}, &srcMo)
+	if err != nil {
+		return fmt.Errorf("Failed to read source guest %s: %s\n", clone_from_vm, err)
+	}
+
+	//  A running source is copied from a temporary snapshot.
+	if clone_snapshot == "" && srcMo.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		clone_snapshot, err = guestSnapshotCreate(c, src_vmid, "terraform-clone-"+guest_name, "Temporary snapshot to clone "+guest_name, false, false)
+		if err != nil {
+			return fmt.Errorf("Failed to snapshot running source guest %s: %s\n", clone_from_vm, err)
+		}
+		temp_snapshot := clone_snapshot
+		defer func() {
+			if err := guestSnapshotRemove(c, src_vmid, temp_snapshot, false); err != nil {
+				log.Printf("[guestClone] Failed to remove temporary snapshot %s of %s: %s\n", temp_snapshot, clone_from_vm, err)
+			}
+		}()
+	}
+
+	devices := object.VirtualDeviceList(srcMo.Config.Hardware.Device)
+	var snapshotMo mo.VirtualMachineSnapshot
+	if clone_snapshot != "" {
+		snapshot_ref, err := src_vm.FindSnapshot(gc.Context(), clone_snapshot)
+		if err != nil {
+			return fmt.Errorf("Failed to find snapshot %s of %s: %s\n", clone_snapshot, clone_from_vm, err)
+		}
+
+		err = src_vm.Properties(gc.Context(), *snapshot_ref, []string{"config"}, &snapshotMo)
+		if err != nil {
+			return fmt.Errorf("Failed to read snapshot %s: %s\n", clone_snapshot, err)
+		}
+		devices = snapshotMo.Config.Hardware.Device
+	}
+
+	disks := cloneDisksFromDevices(devices, guest_name)
+	if len(disks) == 0 {
+		return fmt.Errorf("Source guest %s has no disks\n", clone_from_vm)
+	}
+
+	src_vmx, err := readVmx_contents(c, src_vmid)
+	if err != nil {
+		return fmt.Errorf("Failed to read source vmx: %s\n", err)
+	}
+
+	//  The current vmx may have changed since the snapshot.
+	doc := vmx.Parse(src_vmx)
+	if clone_snapshot != "" {
+		snapshotVmx(doc, &snapshotMo.Config)
+	}
+
+	if _, err = guestCreateDir(c, disk_store, guest_name, rollback); err != nil {
+		return err
+	}
+	return guestCloneRegister(c, gc, doc, disks, disk_store, guest_name, linked_clone, boot_disk_type, resource_pool_name, rollback)
+}
+
+// guestCreateDir creates the directory of a new guest on disk_store and returns
+// its path.  It fails if the directory already exists.
+func guestCreateDir(c *Config, disk_store, guest_name string, rollback *guestRollback) (string, error) {
+	esxiConnInfo := getConnectionInfo(c)
+
+	fullPATH := fmt.Sprintf("/vmfs/volumes/%s/%s", disk_store, guest_name)
+	remote_cmd := shellCommand("ls", "-d", fullPATH)
+	stdout, _ := runRemoteSshCommand(esxiConnInfo, remote_cmd, "check if guest path already exists.")
+	if !strings.Contains(stdout, "No such file or directory") {
+		return "", fmt.Errorf("Guest may already exists. path:%s\n", fullPATH)
+	}
+	remote_cmd = shellCommand("mkdir", fullPATH)
+	_, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "create guest path")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create guest path. fullPATH:%s\n", fullPATH)
+	}
+	rollback.add("create guest path", func() error {
+		return removeRemotePath(c, fullPATH)
+	})
+	return fullPATH, nil
+}
+
+// guestCloneRegister copies the disks into the guest's directory, writes the
+// guest's vmx from the source's doc and registers it.
+func guestCloneRegister(c *Config, gc *GovmomiClient, doc *vmx.Document, disks []cloneDisk, disk_store, guest_name string,
+	linked_clone bool, boot_disk_type, resource_pool_name string, rollback *guestRollback) error {
+	esxiConnInfo := getConnectionInfo(c)
+	fullPATH := fmt.Sprintf("/vmfs/volumes/%s/%s", disk_store, guest_name)
+
+	//  The disks are removed with the guest path.
+	err := guestCloneDisks(gc, disks, disk_store, guest_name, linked_clone, boot_disk_type)
+	if err != nil {
+		return fmt.Errorf("Failed to clone disks: %s\n", err)
+	}
+
+	cloneVmx(doc, guest_name, disks)
+
+	dst_vmx_file := fmt.Sprintf("%s/%s.vmx", fullPATH, guest_name)
+	_, err = writeContentToRemoteFile(esxiConnInfo, doc.String(), dst_vmx_file, "write guest_name.vmx file")
+	if err != nil {
+		return fmt.Errorf("Failed to write guest_name.vmx file:%s\n", err)
+	}
+
+	vmx_path := object.DatastorePath{Datastore: disk_store, Path: guest_name + "/" + guest_name + ".vmx"}
+	vmid, err := guestRegisterVmx(c, vmx_path.String(), guest_name, resource_pool_name)
+	if err != nil {
+		return fmt.Errorf("Failed to register guest:%s\n", err)
+	}
+	rollback.add("register guest", func() error {
+		return guestUnregister(c, vmid)
+	})
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package esxi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// TestCloneDisksBootFirst verifies the boot disk is named after the clone on any
+// bus, and every copy keeps the adapter type of its controller
+func TestCloneDisksBootFirst(t *testing.T) {
+	scsi := &types.VirtualLsiLogicSASController{}
+	scsi.Key = 1000
+	sata := &types.VirtualAHCIController{}
+	sata.Key = 15000
+	nvme := &types.VirtualNVMEController{}
+	nvme.Key = 31000
+
+	devices := object.VirtualDeviceList{
+		scsi, sata, nvme,
+		testCloneDisk(2000, 1000, 0, "[ds1] web/web_1.vmdk"),
+		testCloneDisk(31000, 31000, 0, "[ds1] web/web_2.vmdk"),
+		testCloneDisk(16000, 15000, 0, "[ds1] web/web.vmdk"),
+	}
+	disks := cloneDisksFromDevices(devices, "copy")
+	expected := []cloneDisk{
+		{Slot: vmx.Slot{Bus: "sata"}, Source: "[ds1] web/web.vmdk", File: "copy.vmdk", AdapterType: types.VirtualDiskAdapterTypeIde},
+		{Slot: vmx.Slot{Bus: "nvme"}, Source: "[ds1] web/web_2.vmdk", File: "copy_1.vmdk", AdapterType: types.VirtualDiskAdapterTypeLsiLogic},
+		{Slot: vmx.Slot{Bus: "scsi"}, Source: "[ds1] web/web_1.vmdk", File: "copy_2.vmdk", AdapterType: types.VirtualDiskAdapterTypeLsiLogic},
+	}
+	if !reflect.DeepEqual(disks, expected) {
+		t.Errorf("cloneDisksFromDevices =\n%+v\nexpected\n%+v", disks, expected)
+	}
+
+	ide := &types.VirtualIDEController{}
+	ide.Key = 200
+	buslogic := &types.VirtualBusLogicController{}
+	buslogic.Key = 1001
+	for controller, adapter := range map[types.BaseVirtualDevice]types.VirtualDiskAdapterType{
+		ide:                                types.VirtualDiskAdapterTypeIde,
+		buslogic:                           types.VirtualDiskAdapterTypeBusLogic,
+		&types.ParaVirtualSCSIController{}: types.VirtualDiskAdapterTypeLsiLogic,
+	} {
+		if got := cloneDiskAdapterType(controller); got != adapter {
+			t.Errorf("cloneDiskAdapterType(%T) = %s, expected %s", controller, got, adapter)
+		}
+	}
+}
+
+// TestCloneVmx verifies a source vmx gets a new identity and the clone's disks
+func TestCloneVmx(t *testing.T) {
+	scsi := &types.ParaVirtualSCSIController{}
+	scsi.Key = 1000
+	scsi.BusNumber = 0
+	sata := &types.VirtualAHCIController{}
+	sata.Key = 15000
+	sata.BusNumber = 0
+
+	devices := object.VirtualDeviceList{
+		scsi, sata,
+		testCloneDisk(16000, 15000, 1, "[ds1] base/base_1.vmdk"),
+		testCloneDisk(2000, 1000, 0, "[ds1] base/base-000001.vmdk", "[ds1] base/base.vmdk"),
+	}
+	disks := cloneDisksFromDevices(devices, "runner 1")
+	expected := []cloneDisk{
+		{Slot: vmx.Slot{Bus: "scsi", Controller: 0, Unit: 0}, Source: "[ds1] base/base-000001.vmdk", File: "runner 1.vmdk", AdapterType: types.VirtualDiskAdapterTypeLsiLogic},
+		{Slot: vmx.Slot{Bus: "sata", Controller: 0, Unit: 1}, Source: "[ds1] base/base_1.vmdk", File: "runner 1_1.vmdk", AdapterType: types.VirtualDiskAdapterTypeIde},
+	}
+	if !reflect.DeepEqual(disks, expected) {
+		t.Fatalf("cloneDisksFromDevices =\n%+v\nexpected\n%+v", disks, expected)
+	}
+
+	doc := vmx.Parse("displayName = \"base\"\n" +
+		"nvram = \"base.nvram\"\n" +
+		"uuid.bios = \"56 4d 11\"\n" +
+		"uuid.location = \"56 4d 11\"\n" +
+		"sched.swap.derivedName = \"/vmfs/volumes/ds1/base/base-1234.vswp\"\n" +
+		"scsi0.present = \"TRUE\"\n" +
+		"scsi0:0.fileName = \"base-000002.vmdk\"\n" +
+		"scsi0:0.present = \"TRUE\"\n" +
+		"scsi0:1.fileName = \"/vmfs/volumes/ds1/data/added-later.vmdk\"\n" +
+		"scsi0:1.present = \"TRUE\"\n" +
+		"sata0:1.fileName = \"base_1-000001.vmdk\"\n" +
+		"sata0:1.present = \"TRUE\"\n" +
+		"ide1:0.deviceType = \"atapi-cdrom\"\n" +
+		"ide1:0.present = \"TRUE\"\n" +
+		"ethernet0.addressType = \"generated\"\n" +
+		"ethernet0.generatedAddress = \"00:0c:29:e1:e3:a7\"\n" +
+		"ethernet0.generatedAddressOffset = \"0\"\n" +
+		"ethernet0.present = \"TRUE\"\n" +
+		"ethernet1.addressType = \"static\"\n" +
+		"ethernet1.address = \"00:50:56:01:02:03\"\n" +
+		"ethernet1.present = \"TRUE\"\n")
+	cloneVmx(doc, "runner 1", disks)
+
+	values := map[string]string{
+		"displayName":           "runner 1",
+		"nvram":                 "runner 1.nvram",
+		"scsi0:0.fileName":      "runner 1.vmdk",
+		"scsi0:0.deviceType":    "scsi-hardDisk",
+		"sata0:1.fileName":      "runner 1_1.vmdk",
+		"ethernet0.addressType": "generated",
+		"ethernet1.addressType": "generated",
+		"ide1:0.deviceType":     "atapi-cdrom",
+	}
+	for key, value := range values {
+		if got := doc.Value(key); got != value {
+			t.Errorf("%s = %q, expected %q", key, got, value)
+		}
+	}
+	for _, key := range []string{"uuid.bios", "uuid.location", "sched.swap.derivedName", "ethernet0.generatedAddress", "ethernet0.generatedAddressOffset", "ethernet1.address"} {
+		if doc.Has(key) {
+			t.Errorf("%s should be removed", key)
+		}
+	}
+	if doc.HasDevice("scsi0:1") {
+		t.Errorf("disks that are not in the snapshot should be removed:\n%s", doc)
+	}
+}
+
+// TestSnapshotVmx verifies a clone's vmx is reconciled with the hardware and ethernets of its snapshot
+func TestSnapshotVmx(t *testing.T) {
+	scsi := &types.ParaVirtualSCSIController{}
+	scsi.Key = 1000
+	scsi.BusNumber = 1
+	e1000 := &types.VirtualE1000{}
+	e1000.Key = 4000
+	e1000.Backing = &types.VirtualEthernetCardNetworkBackingInfo{VirtualDeviceDeviceBackingInfo: types.VirtualDeviceDeviceBackingInfo{DeviceName: "VM Network"}}
+	e1000.Connectable = &types.VirtualDeviceConnectInfo{StartConnected: true}
+	vmxnet3 := &types.VirtualVmxnet3{}
+	vmxnet3.Key = 4002
+	vmxnet3.Backing = &types.VirtualEthernetCardDistributedVirtualPortBackingInfo{Port: types.DistributedVirtualSwitchPortConnection{SwitchUuid: "50 2d 1a", PortgroupKey: "dvportgroup-21"}}
+	vmxnet3.Connectable = &types.VirtualDeviceConnectInfo{StartConnected: false}
+
+	config := &types.VirtualMachineConfigInfo{
+		GuestId:  "centos7_64Guest",
+		Version:  "vmx-13",
+		Firmware: "bios",
+		Hardware: types.VirtualHardware{
+			NumCPU:            2,
+			NumCoresPerSocket: 1,
+			MemoryMB:          1024,
+			Device:            []types.BaseVirtualDevice{scsi, e1000, vmxnet3},
+		},
+	}
+
+	//  The source was changed after the snapshot.
+	doc := vmx.Parse(`memsize = "4096"
+numvcpus = "8"
+guestOS = "centos8-64"
+virtualHW.version = "19"
+firmware = "efi"
+ethernet0.virtualDev = "e1000"
+ethernet0.networkName = "VM Network"
+ethernet0.addressType = "generated"
+ethernet0.present = "TRUE"
+ethernet1.virtualDev = "vmxnet3"
+ethernet1.networkName = "Added"
+ethernet1.present = "TRUE"
+ethernet2.virtualDev = "e1000e"
+ethernet2.networkName = "Lab"
+ethernet2.present = "TRUE"
+`)
+	snapshotVmx(doc, config)
+
+	values := map[string]string{
+		"memsize":                   "1024",
+		"numvcpus":                  "2",
+		"cpuid.coresPerSocket":      "1",
+		"guestOS":                   "centos7_64Guest",
+		"virtualHW.version":         "13",
+		"firmware":                  "bios",
+		"scsi1.virtualDev":          "pvscsi",
+		"scsi1.present":             "TRUE",
+		"ethernet0.networkName":     "VM Network",
+		"ethernet0.virtualDev":      "e1000",
+		"ethernet2.virtualDev":      "vmxnet3",
+		"ethernet2.dvs.portgroupId": "dvportgroup-21",
+		"ethernet2.dvs.switchId":    "50 2d 1a",
+		"ethernet2.startConnected":  "FALSE",
+		"ethernet2.addressType":     "generated",
+	}
+	for key, value := range values {
+		if got := doc.Value(key); got != value {
+			t.Errorf("%s = %q, expected %q", key, got, value)
+		}
+	}
+	if doc.HasDevice("ethernet1") || doc.Has("ethernet2.networkName") {
+		t.Errorf("Expected ethernet1 removed and ethernet2 moved to the port group:\n%s", doc)
+	}
+}
+
+// TestGuestCloneDisksGovmomi tests copying a guest's disks and registering the clone
+func TestGuestCloneDisksGovmomi(t *testing.T) {
+	model := simulator.ESX()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatal("Failed to find VMs in simulator")
+	}
+	src := vms[0]
+
+	devices, err := src.Device(client.Context())
+	if err != nil {
+		t.Fatalf("Failed to read devices: %v", err)
+	}
+	disks := cloneDisksFromDevices(devices, "clone")
+	if len(disks) == 0 {
+		t.Fatal("Expected the source to have disks")
+	}
+
+	ds, err := client.Finder.DefaultDatastore(client.Context())
+	if err != nil {
+		t.Fatalf("Failed to find datastore: %v", err)
+	}
+	err = object.NewFileManager(client.Client.Client).MakeDirectory(client.Context(), ds.Path("clone"), nil, true)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	err = guestCloneDisks(client, disks, ds.Name(), "clone", false, "thin")
+	if err != nil {
+		t.Fatalf("Failed to clone disks: %v", err)
+	}
+	if _, err = ds.Stat(client.Context(), "clone/clone.vmdk"); err != nil {
+		t.Errorf("Expected the copied disk: %v", err)
+	}
+
+	//  Register the source's vmx again, as a new guest.
+	var srcMo mo.VirtualMachine
+	err = src.Properties(client.Context(), src.Reference(), []string{"name", "config.files.vmPathName"}, &srcMo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := src.PowerOff(client.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = task.Wait(client.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if err = src.Unregister(client.Context()); err != nil {
+		t.Fatal(err)
+	}
+	vmid, err := guestRegisterVmx(config, srcMo.Config.Files.VmPathName, srcMo.Name, "/")
+	if err != nil {
+		t.Fatalf("Failed to register guest: %v", err)
+	}
+	if vmid == "" || vmid == src.Reference().Value {
+		t.Errorf("Expected a new vmid, got %q", vmid)
+	}
+}
@@ -0,0 +1,385 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// diskControllerTypes maps a controllers type to the bus it creates and, for scsi
+// controllers, the vmx virtualDev.
+var diskControllerTypes = map[string]struct{ bus, virtualDev string }{
+	"pvscsi":       {"scsi", "pvscsi"},
+	"lsilogic":     {"scsi", "lsilogic"},
+	"lsilogic-sas": {"scsi", "lsisas1068"},
+	"buslogic":     {"scsi", "buslogic"},
+	"sata":         {"sata", ""},
+	"nvme":         {"nvme", ""},
+}
+
+var diskControllerTypeNames = []string{"pvscsi", "lsilogic", "lsilogic-sas", "buslogic", "sata", "nvme"}
+
+// diskBusLimits are the number of controllers of each bus and the number of units on
+// each controller.
+var diskBusLimits = map[string]struct{ controllers, units int }{
+	"scsi": {4, 16},
+	"sata": {4, 30},
+	"nvme": {4, 15},
+}
+
+var legacyDiskSlotRe = regexp.MustCompile(`^(?:(\d+):)?(\d+)$`)
+
+// guestController is a disk controller.
+type guestController struct {
+	Type      string
+	BusNumber int
+}
+
+// name returns the controller's vmx device name, such as scsi1.  Unknown types are scsi
+// virtualDevs read from a vmx file.
+func (ctrl guestController) name() string {
+	bus := "scsi"
+	if info, ok := diskControllerTypes[ctrl.Type]; ok {
+		bus = info.bus
+	}
+	return fmt.Sprintf("%s%d", bus, ctrl.BusNumber)
+}
+
+// Get controllers from the resource config.
+func guestControllersFromResourceData(d resourceChanges) ([]guestController, error) {
+	count := d.Get("controllers.#").(int)
+	controllers := make([]guestController, 0, count)
+	seen := make(map[string]bool)
+	for i := 0; i < count; i++ {
+		prefix := fmt.Sprintf("controllers.%d.", i)
+
+		ctrl := guestController{
+			Type:      d.Get(prefix + "type").(string),
+			BusNumber: d.Get(prefix + "bus_number").(int),
+		}
+		if _, ok := diskControllerTypes[ctrl.Type]; !ok {
+			return nil, fmt.Errorf("Error: invalid controller type %q, must be one of %s", ctrl.Type, strings.Join(diskControllerTypeNames, ", "))
+		}
+		if seen[ctrl.name()] {
+			return nil, fmt.Errorf("Error: controller %s is defined more than once", ctrl.name())
+		}
+		seen[ctrl.name()] = true
+		controllers = append(controllers, ctrl)
+	}
+	return controllers, nil
+}
+
+// Convert controllers to the controllers attribute.
+func guestControllersToResourceData(controllers []guestController) []map[string]interface{} {
+	if len(controllers) == 0 {
+		return nil
+	}
+	out := make([]map[string]interface{}, 0, len(controllers))
+	for _, ctrl := range controllers {
+		out = append(out, map[string]interface{}{
+			"type":       ctrl.Type,
+			"bus_number": ctrl.BusNumber,
+		})
+	}
+	return out
+}
+
+// parseDiskSlot parses a virtual_disks slot.  The legacy forms X:Y and Y are scsiX:Y and
+// scsi0:Y.
+func parseDiskSlot(s string) (vmx.Slot, error) {
+	var slot vmx.Slot
+	if m := legacyDiskSlotRe.FindStringSubmatch(s); m != nil {
+		slot.Bus = "scsi"
+		slot.Controller, _ = strconv.Atoi(m[1])
+		slot.Unit, _ = strconv.Atoi(m[2])
+	} else {
+		var err error
+		slot, err = vmx.ParseSlot(s)
+		if err != nil {
+			return slot, err
+		}
+	}
+
+	limits, ok := diskBusLimits[slot.Bus]
+	switch {
+	case !ok:
+		return slot, fmt.Errorf("%s disks are not supported", slot.Bus)
+	case slot.Controller >= limits.controllers:
+		return slot, fmt.Errorf("%s controller id out of range", slot.Bus)
+	case slot.Unit >= limits.units:
+		return slot, fmt.Errorf("%s id out of range", slot.Bus)
+	case slot.Bus == "scsi" && slot.Unit == 7:
+		return slot, fmt.Errorf("scsi id 7 not allowed")
+	case slot == vmx.Slot{Bus: "scsi"}:
+		return slot, fmt.Errorf("scsi id used by boot disk")
+	}
+	return slot, nil
+}
+
+// Suppress the diff between a legacy slot and the same slot read back from the guest.
+func diskSlotDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	oldSlot, err := parseDiskSlot(old)
+	if err != nil {
+		return false
+	}
+	newSlot, err := parseDiskSlot(new)
+	return err == nil && oldSlot == newSlot
+}
+
+// Get virtual_disks from the resource config.  Slots are returned in the form scsi0:1.
+// Disks without a slot are given the first free unit on scsi0.
+func guestDisksFromResourceData(d resourceChanges) ([60][2]string, error) {
+	var virtual_disks [60][2]string
+
+	count := d.Get("virtual_disks.#").(int)
+	if count > 59 {
+		count = 59
+	}
+	used := make(map[vmx.Slot]bool)
+	for i := 0; i < count; i++ {
+		prefix := fmt.Sprintf("virtual_disks.%d.", i)
+
+		virtual_disks[i][0] = d.Get(prefix + "virtual_disk_id").(string)
+		if attr := d.Get(prefix + "slot").(string); attr != "" {
+			slot, err := parseDiskSlot(attr)
+			if err != nil {
+				return virtual_disks, fmt.Errorf("Error: virtual_disks.%d.slot: %s", i, err)
+			}
+			if used[slot] {
+				return virtual_disks, fmt.Errorf("Error: virtual_disks slot %s is used more than once", slot)
+			}
+			used[slot] = true
+			virtual_disks[i][1] = slot.String()
+		}
+	}
+
+	next := vmx.Slot{Bus: "scsi", Unit: 1}
+	for i := 0; i < count; i++ {
+		if virtual_disks[i][1] != "" {
+			continue
+		}
+		for used[next] || next.Unit == 7 {
+			next.Unit++
+		}
+		if next.Unit >= diskBusLimits["scsi"].units {
+			return virtual_disks, fmt.Errorf("Error: no free slot on scsi0 for virtual_disks.%d", i)
+		}
+		used[next] = true
+		virtual_disks[i][1] = next.String()
+	}
+	return virtual_disks, nil
+}
+
+// guestControllersToVmx sets the type of the configured controllers and creates the
+// controllers that disks are attached to.  Controllers are never removed, they may
+// have devices that aren't managed by terraform.
+func guestControllersToVmx(doc *vmx.Document, controllers []guestController, virtual_disks [60][2]string) {
+	for _, ctrl := range controllers {
+		name := ctrl.name()
+		virtualDev := diskControllerTypes[ctrl.Type].virtualDev
+		if virtualDev != "" && doc.Value(name+".virtualDev") != virtualDev {
+			log.Printf("[guestControllersToVmx] %s type: %s\n", name, ctrl.Type)
+			doc.Set(name+".virtualDev", virtualDev)
+		}
+		if !doc.Bool(name+".present", false) {
+			doc.Set(name+".present", "TRUE")
+		}
+	}
+
+	//  New scsi controllers are the same type as scsi0.
+	defaultScsiDev := doc.Value("scsi0.virtualDev")
+	if defaultScsiDev == "" {
+		defaultScsiDev = "lsilogic"
+	}
+	for i := 0; i < len(virtual_disks); i++ {
+		if virtual_disks[i][0] == "" {
+			continue
+		}
+		slot, err := vmx.ParseSlot(virtual_disks[i][1])
+		if err != nil {
+			continue
+		}
+		name := slot.ControllerName()
+		if doc.Bool(name+".present", false) {
+			continue
+		}
+		log.Printf("[guestControllersToVmx] Create %s\n", name)
+		if slot.Bus == "scsi" {
+			if !doc.Has(name + ".virtualDev") {
+				doc.Set(name+".virtualDev", defaultScsiDev)
+			}
+			doc.Set(name+".sharedBus", "none")
+		}
+		doc.Set(name+".present", "TRUE")
+	}
+}
+
+// guestControllersFromVmx reads the scsi, sata and nvme controllers from a vmx file.
+func guestControllersFromVmx(doc *vmx.Document) []guestController {
+	var controllers []guestController
+	for _, name := range doc.Controllers() {
+		if !doc.Bool(name+".present", false) {
+			continue
+		}
+		bus := strings.TrimRight(name, "0123456789")
+		number, _ := strconv.Atoi(name[len(bus):])
+
+		ctrl := guestController{BusNumber: number}
+		switch bus {
+		case "sata", "nvme":
+			ctrl.Type = bus
+		case "scsi":
+			virtualDev := doc.Value(name + ".virtualDev")
+			ctrl.Type = virtualDev
+			for typ, info := range diskControllerTypes {
+				if info.bus == "scsi" && strings.EqualFold(info.virtualDev, virtualDev) {
+					ctrl.Type = typ
+				}
+			}
+		default:
+			continue
+		}
+		controllers = append(controllers, ctrl)
+	}
+	sort.Slice(controllers, func(i, j int) bool { return controllers[i].name() < controllers[j].name() })
+	return controllers
+}
+
+// vmxRemoveDisks removes the disks, but not the cdroms, from a vmx file.  The boot
+// disk is kept.
+func vmxRemoveDisks(doc *vmx.Document, boot vmx.Slot) {
+	for _, disk := range doc.Disks() {
+		if _, ok := diskBusLimits[disk.Slot.Bus]; !ok || disk.IsCdrom() || disk.Slot == boot {
+			continue
+		}
+		doc.DeleteDevice(disk.Slot.String())
+	}
+}
+
+// guestBootDisk returns the path and slot of a guest's boot disk, as chosen by
+// bootVirtualDisk.
+func guestBootDisk(c *Config, vmid string) (string, vmx.Slot, error) {
+	log.Printf("[guestBootDisk]\n")
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return "", vmx.Slot{}, fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return "", vmx.Slot{}, err
+	}
+	devices, err := vm.Device(gc.Context())
+	if err != nil {
+		return "", vmx.Slot{}, fmt.Errorf("Failed to get guest devices: %s\n", err)
+	}
+	return bootDiskFromDevices(devices)
+}
+
+// deviceSlot returns the vmx slot of a disk or cdrom, such as scsi0:1.
+func deviceSlot(devices object.VirtualDeviceList, device types.BaseVirtualDevice) (vmx.Slot, bool) {
+	var slot vmx.Slot
+	base := device.GetVirtualDevice()
+	switch controller := devices.FindByKey(base.ControllerKey).(type) {
+	case types.BaseVirtualSCSIController:
+		slot.Bus = "scsi"
+		slot.Controller = int(controller.GetVirtualSCSIController().BusNumber)
+	case types.BaseVirtualSATAController:
+		slot.Bus = "sata"
+		slot.Controller = int(controller.GetVirtualSATAController().BusNumber)
+	case *types.VirtualNVMEController:
+		slot.Bus = "nvme"
+		slot.Controller = int(controller.BusNumber)
+	case *types.VirtualIDEController:
+		slot.Bus = "ide"
+		slot.Controller = int(controller.BusNumber)
+	default:
+		return slot, false
+	}
+	if base.UnitNumber != nil {
+		slot.Unit = int(*base.UnitNumber)
+	}
+	return slot, true
+}
+
+// bootVirtualDisk returns the boot disk, or nil.  The boot disk is the disk named
+// after the guest's directory, <name>/<name>.vmdk, as the provider creates, clones
+// and imports it.  Failing that, it's the disk in scsi0:0, the slot kept for the
+// boot disk, and then the disk with the lowest key.
+func bootVirtualDisk(devices object.VirtualDeviceList) *types.VirtualDisk {
+	var named, scsi00, lowest *types.VirtualDisk
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		disk := device.(*types.VirtualDisk)
+		if isGuestBootDiskFile(disk) && (named == nil || disk.Key < named.Key) {
+			named = disk
+		}
+		if slot, ok := deviceSlot(devices, disk); ok && slot == (vmx.Slot{Bus: "scsi"}) {
+			scsi00 = disk
+		}
+		if lowest == nil || disk.Key < lowest.Key {
+			lowest = disk
+		}
+	}
+	switch {
+	case named != nil:
+		return named
+	case scsi00 != nil:
+		return scsi00
+	}
+	return lowest
+}
+
+// snapshotDeltaSuffixRe matches the suffix esxi gives a snapshot's delta disk.
+var snapshotDeltaSuffixRe = regexp.MustCompile(`-\d{6}$`)
+
+// isGuestBootDiskFile tells if a disk, or a disk its snapshot deltas are built on,
+// is named after its directory.
+func isGuestBootDiskFile(disk *types.VirtualDisk) bool {
+	backing, ok := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo)
+	if !ok {
+		return false
+	}
+	for _, file := range append([]string{backing.GetVirtualDeviceFileBackingInfo().FileName}, diskParentFiles(disk)...) {
+		var disk_path object.DatastorePath
+		if !disk_path.FromString(file) {
+			continue
+		}
+		dir, base := path.Split(disk_path.Path)
+		name := snapshotDeltaSuffixRe.ReplaceAllString(strings.TrimSuffix(base, ".vmdk"), "")
+		if dir != "" && name == path.Base(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func bootDiskFromDevices(devices object.VirtualDeviceList) (string, vmx.Slot, error) {
+	boot := bootVirtualDisk(devices)
+	if boot == nil {
+		return "", vmx.Slot{}, fmt.Errorf("guest has no disks")
+	}
+
+	slot, ok := deviceSlot(devices, boot)
+	if !ok {
+		return "", vmx.Slot{}, fmt.Errorf("boot disk controller %d not found", boot.ControllerKey)
+	}
+
+	backing, ok := boot.Backing.(types.BaseVirtualDeviceFileBackingInfo)
+	if !ok {
+		return "", slot, fmt.Errorf("boot disk %s has no backing file", slot)
+	}
+	var path object.DatastorePath
+	if !path.FromString(backing.GetVirtualDeviceFileBackingInfo().FileName) {
+		return "", slot, fmt.Errorf("boot disk %s has an invalid path %q", slot, backing.GetVirtualDeviceFileBackingInfo().FileName)
+	}
+	return "/vmfs/volumes/" + path.Datastore + "/" + path.Path, slot, nil
+}
@@ -0,0 +1,174 @@
+package esxi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// TestParseDiskSlot verifies bus slots, legacy scsi slots and their limits
+func TestParseDiskSlot(t *testing.T) {
+	valid := map[string]string{
+		"1":        "scsi0:1",
+		"0:1":      "scsi0:1",
+		"3:15":     "scsi3:15",
+		"scsi1:0":  "scsi1:0",
+		"SATA0:29": "sata0:29",
+		"nvme3:14": "nvme3:14",
+	}
+	for in, expected := range valid {
+		slot, err := parseDiskSlot(in)
+		if err != nil || slot.String() != expected {
+			t.Errorf("parseDiskSlot(%q) = %s, %v, expected %s", in, slot, err, expected)
+		}
+	}
+
+	for _, in := range []string{"", "0:0", "scsi0:0", "0:7", "4:1", "0:16", "sata0:30", "nvme0:15", "ide0:1", "usb0:1", "a:b"} {
+		if _, err := parseDiskSlot(in); err == nil {
+			t.Errorf("parseDiskSlot(%q) should fail", in)
+		}
+	}
+
+	if !diskSlotDiffSuppress("", "scsi0:1", "0:1", nil) || diskSlotDiffSuppress("", "scsi0:1", "sata0:1", nil) {
+		t.Error("diskSlotDiffSuppress should only match the same slot")
+	}
+}
+
+// TestGuestControllersToVmx verifies controllers are typed and created for their disks
+func TestGuestControllersToVmx(t *testing.T) {
+	doc := vmx.Parse("scsi0.present = \"TRUE\"\n" +
+		"scsi0.virtualDev = \"pvscsi\"\n" +
+		"scsi0:0.fileName = \"boot.vmdk\"\n" +
+		"scsi0:0.present = \"TRUE\"\n" +
+		"scsi0:1.fileName = \"/vmfs/volumes/ds1/old.vmdk\"\n" +
+		"scsi0:1.present = \"TRUE\"\n" +
+		"sata0.present = \"TRUE\"\n" +
+		"sata0:0.deviceType = \"cdrom-image\"\n" +
+		"sata0:0.fileName = \"/vmfs/volumes/ds1/iso/os.iso\"\n" +
+		"sata0:0.present = \"TRUE\"\n" +
+		"sata0:1.fileName = \"/vmfs/volumes/ds1/sata.vmdk\"\n" +
+		"sata0:1.present = \"TRUE\"\n")
+
+	vmxRemoveDisks(doc, vmx.Slot{Bus: "scsi"})
+	if !doc.HasDevice("scsi0:0") || !doc.HasDevice("sata0:0") || doc.HasDevice("scsi0:1") || doc.HasDevice("sata0:1") {
+		t.Errorf("only the additional disks should be removed:\n%s", doc)
+	}
+
+	var virtual_disks [60][2]string
+	virtual_disks[0] = [2]string{"/vmfs/volumes/ds1/a.vmdk", "scsi2:1"}
+	virtual_disks[1] = [2]string{"/vmfs/volumes/ds1/b.vmdk", "nvme0:0"}
+	guestControllersToVmx(doc, []guestController{{Type: "lsilogic-sas", BusNumber: 1}, {Type: "sata", BusNumber: 1}}, virtual_disks)
+
+	expected := map[string]string{
+		"scsi1.virtualDev": "lsisas1068",
+		"scsi1.present":    "TRUE",
+		"sata1.present":    "TRUE",
+		"scsi2.virtualDev": "pvscsi",
+		"scsi2.present":    "TRUE",
+		"nvme0.present":    "TRUE",
+	}
+	for key, value := range expected {
+		if got := doc.Value(key); got != value {
+			t.Errorf("%s = %q, expected %q", key, got, value)
+		}
+	}
+
+	controllers := guestControllersFromVmx(doc)
+	expectedControllers := []guestController{
+		{Type: "nvme", BusNumber: 0},
+		{Type: "sata", BusNumber: 0},
+		{Type: "sata", BusNumber: 1},
+		{Type: "pvscsi", BusNumber: 0},
+		{Type: "lsilogic-sas", BusNumber: 1},
+		{Type: "pvscsi", BusNumber: 2},
+	}
+	if !reflect.DeepEqual(controllers, expectedControllers) {
+		t.Errorf("guestControllersFromVmx =\n%+v\nexpected\n%+v", controllers, expectedControllers)
+	}
+
+	//  Adopting a guest reports controller changes.
+	cur := guestAdoptState{controllers: controllers}
+	want := guestAdoptState{controllers: []guestController{{Type: "pvscsi", BusNumber: 1}, {Type: "nvme", BusNumber: 1}}}
+	changes := cur.changesTo(want)
+	if !reflect.DeepEqual(changes, []string{`controllers.scsi1: "lsilogic-sas" => "pvscsi"`, `controllers.nvme1: "" => "nvme"`}) {
+		t.Errorf("unexpected adopt changes %q", changes)
+	}
+}
+
+// TestBootDiskFromDevices verifies the disk named after the guest is the boot disk on any controller
+func TestBootDiskFromDevices(t *testing.T) {
+	unit := func(n int32) *int32 { return &n }
+	disk := func(key, controllerKey int32, u int32, fileName string) *types.VirtualDisk {
+		return &types.VirtualDisk{
+			VirtualDevice: types.VirtualDevice{
+				Key:           key,
+				ControllerKey: controllerKey,
+				UnitNumber:    unit(u),
+				Backing: &types.VirtualDiskFlatVer2BackingInfo{
+					VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: fileName},
+				},
+			},
+		}
+	}
+	scsi := &types.ParaVirtualSCSIController{}
+	scsi.Key = 1000
+	scsi.BusNumber = 0
+	sata := &types.VirtualAHCIController{}
+	sata.Key = 15000
+	sata.BusNumber = 0
+	nvme := &types.VirtualNVMEController{}
+	nvme.Key = 31000
+	nvme.BusNumber = 1
+
+	devices := object.VirtualDeviceList{
+		scsi, sata, nvme,
+		disk(31001, 31000, 0, "[ds1] web/web_1.vmdk"),
+		disk(16000, 15000, 2, "[ds1] web/web.vmdk"),
+		disk(31000, 31000, 1, "[ds1] web/web_2.vmdk"),
+	}
+	path, slot, err := bootDiskFromDevices(devices)
+	if err != nil || path != "/vmfs/volumes/ds1/web/web.vmdk" || slot.String() != "sata0:2" {
+		t.Errorf("bootDiskFromDevices = %q, %s, %v", path, slot, err)
+	}
+
+	//  scsi data disks have lower keys than a sata boot disk.
+	devices = append(devices, disk(2000, 1000, 0, "[ds1] web/web_3.vmdk"), disk(2001, 1000, 1, "[ds1] disks/data.vmdk"))
+	path, slot, err = bootDiskFromDevices(devices)
+	if err != nil || path != "/vmfs/volumes/ds1/web/web.vmdk" || slot.String() != "sata0:2" {
+		t.Errorf("bootDiskFromDevices = %q, %s, %v", path, slot, err)
+	}
+
+	//  A snapshot's delta of the boot disk.
+	delta := disk(16000, 15000, 2, "[ds1] web/web-000001.vmdk")
+	delta.Backing.(*types.VirtualDiskFlatVer2BackingInfo).Parent = &types.VirtualDiskFlatVer2BackingInfo{
+		VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: "[ds1] web/web.vmdk"},
+	}
+	devices[4] = delta
+	path, slot, err = bootDiskFromDevices(devices)
+	if err != nil || path != "/vmfs/volumes/ds1/web/web-000001.vmdk" || slot.String() != "sata0:2" {
+		t.Errorf("bootDiskFromDevices = %q, %s, %v", path, slot, err)
+	}
+
+	//  Without a disk named after the guest, scsi0:0 and then the lowest key.
+	devices = object.VirtualDeviceList{
+		scsi, sata,
+		disk(16000, 15000, 0, "[datastore 2] db/disk-a.vmdk"),
+		disk(2001, 1000, 1, "[datastore 2] db/disk-c.vmdk"),
+		disk(2000, 1000, 0, "[datastore 2] db/disk-b.vmdk"),
+	}
+	path, slot, err = bootDiskFromDevices(devices)
+	if err != nil || path != "/vmfs/volumes/datastore 2/db/disk-b.vmdk" || slot.String() != "scsi0:0" {
+		t.Errorf("bootDiskFromDevices = %q, %s, %v", path, slot, err)
+	}
+	path, slot, err = bootDiskFromDevices(devices[:4])
+	if err != nil || path != "/vmfs/volumes/datastore 2/db/disk-c.vmdk" || slot.String() != "scsi0:1" {
+		t.Errorf("bootDiskFromDevices = %q, %s, %v", path, slot, err)
+	}
+
+	if _, _, err = bootDiskFromDevices(object.VirtualDeviceList{scsi}); err == nil {
+		t.Error("a guest without disks should fail")
+	}
+}
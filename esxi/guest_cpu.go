@@ -0,0 +1,229 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// guestCPUKeys are the esxi_guest attributes of a guest's cpu settings.
+var guestCPUKeys = []string{
+	"cores_per_socket", "nested_hv_enabled", "cpu_performance_counters", "latency_sensitivity", "numa_node_affinity",
+	"cpu_hot_add_enabled", "memory_hot_add_enabled",
+}
+
+// guestCPU are the cpu topology, NUMA and virtualization settings of a guest.
+type guestCPU struct {
+	CoresPerSocket      int    // cpuid.coresPerSocket, 0 is the host default
+	NestedHVEnabled     bool   // vhv.enable
+	PerformanceCounters bool   // vpmc.enable
+	LatencySensitivity  string // sched.cpu.latencySensitivity, "" is the host default
+	NumaNodeAffinity    []int  // numa.nodeAffinity
+	CpuHotAddEnabled    bool   // vcpu.hotadd
+	MemoryHotAddEnabled bool   // mem.hotadd
+}
+
+// hostCapabilities are the host limits a guest's cpu settings are checked
+// against.  Unknown capabilities are nil, and aren't checked.
+type hostCapabilities struct {
+	CpuThreads                  int
+	NumaNodes                   int
+	NestedHVSupported           *bool
+	VPMCSupported               *bool
+	LatencySensitivitySupported *bool
+}
+
+// Get the cpu settings from the resource config.
+func guestCPUFromResourceData(d *schema.ResourceData) guestCPU {
+	cpu := guestCPU{
+		CoresPerSocket:      d.Get("cores_per_socket").(int),
+		NestedHVEnabled:     d.Get("nested_hv_enabled").(bool),
+		PerformanceCounters: d.Get("cpu_performance_counters").(bool),
+		LatencySensitivity:  d.Get("latency_sensitivity").(string),
+		CpuHotAddEnabled:    d.Get("cpu_hot_add_enabled").(bool),
+		MemoryHotAddEnabled: d.Get("memory_hot_add_enabled").(bool),
+	}
+	for _, node := range d.Get("numa_node_affinity").([]interface{}) {
+		cpu.NumaNodeAffinity = append(cpu.NumaNodeAffinity, node.(int))
+	}
+	return cpu
+}
+
+// Set the cpu settings attributes.
+func guestCPUToResourceData(d *schema.ResourceData, cpu guestCPU) {
+	d.Set("cores_per_socket", cpu.CoresPerSocket)
+	d.Set("nested_hv_enabled", cpu.NestedHVEnabled)
+	d.Set("cpu_performance_counters", cpu.PerformanceCounters)
+	d.Set("latency_sensitivity", cpu.LatencySensitivity)
+	d.Set("numa_node_affinity", cpu.NumaNodeAffinity)
+	d.Set("cpu_hot_add_enabled", cpu.CpuHotAddEnabled)
+	d.Set("memory_hot_add_enabled", cpu.MemoryHotAddEnabled)
+}
+
+// guestCPUConfigured tells if any cpu setting is set.  Otherwise a new guest
+// keeps the settings of its source.
+func guestCPUConfigured(d *schema.ResourceData) bool {
+	for _, key := range guestCPUKeys {
+		if _, ok := d.GetOk(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// guestCPUChanged tells if any cpu setting changed.
+func guestCPUChanged(d *schema.ResourceData) bool {
+	for _, key := range guestCPUKeys {
+		if d.HasChange(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// vmxBool parses a vmx boolean
+func vmxBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// guestCPUFromVmx reads the cpu settings from a vmx file.
+func guestCPUFromVmx(doc *vmx.Document) guestCPU {
+	cpu := guestCPU{
+		NestedHVEnabled:     vmxBool(doc.Value("vhv.enable")),
+		PerformanceCounters: vmxBool(doc.Value("vpmc.enable")),
+		LatencySensitivity:  strings.ToLower(doc.Value("sched.cpu.latencySensitivity")),
+		CpuHotAddEnabled:    vmxBool(doc.Value("vcpu.hotadd")),
+		MemoryHotAddEnabled: vmxBool(doc.Value("mem.hotadd")),
+	}
+	cpu.CoresPerSocket, _ = strconv.Atoi(doc.Value("cpuid.coresPerSocket"))
+	for _, node := range strings.Split(doc.Value("numa.nodeAffinity"), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(node)); err == nil {
+			cpu.NumaNodeAffinity = append(cpu.NumaNodeAffinity, n)
+		}
+	}
+	return cpu
+}
+
+// guestCPUToVmx writes the cpu settings to a vmx file.  Settings left at their
+// default are removed.
+func guestCPUToVmx(doc *vmx.Document, cpu guestCPU) {
+	set := func(key string, value string) {
+		if value == "" {
+			doc.Delete(key)
+		} else {
+			doc.Set(key, value)
+		}
+	}
+	vmxTrue := func(value bool) string {
+		if value {
+			return "TRUE"
+		}
+		return ""
+	}
+
+	cores := ""
+	if cpu.CoresPerSocket > 0 {
+		cores = strconv.Itoa(cpu.CoresPerSocket)
+	}
+	set("cpuid.coresPerSocket", cores)
+	set("vhv.enable", vmxTrue(cpu.NestedHVEnabled))
+	set("vpmc.enable", vmxTrue(cpu.PerformanceCounters))
+	set("sched.cpu.latencySensitivity", cpu.LatencySensitivity)
+	set("vcpu.hotadd", vmxTrue(cpu.CpuHotAddEnabled))
+	set("mem.hotadd", vmxTrue(cpu.MemoryHotAddEnabled))
+
+	nodes := make([]string, 0, len(cpu.NumaNodeAffinity))
+	for _, node := range cpu.NumaNodeAffinity {
+		nodes = append(nodes, strconv.Itoa(node))
+	}
+	set("numa.nodeAffinity", strings.Join(nodes, ","))
+}
+
+// validate checks the cpu settings against numvcpus, if it's known, and the
+// host's capabilities.
+func (cpu guestCPU) validate(numvcpus int, host hostCapabilities) error {
+	var errs []string
+
+	if cpu.CoresPerSocket > 0 && numvcpus > 0 && numvcpus%cpu.CoresPerSocket != 0 {
+		errs = append(errs, fmt.Sprintf("cores_per_socket %d must divide numvcpus %d", cpu.CoresPerSocket, numvcpus))
+	}
+	if host.CpuThreads > 0 && numvcpus > host.CpuThreads {
+		errs = append(errs, fmt.Sprintf("numvcpus %d is more than the host's %d cpu threads", numvcpus, host.CpuThreads))
+	}
+	if cpu.NestedHVEnabled && host.NestedHVSupported != nil && !*host.NestedHVSupported {
+		errs = append(errs, "nested_hv_enabled is not supported by the host")
+	}
+	if cpu.PerformanceCounters && host.VPMCSupported != nil && !*host.VPMCSupported {
+		errs = append(errs, "cpu_performance_counters is not supported by the host")
+	}
+	if cpu.LatencySensitivity != "" && cpu.LatencySensitivity != "normal" &&
+		host.LatencySensitivitySupported != nil && !*host.LatencySensitivitySupported {
+		errs = append(errs, "latency_sensitivity is not supported by the host")
+	}
+	for _, node := range cpu.NumaNodeAffinity {
+		if node < 0 || (host.NumaNodes > 0 && node >= host.NumaNodes) {
+			errs = append(errs, fmt.Sprintf("numa_node_affinity: the host has no NUMA node %d", node))
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("Error: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// getHostCapabilities returns the capabilities of the esxi host.
+func getHostCapabilities(c *Config) (hostCapabilities, error) {
+	log.Printf("[getHostCapabilities]\n")
+
+	var capabilities hostCapabilities
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return capabilities, fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	host, err := getHostSystem(gc.Context(), gc.Finder)
+	if err != nil {
+		return capabilities, err
+	}
+
+	var hostMo mo.HostSystem
+	err = host.Properties(gc.Context(), host.Reference(), []string{"capability", "summary.hardware", "hardware.numaInfo"}, &hostMo)
+	if err != nil {
+		return capabilities, fmt.Errorf("failed to get host capabilities: %w", err)
+	}
+
+	if hostMo.Summary.Hardware != nil {
+		capabilities.CpuThreads = int(hostMo.Summary.Hardware.NumCpuThreads)
+	}
+	if hostMo.Hardware != nil && hostMo.Hardware.NumaInfo != nil {
+		capabilities.NumaNodes = int(hostMo.Hardware.NumaInfo.NumNodes)
+	}
+	if hostMo.Capability != nil {
+		capabilities.NestedHVSupported = hostMo.Capability.NestedHVSupported
+		capabilities.VPMCSupported = hostMo.Capability.VPMCSupported
+		capabilities.LatencySensitivitySupported = hostMo.Capability.LatencySensitivitySupported
+	}
+	return capabilities, nil
+}
+
+// guestValidateCPU checks a guest's cpu settings against the esxi host.  If the
+// host's capabilities can't be read, only numvcpus is checked.
+func guestValidateCPU(c *Config, cpu guestCPU, numvcpus int) error {
+	host, err := getHostCapabilities(c)
+	if err != nil {
+		log.Printf("[guestValidateCPU] %s\n", err)
+	}
+	return cpu.validate(numvcpus, host)
+}
@@ -0,0 +1,113 @@
+package esxi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/vmware/govmomi/simulator"
+)
+
+// TestGuestCPUVmx verifies cpu settings are written to and read from a vmx file
+func TestGuestCPUVmx(t *testing.T) {
+	doc := vmx.Parse("numvcpus = \"4\"\nnuma.autosize.vcpu.maxPerVirtualNode = \"2\"\nvhv.enable = \"TRUE\"\n")
+
+	cpu := guestCPU{CoresPerSocket: 2, PerformanceCounters: true, LatencySensitivity: "high", NumaNodeAffinity: []int{0, 1}, CpuHotAddEnabled: true}
+	guestCPUToVmx(doc, cpu)
+
+	expected := map[string]string{
+		"cpuid.coresPerSocket":         "2",
+		"vpmc.enable":                  "TRUE",
+		"sched.cpu.latencySensitivity": "high",
+		"numa.nodeAffinity":            "0,1",
+		"vcpu.hotadd":                  "TRUE",
+	}
+	for key, value := range expected {
+		if got := doc.Value(key); got != value {
+			t.Errorf("Expected %s = %q, got %q", key, value, got)
+		}
+	}
+	if doc.Has("vhv.enable") {
+		t.Error("Expected vhv.enable to be removed")
+	}
+	if got := guestCPUFromVmx(doc); !reflect.DeepEqual(got, cpu) {
+		t.Errorf("Expected %+v, got %+v", cpu, got)
+	}
+
+	//  numa.autosize isn't numvcpus.
+	if doc.Value("numvcpus") != "4" {
+		t.Errorf("Expected numvcpus to be kept, got %q", doc.Value("numvcpus"))
+	}
+
+	guestCPUToVmx(doc, guestCPU{})
+	for key := range expected {
+		if doc.Has(key) {
+			t.Errorf("Expected %s to be removed", key)
+		}
+	}
+}
+
+// TestGuestCPUValidate verifies cpu settings are checked against numvcpus and the host
+func TestGuestCPUValidate(t *testing.T) {
+	no := false
+	host := hostCapabilities{CpuThreads: 16, NumaNodes: 2, NestedHVSupported: &no}
+
+	if err := (guestCPU{CoresPerSocket: 2, NumaNodeAffinity: []int{1}}).validate(4, host); err != nil {
+		t.Errorf("Expected valid cpu settings, got %v", err)
+	}
+	if err := (guestCPU{CoresPerSocket: 4, NestedHVEnabled: true}).validate(0, hostCapabilities{}); err != nil {
+		t.Errorf("Unknown numvcpus and host capabilities shouldn't be checked, got %v", err)
+	}
+
+	tests := []struct {
+		cpu      guestCPU
+		numvcpus int
+		message  string
+	}{
+		{guestCPU{CoresPerSocket: 3}, 4, "cores_per_socket 3 must divide numvcpus 4"},
+		{guestCPU{}, 32, "more than the host's 16 cpu threads"},
+		{guestCPU{NestedHVEnabled: true}, 2, "nested_hv_enabled is not supported"},
+		{guestCPU{NumaNodeAffinity: []int{0, 2}}, 2, "the host has no NUMA node 2"},
+	}
+	for _, test := range tests {
+		err := test.cpu.validate(test.numvcpus, host)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%+v: expected error containing %q, got %v", test.cpu, test.message, err)
+		}
+	}
+}
+
+// TestGetHostCapabilitiesGovmomi verifies host capabilities with the vcsim simulator
+func TestGetHostCapabilitiesGovmomi(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+	defer config.CloseGovmomiClient()
+
+	host, err := getHostCapabilities(config)
+	if err != nil {
+		t.Fatalf("Failed to get host capabilities: %v", err)
+	}
+	if host.CpuThreads == 0 || host.NumaNodes != 1 {
+		t.Errorf("Unexpected host capabilities %+v", host)
+	}
+	if err = (guestCPU{NumaNodeAffinity: []int{1}}).validate(1, host); err == nil {
+		t.Error("Expected NUMA node 1 to be rejected")
+	}
+}
@@ -0,0 +1,127 @@
+package esxi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+)
+
+var extraConfigKeyRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// vmx keys set by other esxi_guest attributes.  They can't be set by extra_config.
+var managedVmxKeys = map[string]string{
+	"config.version":    "",
+	"virtualhw.version": "virthwver",
+	"memsize":           "memsize",
+	"numvcpus":          "numvcpus",
+	"guestos":           "guestos",
+	"firmware":          "boot_firmware",
+	"annotation":        "notes",
+	"displayname":       "guest_name",
+	"nvram":             "",
+	"disk.enableuuid":   "",
+	"sched.cpu.min":     "cpu_reservation",
+	"sched.cpu.max":     "cpu_limit",
+	"sched.cpu.shares":  "cpu_shares",
+	"sched.mem.min":     "mem_reservation",
+	"sched.mem.max":     "mem_limit",
+	"sched.mem.shares":  "mem_shares",
+	"sched.mem.pin":     "mem_reservation_locked_to_max",
+
+	"cpuid.corespersocket":         "cores_per_socket",
+	"vhv.enable":                   "nested_hv_enabled",
+	"vpmc.enable":                  "cpu_performance_counters",
+	"sched.cpu.latencysensitivity": "latency_sensitivity",
+	"numa.nodeaffinity":            "numa_node_affinity",
+	"vcpu.hotadd":                  "cpu_hot_add_enabled",
+	"mem.hotadd":                   "memory_hot_add_enabled",
+
+	"bios.bootorder":          "boot_order",
+	"bios.bootdelay":          "boot_delay_ms",
+	"uefi.secureboot.enabled": "efi_secure_boot_enabled",
+	"bios.bootretry.enabled":  "boot_retry_enabled",
+	"bios.bootretry.delay":    "boot_retry_delay_ms",
+	"bios.forcesetuponce":     "enter_bios_setup_once",
+}
+
+var managedVmxKeyPrefixes = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^guestinfo\.`),
+	regexp.MustCompile(`(?i)^ethernet\d+\.`),
+	regexp.MustCompile(`(?i)^(scsi|sata|ide|nvme)\d+(:\d+)?\.`),
+}
+
+// managedVmxKey returns the esxi_guest attribute that sets a vmx key, if any.
+func managedVmxKey(key string) (string, bool) {
+	if attr, ok := managedVmxKeys[strings.ToLower(key)]; ok {
+		return attr, true
+	}
+	for _, re := range managedVmxKeyPrefixes {
+		if re.MatchString(key) {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// Validate extra_config keys
+func validateExtraConfig(v interface{}, k string) (ws []string, es []error) {
+	extra_config, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, []error{fmt.Errorf("%s: expected a map", k)}
+	}
+
+	keys := make([]string, 0, len(extra_config))
+	for key := range extra_config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	seen := make(map[string]string)
+	for _, key := range keys {
+		if !extraConfigKeyRe.MatchString(key) {
+			es = append(es, fmt.Errorf("%s: %q is not a valid vmx key", k, key))
+			continue
+		}
+		if other, ok := seen[strings.ToLower(key)]; ok {
+			es = append(es, fmt.Errorf("%s: %q and %q are the same vmx key", k, other, key))
+			continue
+		}
+		seen[strings.ToLower(key)] = key
+
+		if attr, ok := managedVmxKey(key); ok {
+			if attr != "" {
+				es = append(es, fmt.Errorf("%s: %q is managed by this provider, use %s instead", k, key, attr))
+			} else {
+				es = append(es, fmt.Errorf("%s: %q is managed by this provider", k, key))
+			}
+		}
+	}
+	return ws, es
+}
+
+// removedExtraConfigKeys returns the keys in old that are no longer in new.
+func removedExtraConfigKeys(old, new map[string]interface{}) []string {
+	var removed []string
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// extraConfigFromVmx reads the given keys from a guest's vmx file.  Keys that are
+// not set are left out.
+func extraConfigFromVmx(doc *vmx.Document, keys []string) map[string]interface{} {
+	extra_config := make(map[string]interface{})
+	for _, key := range keys {
+		if value, ok := doc.Get(key); ok {
+			extra_config[key] = value
+		}
+	}
+	return extra_config
+}
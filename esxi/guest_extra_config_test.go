@@ -0,0 +1,68 @@
+package esxi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestValidateExtraConfig verifies keys managed by other attributes are rejected
+func TestValidateExtraConfig(t *testing.T) {
+	valid := map[string]interface{}{
+		"hypervisor.cpuid.v0":          "FALSE",
+		"tools.syncTime":               "FALSE",
+		"isolation.tools.copy.disable": "TRUE",
+		"svga.vramSize":                "16777216",
+		"sched.mem.pshare.enable":      "FALSE",
+		"ethernet.allowExtra":          "x",
+	}
+	if _, es := validateExtraConfig(valid, "extra_config"); len(es) != 0 {
+		t.Errorf("valid extra_config rejected: %v", es)
+	}
+
+	invalid := map[string]string{
+		"memSize":               "use memsize instead",
+		"NUMVCPUS":              "use numvcpus instead",
+		"virtualHW.version":     "use virthwver instead",
+		"vhv.enable":            "use nested_hv_enabled instead",
+		"sched.cpu.min":         "use cpu_reservation instead",
+		"guestinfo.userdata":    "is managed by this provider",
+		"ethernet0.networkName": "is managed by this provider",
+		"scsi0:1.fileName":      "is managed by this provider",
+		"sata0.present":         "is managed by this provider",
+		"bad key":               "is not a valid vmx key",
+		"a=b":                   "is not a valid vmx key",
+	}
+	for key, message := range invalid {
+		_, es := validateExtraConfig(map[string]interface{}{key: "x"}, "extra_config")
+		if len(es) != 1 || !strings.Contains(es[0].Error(), message) {
+			t.Errorf("%q: expected error containing %q, got %v", key, message, es)
+		}
+	}
+
+	_, es := validateExtraConfig(map[string]interface{}{"tools.syncTime": "x", "tools.synctime": "y"}, "extra_config")
+	if len(es) != 1 {
+		t.Errorf("keys that differ only in case should be rejected, got %v", es)
+	}
+}
+
+// TestRemovedExtraConfigKeys verifies keys dropped from config are removed
+func TestRemovedExtraConfigKeys(t *testing.T) {
+	old := map[string]interface{}{"vhv.enable": "TRUE", "tools.syncTime": "FALSE", "svga.present": "TRUE"}
+	new := map[string]interface{}{"tools.syncTime": "TRUE"}
+
+	expected := []string{"svga.present", "vhv.enable"}
+	if got := removedExtraConfigKeys(old, new); !reflect.DeepEqual(got, expected) {
+		t.Errorf("removedExtraConfigKeys = %q, expected %q", got, expected)
+	}
+	if got := removedExtraConfigKeys(nil, new); len(got) != 0 {
+		t.Errorf("nothing should be removed on create, got %q", got)
+	}
+
+	//  Adopting a guest reports extra_config changes.
+	cur := guestAdoptState{extra_config: map[string]interface{}{"tools.syncTime": "FALSE"}}
+	want := guestAdoptState{extra_config: map[string]interface{}{"tools.syncTime": "FALSE", "vhv.enable": "TRUE"}}
+	if changes := cur.changesTo(want); !reflect.DeepEqual(changes, []string{`extra_config.vhv.enable: "" => "TRUE"`}) {
+		t.Errorf("unexpected adopt changes %q", changes)
+	}
+}
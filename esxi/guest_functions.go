@@ -3,68 +3,89 @@ package esxi
 import (
 	"fmt"
 	"log"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
 )
 
 func guestGetVMID(c *Config, guest_name string) (string, error) {
-	esxiConnInfo := getConnectionInfo(c)
 	log.Printf("[guestGetVMID]\n")
 
-	var remote_cmd, vmid string
-	var err error
-
-	remote_cmd = fmt.Sprintf("vim-cmd vmsvc/getallvms 2>/dev/null |sort -n | "+
-		"grep -m 1 \"[0-9] * %s .*%s\" |awk '{print $1}' ", guest_name, guest_name)
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return "", fmt.Errorf("Failed get vmid: %s\n", err)
+	}
 
-	vmid, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "get vmid")
-	log.Printf("[guestGetVMID] result: %s\n", vmid)
+	vms, err := listVirtualMachines(gc.Context(), gc.Client.Client, []string{"name"})
 	if err != nil {
 		log.Printf("[guestGetVMID] Failed get vmid: %s\n", err)
 		return "", fmt.Errorf("Failed get vmid: %s\n", err)
 	}
 
+	//  Exact name match.  If there are duplicates, use the lowest vmid.
+	var vmids []string
+	for _, vm := range vms {
+		if unescapeEntityName(vm.Name) == guest_name {
+			vmids = append(vmids, vm.Self.Value)
+		}
+	}
+	sort.Slice(vmids, func(i, j int) bool { return vmidLess(vmids[i], vmids[j]) })
+
+	vmid := ""
+	if len(vmids) > 0 {
+		vmid = vmids[0]
+	}
+	log.Printf("[guestGetVMID] result: %s\n", vmid)
+
 	return vmid, nil
 }
 
 func guestValidateVMID(c *Config, vmid string) (string, error) {
-	esxiConnInfo := getConnectionInfo(c)
 	log.Printf("[guestValidateVMID]\n")
 
-	var remote_cmd string
-	var err error
-
-	remote_cmd = fmt.Sprintf("vim-cmd vmsvc/getallvms 2>/dev/null | awk '{print $1}' | "+
-		"grep '^%s$'", vmid)
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return "", fmt.Errorf("Failed get vmid: %s\n", err)
+	}
 
-	vmid, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "validate vmid exists")
-	log.Printf("[guestValidateVMID] result: %s\n", vmid)
+	vms, err := listVirtualMachines(gc.Context(), gc.Client.Client, []string{"name"})
 	if err != nil {
 		log.Printf("[guestValidateVMID] Failed get vmid: %s\n", err)
 		return "", fmt.Errorf("Failed get vmid: %s\n", err)
 	}
 
-	return vmid, nil
+	for _, vm := range vms {
+		if vm.Self.Value == vmid {
+			log.Printf("[guestValidateVMID] result: %s\n", vmid)
+			return vmid, nil
+		}
+	}
+
+	return "", fmt.Errorf("Failed get vmid: %s not found\n", vmid)
+}
+
+// vmidLess orders vmids numerically, falling back to a string compare.
+func vmidLess(a, b string) bool {
+	ai, aerr := strconv.Atoi(a)
+	bi, berr := strconv.Atoi(b)
+	if aerr == nil && berr == nil {
+		return ai < bi
+	}
+	return a < b
 }
 
 func getBootDiskPath(c *Config, vmid string) (string, error) {
-	esxiConnInfo := getConnectionInfo(c)
 	log.Printf("[getBootDiskPath]\n")
 
-	var remote_cmd, stdout string
-	var err error
-
-	remote_cmd = fmt.Sprintf("vim-cmd vmsvc/device.getdevices %s | grep -A10 -e 'key = 2000' -e 'key = 3000' -e 'key = 16000'|grep -m 1 fileName", vmid)
-	stdout, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "get boot disk")
+	boot_disk_path, _, err := guestBootDisk(c, vmid)
 	if err != nil {
-		log.Printf("[getBootDiskPath] Failed get boot disk path: %s\n", stdout)
+		log.Printf("[getBootDiskPath] Failed get boot disk path: %s\n", err)
 		return "Failed get boot disk path:", err
 	}
-	r := strings.NewReplacer("fileName = \"[", "/vmfs/volumes/",
-		"] ", "/", "\",", "")
-	return r.Replace(stdout), err
+	return boot_disk_path, nil
 }
 
 func getDst_vmx_file(c *Config, vmid string) (string, error) {
@@ -74,13 +95,13 @@ func getDst_vmx_file(c *Config, vmid string) (string, error) {
 	var dst_vmx_ds, dst_vmx, dst_vmx_file string
 
 	//      -Get location of vmx file on esxi host
-	remote_cmd := fmt.Sprintf("vim-cmd vmsvc/get.config %s | grep vmPathName|grep -oE \"\\[.*\\]\"", vmid)
+	remote_cmd := shellCommand("vim-cmd", "vmsvc/get.config", vmid) + " | grep vmPathName|grep -oE \"\\[.*\\]\""
 	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "get dst_vmx_ds")
 	dst_vmx_ds = stdout
 	dst_vmx_ds = strings.Trim(dst_vmx_ds, "[")
 	dst_vmx_ds = strings.Trim(dst_vmx_ds, "]")
 
-	remote_cmd = fmt.Sprintf("vim-cmd vmsvc/get.config %s | grep vmPathName|awk '{print $NF}'|sed 's/[\"|,]//g'", vmid)
+	remote_cmd = shellCommand("vim-cmd", "vmsvc/get.config", vmid) + " | grep vmPathName|awk '{print $NF}'|sed 's/[\"|,]//g'"
 	stdout, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "get dst_vmx")
 	dst_vmx = stdout
 
@@ -95,21 +116,19 @@ func readVmx_contents(c *Config, vmid string) (string, error) {
 	var remote_cmd, vmx_contents string
 
 	dst_vmx_file, err := getDst_vmx_file(c, vmid)
-	remote_cmd = fmt.Sprintf("cat \"%s\"", dst_vmx_file)
+	remote_cmd = shellCommand("cat", dst_vmx_file)
 	vmx_contents, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "read guest_name.vmx file")
 
 	return vmx_contents, err
 }
 
-func updateVmx_contents(c *Config, vmid string, iscreate bool, memsize int, numvcpus int,
-	virthwver int, guestos string, virtual_networks [10][3]string, boot_firmware string, virtual_disks [60][2]string, notes string,
-	guestinfo map[string]interface{}) error {
+func updateVmx_contents(c *Config, vmid string, iscreate bool, memsize int, numvcpus int, cpu *guestCPU,
+	virthwver int, guestos string, virtual_networks []guestNIC, boot_firmware string, virtual_disks [60][2]string,
+	controllers []guestController, cdroms []guestCdrom, notes string, guestinfo map[string]interface{}, removed_guestinfo []string, extra_config map[string]interface{}, removed_extra_config []string,
+	vmx_backup_retention int) error {
 
-	esxiConnInfo := getConnectionInfo(c)
 	log.Printf("[updateVmx_contents]\n")
 
-	var regexReplacement string
-
 	vmx_contents, err := readVmx_contents(c, vmid)
 	if err != nil {
 		log.Printf("[updateVmx_contents] Failed get vmx contents: %s\n", err)
@@ -119,272 +138,135 @@ func updateVmx_contents(c *Config, vmid string, iscreate bool, memsize int, numv
 		return nil
 	}
 
-	// modify memsize
+	doc := vmx.Parse(vmx_contents)
+
 	if memsize != 0 {
-		re := regexp.MustCompile("memSize = \".*\"")
-		regexReplacement = fmt.Sprintf("memSize = \"%d\"", memsize)
-		vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
+		doc.Set("memSize", strconv.Itoa(memsize))
 	}
-
-	// modify numvcpus
 	if numvcpus != 0 {
-		if strings.Contains(vmx_contents, "numvcpus = ") {
-			re := regexp.MustCompile("numvcpus = \".*\"")
-			regexReplacement = fmt.Sprintf("numvcpus = \"%d\"", numvcpus)
-			vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-		} else {
-			log.Printf("[updateVmx_contents] Add numvcpu: %d\n", numvcpus)
-			vmx_contents += fmt.Sprintf("\nnumvcpus = \"%d\"", numvcpus)
-		}
+		doc.Set("numvcpus", strconv.Itoa(numvcpus))
+	}
+	if cpu != nil {
+		guestCPUToVmx(doc, *cpu)
 	}
-
-	// modify virthwver
 	if virthwver != 0 {
-		re := regexp.MustCompile("virtualHW.version = \".*\"")
-		regexReplacement = fmt.Sprintf("virtualHW.version = \"%d\"", virthwver)
-		vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
+		doc.Set("virtualHW.version", strconv.Itoa(virthwver))
 	}
-
-	// modify guestos
 	if guestos != "" {
-		re := regexp.MustCompile("guestOS = \".*\"")
-		regexReplacement = fmt.Sprintf("guestOS = \"%s\"", guestos)
-		vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
+		doc.Set("guestOS", guestos)
+	}
+	if boot_firmware != "" {
+		doc.Set("firmware", boot_firmware)
 	}
-
-	// modify boot_firmware
-	re := regexp.MustCompile("firmware = \".*\"")
-	regexReplacement = fmt.Sprintf("firmware = \"%s\"", boot_firmware)
-	vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-
-	// modify annotation
 	if notes != "" {
-		notes = strings.Replace(notes, "\"", "|22", -1)
-		if strings.Contains(vmx_contents, "annotation") {
-			re := regexp.MustCompile("annotation = \".*\"")
-			regexReplacement = fmt.Sprintf("annotation = \"%s\"", notes)
-			vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-		} else {
-			regexReplacement = fmt.Sprintf("\nannotation = \"%s\"", notes)
-			vmx_contents += regexReplacement
-		}
+		doc.Set("annotation", notes)
 	}
-
-	if len(guestinfo) > 0 {
-		parsed_vmx := ParseVMX(vmx_contents)
-		for k, v := range guestinfo {
-			log.Println("SAVING", k, v)
-			parsed_vmx["guestinfo."+k] = v.(string)
-		}
-		vmx_contents = EncodeVMX(parsed_vmx)
+	for _, k := range removed_guestinfo {
+		log.Printf("[updateVmx_contents] Remove guestinfo: %s\n", k)
+		doc.Delete("guestinfo." + k)
+	}
+	for k, v := range guestinfo {
+		doc.Set("guestinfo."+k, v.(string))
+	}
+	for _, k := range removed_extra_config {
+		log.Printf("[updateVmx_contents] Remove extra_config: %s\n", k)
+		doc.Delete(k)
+	}
+	for k, v := range extra_config {
+		doc.Set(k, v.(string))
 	}
 
 	//
-	//  add/modify virtual disks
-	//
-	var tmpvar string
-	var vmx_contents_new string
-	var i, j int
-
-	//
-	//  Remove all disks
+	//  Remove all disks, except the boot disk, then add the disks that are managed by terraform
 	//
-	regexReplacement = fmt.Sprintf("")
-	for i = 0; i < 4; i++ {
-		for j = 0; j < 16; j++ {
-
-			if (i != 0 || j != 0) && j != 7 {
-				re := regexp.MustCompile(fmt.Sprintf("scsi%d:%d.*\n", i, j))
-				vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-			}
-		}
+	_, boot_slot, err := guestBootDisk(c, vmid)
+	if err != nil {
+		return fmt.Errorf("Failed to find boot disk: %s\n", err)
 	}
+	vmxRemoveDisks(doc, boot_slot)
 
-	//
-	//  Add disks that are managed by terraform
-	//
-	for i = 0; i < 59; i++ {
+	for i := 0; i < 59; i++ {
 		if virtual_disks[i][0] != "" {
-
 			log.Printf("[updateVmx_contents] Adding: %s\n", virtual_disks[i][1])
-			tmpvar = fmt.Sprintf("scsi%s.deviceType = \"scsi-hardDisk\"\n", virtual_disks[i][1])
-			if !strings.Contains(vmx_contents, tmpvar) {
-				vmx_contents += "\n" + tmpvar
+			slot, err := vmx.ParseSlot(virtual_disks[i][1])
+			if err != nil {
+				return err
 			}
-
-			tmpvar = fmt.Sprintf("scsi%s.fileName", virtual_disks[i][1])
-			if strings.Contains(vmx_contents, tmpvar) {
-				re := regexp.MustCompile(tmpvar + " = \".*\"")
-				regexReplacement = fmt.Sprintf(tmpvar+" = \"%s\"", virtual_disks[i][0])
-				vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-			} else {
-				regexReplacement = fmt.Sprintf("\n"+tmpvar+" = \"%s\"", virtual_disks[i][0])
-				vmx_contents += "\n" + regexReplacement
+			if slot == boot_slot {
+				return fmt.Errorf("Slot %s is used by the boot disk\n", slot)
 			}
-
-			tmpvar = fmt.Sprintf("scsi%s.present = \"true\"\n", virtual_disks[i][1])
-			if !strings.Contains(vmx_contents, tmpvar) {
-				vmx_contents += "\n" + tmpvar
+			if slot.Bus == "scsi" {
+				doc.Set(slot.Key("deviceType"), "scsi-hardDisk")
 			}
-
+			doc.Set(slot.Key("fileName"), virtual_disks[i][0])
+			doc.Set(slot.Key("present"), "true")
 		}
 	}
+	guestControllersToVmx(doc, controllers, virtual_disks)
 
 	//
-	//  Create/update networks network_interfaces
+	//  Cdroms, left as they are if cdroms is nil
 	//
-
-	//  Define default nic type.
-	var defaultNetworkType, networkType string
-	if virtual_networks[0][2] != "" {
-		defaultNetworkType = virtual_networks[0][2]
-	} else {
-		defaultNetworkType = "e1000"
-	}
-
-	//  If this is first time provisioning, delete all the old ethernet configuration.
-	if iscreate == true {
-		log.Printf("[updateVmx_contents] Delete old ethernet configuration: %d\n", i)
-		regexReplacement = fmt.Sprintf("")
-		for i = 0; i < 9; i++ {
-			re := regexp.MustCompile(fmt.Sprintf("ethernet%d.*\n", i))
-			vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-		}
-	}
-
-	//  Add/Modify virtual networks.
-	networkType = ""
-
-	for i := 0; i <= 9; i++ {
-		log.Printf("[updateVmx_contents] ethernet%d\n", i)
-
-		if virtual_networks[i][0] == "" && strings.Contains(vmx_contents, "ethernet"+strconv.Itoa(i)) == true {
-			//  This is Modify (Delete existing network configuration)
-			log.Printf("[updateVmx_contents] Modify ethernet%d - Delete existing.\n", i)
-			regexReplacement = fmt.Sprintf("")
-			re := regexp.MustCompile(fmt.Sprintf("ethernet%d.*\n", i))
-			vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-		}
-
-		if virtual_networks[i][0] != "" && strings.Contains(vmx_contents, "ethernet"+strconv.Itoa(i)) == true {
-			//  This is Modify
-			log.Printf("[updateVmx_contents] Modify ethernet%d - Modify existing.\n", i)
-
-			//  Modify Network Name
-			re := regexp.MustCompile("ethernet" + strconv.Itoa(i) + ".networkName = \".*\"")
-			regexReplacement = fmt.Sprintf("ethernet"+strconv.Itoa(i)+".networkName = \"%s\"", virtual_networks[i][0])
-			vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-
-			//  Modify virtual Device
-			re = regexp.MustCompile("ethernet" + strconv.Itoa(i) + ".virtualDev = \".*\"")
-			regexReplacement = fmt.Sprintf("ethernet"+strconv.Itoa(i)+".virtualDev = \"%s\"", virtual_networks[i][2])
-			vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-
-			//  Modify MAC (dynamic to static only. static to dynamic is not implemented)
-			if virtual_networks[i][1] != "" {
-				log.Printf("[updateVmx_contents] ethernet%d Modify MAC: %s\n", i, virtual_networks[i][0])
-
-				re = regexp.MustCompile("ethernet" + strconv.Itoa(i) + ".[a-zA-Z]*ddress = \".*\"")
-				regexReplacement = fmt.Sprintf("ethernet"+strconv.Itoa(i)+".address = \"%s\"", virtual_networks[i][1])
-				vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-
-				re = regexp.MustCompile("ethernet" + strconv.Itoa(i) + ".addressType = \".*\"")
-				regexReplacement = fmt.Sprintf("ethernet" + strconv.Itoa(i) + ".addressType = \"static\"")
-				vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-
-				re = regexp.MustCompile("ethernet" + strconv.Itoa(i) + ".generatedAddressOffset = \".*\"")
-				regexReplacement = fmt.Sprintf("")
-				vmx_contents = re.ReplaceAllString(vmx_contents, regexReplacement)
-			}
+	if cdroms != nil {
+		err = guestUploadIsos(c, vmid, cdroms)
+		if err != nil {
+			return err
 		}
-
-		if virtual_networks[i][0] != "" && strings.Contains(vmx_contents, "ethernet"+strconv.Itoa(i)) == false {
-			//  This is create
-
-			//  Set virtual_network name
-			log.Printf("[updateVmx_contents] ethernet%d Create New: %s\n", i, virtual_networks[i][0])
-			tmpvar = fmt.Sprintf("\nethernet%d.networkName = \"%s\"\n", i, virtual_networks[i][0])
-			vmx_contents_new = tmpvar
-
-			//  Set mac address
-			if virtual_networks[i][1] != "" {
-				tmpvar = fmt.Sprintf("ethernet%d.addressType = \"static\"\n", i)
-				vmx_contents_new = vmx_contents_new + tmpvar
-
-				tmpvar = fmt.Sprintf("ethernet%d.address = \"%s\"\n", i, virtual_networks[i][1])
-				vmx_contents_new = vmx_contents_new + tmpvar
-			}
-
-			//  Set network type
-			if virtual_networks[i][2] == "" {
-				networkType = defaultNetworkType
-			} else {
-				networkType = virtual_networks[i][2]
-			}
-
-			tmpvar = fmt.Sprintf("ethernet%d.virtualDev = \"%s\"\n", i, networkType)
-			vmx_contents_new = vmx_contents_new + tmpvar
-
-			tmpvar = fmt.Sprintf("ethernet%d.present = \"TRUE\"\n", i)
-
-			vmx_contents = vmx_contents + vmx_contents_new + tmpvar
+		err = guestCdromsToVmx(doc, cdroms)
+		if err != nil {
+			return err
 		}
 	}
 
+	//
+	//  Create/update networks network_interfaces
+	//
+	guestNICsToVmx(doc, virtual_networks, iscreate, guestDVSPortgroups(c))
+
 	//  Add disk UUID
-	if !strings.Contains(vmx_contents, "disk.EnableUUID") {
-		vmx_contents = vmx_contents + "\ndisk.EnableUUID = \"TRUE\""
+	if !doc.Has("disk.EnableUUID") {
+		doc.Set("disk.EnableUUID", "TRUE")
 	}
 
 	//
 	//  Write vmx file to esxi host
 	//
-	log.Printf("[updateVmx_contents] New guest_name.vmx: %s\n", vmx_contents)
+	vmx_contents = doc.String()
+	log.Printf("[updateVmx_contents] New guest_name.vmx: %s\n", redactGuestinfo(vmx_contents))
 
-	dst_vmx_file, err := getDst_vmx_file(c, vmid)
-
-	vmx_contents, err = writeContentToRemoteFile(esxiConnInfo, strings.Replace(vmx_contents, "\\\"", "\"", -1), dst_vmx_file, "write guest_name.vmx file")
-
-	err = guestReload(c, vmid)
-	return err
+	return writeVmxTransaction(c, vmid, vmx_contents, vmx_backup_retention)
 }
 
-func cleanStorageFromVmx(c *Config, vmid string) error {
-	esxiConnInfo := getConnectionInfo(c)
+func cleanStorageFromVmx(c *Config, vmid string, vmx_backup_retention int) error {
 	log.Printf("[cleanStorageFromVmx]\n")
 
 	vmx_contents, err := readVmx_contents(c, vmid)
 	if err != nil {
-		log.Printf("[updateVmx_contents] Failed get vmx contents: %s\n", err)
+		log.Printf("[cleanStorageFromVmx] Failed get vmx contents: %s\n", err)
 		return fmt.Errorf("Failed to get vmx contents: %s\n", err)
 	}
 
-	for x := 0; x < 4; x++ {
-		for y := 0; y < 16; y++ {
-			if !(x == 0 && y == 0) {
-				regexReplacement := fmt.Sprintf("scsi%d:%d.*", x, y)
-				re := regexp.MustCompile(regexReplacement)
-				vmx_contents = re.ReplaceAllString(vmx_contents, "")
-			}
-		}
+	//  If the boot disk can't be found, assume it's the first scsi disk.
+	_, boot_slot, err := guestBootDisk(c, vmid)
+	if err != nil {
+		log.Printf("[cleanStorageFromVmx] %s, keeping scsi0:0\n", err)
+		boot_slot = vmx.Slot{Bus: "scsi"}
 	}
 
+	doc := vmx.Parse(vmx_contents)
+	vmxRemoveDisks(doc, boot_slot)
+
 	//
 	//  Write vmx file to esxi host
 	//
-
-	dst_vmx_file, err := getDst_vmx_file(c, vmid)
-	vmx_contents, err = writeContentToRemoteFile(esxiConnInfo, strings.Replace(vmx_contents, "\\\"", "\"", -1), dst_vmx_file, "write guest_name.vmx file")
-
-	err = guestReload(c, vmid)
-	return err
+	return writeVmxTransaction(c, vmid, doc.String(), vmx_backup_retention)
 }
 
 func guestReload(c *Config, vmid string) error {
 	esxiConnInfo := getConnectionInfo(c)
 	log.Printf("[guestReload]\n")
 
-	remote_cmd := fmt.Sprintf("vim-cmd vmsvc/reload %s", vmid)
+	remote_cmd := shellCommand("vim-cmd", "vmsvc/reload", vmid)
 	_, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/reload")
 
 	return err
@@ -399,7 +281,7 @@ func guestPowerOn(c *Config, vmid string) (string, error) {
 		return "", nil
 	}
 
-	remote_cmd := fmt.Sprintf("vim-cmd vmsvc/power.on %s", vmid)
+	remote_cmd := shellCommand("vim-cmd", "vmsvc/power.on", vmid)
 	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/power.on")
 	time.Sleep(3 * time.Second)
 
@@ -410,9 +292,19 @@ func guestPowerOn(c *Config, vmid string) (string, error) {
 	return stdout, err
 }
 
-func guestPowerOff(c *Config, vmid string, guest_shutdown_timeout int) (string, error) {
+// Shutdown behaviors, how a powered on guest is powered off.
+const (
+	shutdownBehaviorGuest         = "guest"           // guest OS shutdown with VMware tools, fail after the timeout
+	shutdownBehaviorHard          = "hard"            // power off
+	shutdownBehaviorGuestThenHard = "guest-then-hard" // guest OS shutdown, power off after the timeout
+)
+
+// guestPowerOff powers off a guest as shutdown_behavior says.  A guest_shutdown_timeout
+// of 0 skips the guest OS shutdown of guest-then-hard.  A suspended guest is
+// always powered off hard.
+func guestPowerOff(c *Config, vmid string, guest_shutdown_timeout int, shutdown_behavior string) (string, error) {
 	esxiConnInfo := getConnectionInfo(c)
-	log.Printf("[guestPowerOff]\n")
+	log.Printf("[guestPowerOff] %s\n", shutdown_behavior)
 
 	var remote_cmd, stdout string
 
@@ -422,8 +314,8 @@ func guestPowerOff(c *Config, vmid string, guest_shutdown_timeout int) (string,
 
 	} else if savedpowerstate == "on" {
 
-		if guest_shutdown_timeout != 0 {
-			remote_cmd = fmt.Sprintf("vim-cmd vmsvc/power.shutdown %s", vmid)
+		if shutdown_behavior == shutdownBehaviorGuest || (shutdown_behavior != shutdownBehaviorHard && guest_shutdown_timeout != 0) {
+			remote_cmd = shellCommand("vim-cmd", "vmsvc/power.shutdown", vmid)
 			stdout, _ = runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/power.shutdown")
 			time.Sleep(3 * time.Second)
 
@@ -433,26 +325,78 @@ func guestPowerOff(c *Config, vmid string, guest_shutdown_timeout int) (string,
 				}
 				time.Sleep(3 * time.Second)
 			}
+			if shutdown_behavior == shutdownBehaviorGuest {
+				if guestPowerGetState(c, vmid) == "off" {
+					return stdout, nil
+				}
+				return stdout, fmt.Errorf("guest did not shut down within %d seconds", guest_shutdown_timeout)
+			}
 		}
 
-		remote_cmd = fmt.Sprintf("vim-cmd vmsvc/power.off %s", vmid)
+		remote_cmd = shellCommand("vim-cmd", "vmsvc/power.off", vmid)
 		stdout, _ = runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/power.off")
 		time.Sleep(1 * time.Second)
 
 		return stdout, nil
 
 	} else {
-		remote_cmd = fmt.Sprintf("vim-cmd vmsvc/power.off %s", vmid)
+		remote_cmd = shellCommand("vim-cmd", "vmsvc/power.off", vmid)
 		stdout, _ = runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/power.off")
 		return stdout, nil
 	}
 }
 
+// guestPowerSuspend suspends a powered on guest.
+func guestPowerSuspend(c *Config, vmid string) (string, error) {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Printf("[guestPowerSuspend]\n")
+
+	if guestPowerGetState(c, vmid) == "suspended" {
+		return "", nil
+	}
+
+	remote_cmd := shellCommand("vim-cmd", "vmsvc/power.suspend", vmid)
+	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/power.suspend")
+	time.Sleep(3 * time.Second)
+
+	if guestPowerGetState(c, vmid) == "suspended" {
+		return stdout, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("guest is not suspended")
+	}
+	return stdout, err
+}
+
+// guestReboot restarts a powered on guest.  guest reboots the guest OS with
+// VMware tools, hard resets the guest, and guest-then-hard resets it only if
+// VMware tools aren't running.
+func guestReboot(c *Config, vmid string, shutdown_behavior string) (string, error) {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Printf("[guestReboot] %s\n", shutdown_behavior)
+
+	tools_running := shutdown_behavior != shutdownBehaviorHard && guestToolsRunning(c, vmid)
+	if shutdown_behavior == shutdownBehaviorGuest && !tools_running {
+		return "", fmt.Errorf("VMware tools are not running, the guest OS can't be rebooted")
+	}
+
+	remote_cmd := shellCommand("vim-cmd", "vmsvc/power.reset", vmid)
+	if tools_running {
+		remote_cmd = shellCommand("vim-cmd", "vmsvc/power.reboot", vmid)
+	}
+	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/power.reboot")
+	if err != nil {
+		return stdout, err
+	}
+	time.Sleep(3 * time.Second)
+	return stdout, nil
+}
+
 func guestPowerGetState(c *Config, vmid string) string {
 	esxiConnInfo := getConnectionInfo(c)
 	log.Printf("[guestPowerGetState]\n")
 
-	remote_cmd := fmt.Sprintf("vim-cmd vmsvc/power.getstate %s", vmid)
+	remote_cmd := shellCommand("vim-cmd", "vmsvc/power.getstate", vmid)
 	stdout, _ := runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/power.getstate")
 	if strings.Contains(stdout, "Unable to find a VM corresponding") {
 		return "Unknown"
@@ -469,55 +413,6 @@ func guestPowerGetState(c *Config, vmid string) string {
 	}
 }
 
-func guestGetIpAddress(c *Config, vmid string, guest_startup_timeout int) string {
-	esxiConnInfo := getConnectionInfo(c)
-	log.Printf("[guestGetIpAddress]\n")
-
-	var remote_cmd, stdout, ip_address, ip_address2 string
-	var uptime int
-
-	//  Check if powered off
-	if guestPowerGetState(c, vmid) != "on" {
-		return ""
-	}
-
-	//
-	//  Check uptime of guest.
-	//
-	uptime = 0
-	for uptime < guest_startup_timeout {
-		//  Primary method to get IP
-		remote_cmd = fmt.Sprintf("vim-cmd vmsvc/get.guest %s 2>/dev/null |sed '1!G;h;$!d' |awk '/deviceConfigId = 4000/,/ipAddress/' |grep -m 1 -oE '((1?[0-9][0-9]?|2[0-4][0-9]|25[0-5])\\.){3}(1?[0-9][0-9]?|2[0-4][0-9]|25[0-5])'", vmid)
-		stdout, _ = runRemoteSshCommand(esxiConnInfo, remote_cmd, "get ip_address method 1")
-		ip_address = stdout
-		if ip_address != "" {
-			return ip_address
-		}
-
-		time.Sleep(3 * time.Second)
-
-		//  Get uptime if above failed.
-		remote_cmd = fmt.Sprintf("vim-cmd vmsvc/get.summary %s 2>/dev/null | grep 'uptimeSeconds ='|sed 's/^.*= //g'|sed s/,//g", vmid)
-		stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "get uptime")
-		if err != nil {
-			return ""
-		}
-		uptime, _ = strconv.Atoi(stdout)
-	}
-
-	//
-	// Alternate method to get IP
-	//
-	remote_cmd = fmt.Sprintf("vim-cmd vmsvc/get.guest %s 2>/dev/null | grep -m 1 '^   ipAddress = ' | grep -oE '((1?[0-9][0-9]?|2[0-4][0-9]|25[0-5])\\.){3}(1?[0-9][0-9]?|2[0-4][0-9]|25[0-5])'", vmid)
-	stdout, _ = runRemoteSshCommand(esxiConnInfo, remote_cmd, "get ip_address method 2")
-	ip_address2 = stdout
-	if ip_address2 != "" {
-		return ip_address2
-	}
-
-	return ""
-}
-
 // ============================================================================
 // Govmomi-based VM Operations
 // ============================================================================
@@ -0,0 +1,86 @@
+package esxi
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+)
+
+// TestGuestGetVMIDExactMatch verifies guest lookup by name does not treat names as patterns
+func TestGuestGetVMIDExactMatch(t *testing.T) {
+	model := simulator.ESX()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	gc, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := gc.Finder.VirtualMachineList(gc.Context(), "*")
+	if err != nil || len(vms) < 2 {
+		t.Fatal("Failed to find VMs in simulator")
+	}
+
+	// Give the simulator VMs names full of shell and regex metacharacters
+	names := []string{"web.*01", "web101"}
+	if len(vms) > 2 {
+		names = append(names, `it's "$(id)" [x]`)
+	}
+	expected := make(map[string]string)
+	for i, name := range names {
+		task, err := vms[i].Rename(gc.Context(), name)
+		if err != nil {
+			t.Fatalf("Failed to rename VM: %v", err)
+		}
+		if err = task.Wait(gc.Context()); err != nil {
+			t.Fatalf("Failed to rename VM: %v", err)
+		}
+		expected[name] = vms[i].Reference().Value
+	}
+
+	for name, vmid := range expected {
+		got, err := guestGetVMID(config, name)
+		if err != nil {
+			t.Fatalf("guestGetVMID(%q) failed: %v", name, err)
+		}
+		if got != vmid {
+			t.Errorf("guestGetVMID(%q) = %q, expected %q", name, got, vmid)
+		}
+
+		validated, err := guestValidateVMID(config, vmid)
+		if err != nil || validated != vmid {
+			t.Errorf("guestValidateVMID(%q) = %q, %v", vmid, validated, err)
+		}
+	}
+
+	// Partial names and patterns must not match
+	for _, name := range []string{"web", "web.*", "web1", ".*"} {
+		got, err := guestGetVMID(config, name)
+		if err != nil {
+			t.Fatalf("guestGetVMID(%q) failed: %v", name, err)
+		}
+		if got != "" {
+			t.Errorf("guestGetVMID(%q) should not match, got %q", name, got)
+		}
+	}
+
+	if _, err := guestValidateVMID(config, "1 || true"); err == nil {
+		t.Error("guestValidateVMID should reject an unknown vmid")
+	}
+}
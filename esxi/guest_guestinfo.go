@@ -0,0 +1,172 @@
+package esxi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// guestinfoEncodedKeys are the guestinfo keys read by VMware's cloud-init
+// datasource with an encoding set in <key>.encoding.
+var guestinfoEncodedKeys = map[string]bool{
+	"metadata":   true,
+	"userdata":   true,
+	"vendordata": true,
+}
+
+// guestinfoVmxLineRe matches a guestinfo line of a vmx file, up to its value.
+var guestinfoVmxLineRe = regexp.MustCompile(`(?im)^(\s*guestinfo\.[^=\n]*=\s*)".*"\s*$`)
+
+// redactGuestinfo hides the guestinfo values of a vmx file, so it can be logged.
+func redactGuestinfo(vmx_contents string) string {
+	return guestinfoVmxLineRe.ReplaceAllString(vmx_contents, `$1"<sensitive>"`)
+}
+
+// guestinfoEncode encodes a guestinfo value as cloud-init's datasource expects
+func guestinfoEncode(value, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return value, nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	case "gzip+base64":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(value)); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+	}
+	return "", fmt.Errorf("unknown guestinfo encoding %q", encoding)
+}
+
+// guestinfoDecode decodes a guestinfo value.  The short names cloud-init accepts
+// are decoded too.
+func guestinfoDecode(value, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return value, nil
+	case "base64", "b64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		return string(data), err
+	case "gzip+base64", "gz+b64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		data, err = io.ReadAll(zr)
+		return string(data), err
+	}
+	return "", fmt.Errorf("unknown guestinfo encoding %q", encoding)
+}
+
+// guestinfoVmx merges guestinfo and sensitive_guestinfo into the guestinfo keys
+// of the vmx file (without the "guestinfo." prefix).  With an encoding, the
+// metadata, userdata and vendordata values are encoded and <key>.encoding is set.
+func guestinfoVmx(guestinfo, sensitive_guestinfo map[string]interface{}, encoding string) (map[string]interface{}, error) {
+	//  <key>.encoding is set by guestinfo_encoding.
+	if encoding != "" {
+		for key := range guestinfoEncodedKeys {
+			for _, values := range []map[string]interface{}{guestinfo, sensitive_guestinfo} {
+				if _, ok := values[key+".encoding"]; ok {
+					return nil, fmt.Errorf("guestinfo key %q is set by guestinfo_encoding", key+".encoding")
+				}
+			}
+		}
+	}
+
+	vmx_guestinfo := make(map[string]interface{})
+	for _, values := range []map[string]interface{}{guestinfo, sensitive_guestinfo} {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if _, ok := vmx_guestinfo[key]; ok {
+				return nil, fmt.Errorf("guestinfo key %q is set more than once", key)
+			}
+			value := values[key].(string)
+			if encoding != "" && guestinfoEncodedKeys[key] {
+				encoded, err := guestinfoEncode(value, encoding)
+				if err != nil {
+					return nil, err
+				}
+				value = encoded
+				vmx_guestinfo[key+".encoding"] = encoding
+			}
+			vmx_guestinfo[key] = value
+		}
+	}
+
+	return vmx_guestinfo, nil
+}
+
+// guestinfoFromResourceData returns the guestinfo keys of the vmx file set by the
+// guestinfo, sensitive_guestinfo and guestinfo_encoding attributes, and the keys
+// that were removed from them.
+func guestinfoFromResourceData(d resourceChanges) (map[string]interface{}, []string, error) {
+	old_guestinfo, new_guestinfo := d.GetChange("guestinfo")
+	old_sensitive, new_sensitive := d.GetChange("sensitive_guestinfo")
+	old_encoding, new_encoding := d.GetChange("guestinfo_encoding")
+
+	guestinfo, err := guestinfoVmx(new_guestinfo.(map[string]interface{}), new_sensitive.(map[string]interface{}), new_encoding.(string))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//  The old values were applied already, so they can't conflict.
+	old, err := guestinfoVmx(old_guestinfo.(map[string]interface{}), old_sensitive.(map[string]interface{}), old_encoding.(string))
+	if err != nil {
+		old = nil
+	}
+	return guestinfo, removedExtraConfigKeys(old, guestinfo), nil
+}
+
+// guestinfoToResourceData sets guestinfo and sensitive_guestinfo from the guestinfo
+// keys of the vmx file.  Only the keys terraform manages are read back, and
+// values encoded by guestinfo_encoding are decoded.
+func guestinfoToResourceData(d *schema.ResourceData, vmx_guestinfo map[string]interface{}) error {
+	encoding := d.Get("guestinfo_encoding").(string)
+
+	for _, attr := range []string{"guestinfo", "sensitive_guestinfo"} {
+		configured := d.Get(attr).(map[string]interface{})
+		if len(configured) == 0 {
+			continue
+		}
+
+		values := make(map[string]interface{})
+		for key := range configured {
+			value, ok := vmx_guestinfo[key]
+			if !ok {
+				continue
+			}
+			if encoding != "" && guestinfoEncodedKeys[key] {
+				key_encoding, _ := vmx_guestinfo[key+".encoding"].(string)
+				decoded, err := guestinfoDecode(value.(string), key_encoding)
+				if err != nil {
+					return fmt.Errorf("Failed to decode guestinfo.%s: %s\n", key, err)
+				}
+				value = decoded
+			}
+			values[key] = value
+		}
+		d.Set(attr, values)
+	}
+	return nil
+}
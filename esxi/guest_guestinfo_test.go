@@ -0,0 +1,93 @@
+package esxi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestGuestinfoEncoding verifies encoded values decode back, including cloud-init's short names
+func TestGuestinfoEncoding(t *testing.T) {
+	userdata := "#cloud-config\npackages:\n  - nginx\n"
+
+	for _, encoding := range []string{"", "base64", "gzip+base64"} {
+		encoded, err := guestinfoEncode(userdata, encoding)
+		if err != nil {
+			t.Fatalf("%q: %v", encoding, err)
+		}
+		if encoding != "" && strings.Contains(encoded, "cloud-config") {
+			t.Errorf("%q: value was not encoded: %q", encoding, encoded)
+		}
+		decoded, err := guestinfoDecode(encoded, encoding)
+		if err != nil || decoded != userdata {
+			t.Errorf("%q: decoded %q, %v", encoding, decoded, err)
+		}
+	}
+
+	if decoded, err := guestinfoDecode("aGVsbG8=", "b64"); err != nil || decoded != "hello" {
+		t.Errorf("b64: decoded %q, %v", decoded, err)
+	}
+	if _, err := guestinfoEncode("x", "gzip"); err == nil {
+		t.Error("unknown encodings should be rejected")
+	}
+}
+
+// TestGuestinfoVmx verifies guestinfo and sensitive_guestinfo are merged and encoded
+func TestGuestinfoVmx(t *testing.T) {
+	guestinfo := map[string]interface{}{"metadata": "local-hostname: web1", "role": "web"}
+	sensitive := map[string]interface{}{"userdata": "password: secret"}
+
+	vmx_guestinfo, err := guestinfoVmx(guestinfo, sensitive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"metadata": "local-hostname: web1", "role": "web", "userdata": "password: secret"}
+	if !reflect.DeepEqual(vmx_guestinfo, expected) {
+		t.Errorf("guestinfoVmx = %v", vmx_guestinfo)
+	}
+
+	vmx_guestinfo, err = guestinfoVmx(guestinfo, sensitive, "base64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]interface{}{
+		"metadata":          "bG9jYWwtaG9zdG5hbWU6IHdlYjE=",
+		"metadata.encoding": "base64",
+		"role":              "web",
+		"userdata":          "cGFzc3dvcmQ6IHNlY3JldA==",
+		"userdata.encoding": "base64",
+	}
+	if !reflect.DeepEqual(vmx_guestinfo, expected) {
+		t.Errorf("guestinfoVmx = %v", vmx_guestinfo)
+	}
+
+	if _, err = guestinfoVmx(guestinfo, map[string]interface{}{"role": "db"}, ""); err == nil {
+		t.Error("a key in both guestinfo and sensitive_guestinfo should be rejected")
+	}
+	if _, err = guestinfoVmx(map[string]interface{}{"userdata.encoding": "base64"}, nil, "gzip+base64"); err == nil {
+		t.Error("<key>.encoding should be rejected with guestinfo_encoding")
+	}
+
+	//  Dropping guestinfo_encoding removes the encoding keys.
+	old, _ := guestinfoVmx(guestinfo, sensitive, "base64")
+	new, _ := guestinfoVmx(guestinfo, nil, "")
+	if got := removedExtraConfigKeys(old, new); !reflect.DeepEqual(got, []string{"metadata.encoding", "userdata", "userdata.encoding"}) {
+		t.Errorf("removed keys = %q", got)
+	}
+}
+
+// TestRedactGuestinfo verifies guestinfo values are hidden from logged vmx files
+func TestRedactGuestinfo(t *testing.T) {
+	vmx_contents := "displayName = \"web1\"\n" +
+		"guestinfo.userdata = \"cGFzc3dvcmQ6IHNlY3JldA==\"\n" +
+		"GuestInfo.Password = \"secret\"\n" +
+		"memSize = \"512\"\n"
+
+	expected := "displayName = \"web1\"\n" +
+		"guestinfo.userdata = \"<sensitive>\"\n" +
+		"GuestInfo.Password = \"<sensitive>\"\n" +
+		"memSize = \"512\"\n"
+	if got := redactGuestinfo(vmx_contents); got != expected {
+		t.Errorf("redactGuestinfo = %q", got)
+	}
+}
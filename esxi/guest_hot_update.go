@@ -0,0 +1,303 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guestHotKeys are the esxi_guest attributes that are applied while the guest
+// is powered on.
+var guestHotKeys = map[string]bool{
+	"notes":                         true,
+	"boot_disk_size":                true,
+	"cpu_reservation":               true,
+	"cpu_limit":                     true,
+	"cpu_shares":                    true,
+	"mem_reservation":               true,
+	"mem_limit":                     true,
+	"mem_shares":                    true,
+	"mem_reservation_locked_to_max": true,
+	"boot_order":                    true,
+	"boot_delay_ms":                 true,
+	"boot_retry_enabled":            true,
+	"boot_retry_delay_ms":           true,
+	"enter_bios_setup_once":         true,
+}
+
+// guestSettingKeys are the esxi_guest attributes that don't change the guest.
+var guestSettingKeys = map[string]bool{
+	"power":                  true,
+	"reboot_trigger":         true,
+	"shutdown_behavior":      true,
+	"autostart":              true,
+	"guest_startup_timeout":  true,
+	"guest_shutdown_timeout": true,
+	"ovf_properties_timer":   true,
+	"vmx_backup_retention":   true,
+	"keep_on_failure":        true,
+	"on_conflict":            true,
+	"wait_for":               true,
+	"preferred_ip_cidrs":     true,
+	"ip_address":             true,
+	"ip_addresses":           true,
+	"requires_reboot":        true,
+}
+
+// resourceChanges is the part of schema.ResourceData and schema.ResourceDiff
+// needed to classify an update.
+type resourceChanges interface {
+	Get(string) interface{}
+	GetChange(string) (interface{}, interface{})
+	HasChange(string) bool
+}
+
+// guestNICsConnectedChanged tells if only the connected state of the network
+// interfaces changed.
+func guestNICsConnectedChanged(d resourceChanges) bool {
+	old_nics, new_nics := d.GetChange("network_interfaces")
+	old_list, new_list := old_nics.([]interface{}), new_nics.([]interface{})
+	if len(old_list) != len(new_list) {
+		return false
+	}
+	for i := range old_list {
+		old_nic, _ := old_list[i].(map[string]interface{})
+		new_nic, _ := new_list[i].(map[string]interface{})
+		for _, key := range []string{"virtual_network", "mac_address", "nic_type", "start_connected"} {
+			if !reflect.DeepEqual(old_nic[key], new_nic[key]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// guestHotAdded tells if numvcpus or memsize only grew, with hot-add enabled
+// before and after the update.
+func guestHotAdded(d resourceChanges, key string, hot_add_key string) bool {
+	old_enabled, new_enabled := d.GetChange(hot_add_key)
+	if !old_enabled.(bool) || !new_enabled.(bool) {
+		return false
+	}
+	old_value, new_value := d.GetChange(key)
+	old_size, _ := strconv.Atoi(old_value.(string))
+	new_size, err := strconv.Atoi(new_value.(string))
+	return err == nil && new_size > old_size
+}
+
+// guestColdChanges returns the changed attributes that can't be applied while
+// the guest is powered on.  guestinfo is only applied live if VMware tools are
+// running, so the guest can read the new values.
+func guestColdChanges(d resourceChanges, tools_running bool) []string {
+	resource := resourceGUEST()
+
+	var cold []string
+	for key, attr := range resource.Schema {
+		if attr.ForceNew || guestHotKeys[key] || guestSettingKeys[key] || !d.HasChange(key) {
+			continue
+		}
+
+		switch key {
+		case "network_interfaces":
+			if guestNICsConnectedChanged(d) {
+				continue
+			}
+		case "guestinfo", "sensitive_guestinfo", "guestinfo_encoding":
+			if tools_running {
+				continue
+			}
+		case "numvcpus":
+			if guestHotAdded(d, "numvcpus", "cpu_hot_add_enabled") {
+				continue
+			}
+		case "memsize":
+			if guestHotAdded(d, "memsize", "memory_hot_add_enabled") {
+				continue
+			}
+		}
+		cold = append(cold, key)
+	}
+
+	//  Notes can't be cleared live, as an empty annotation isn't sent.
+	if d.HasChange("notes") && d.Get("notes").(string) == "" {
+		cold = append(cold, "notes")
+	}
+
+	sort.Strings(cold)
+	return cold
+}
+
+// guestSettingsChanged tells if only settings that don't change the guest
+// changed, so a suspended guest can stay suspended.
+func guestSettingsChanged(d resourceChanges) bool {
+	return len(guestSuspendedColdChanges(d)) == 0
+}
+
+// guestSuspendedColdChanges returns the changed attributes that can't be applied
+// to a suspended guest without powering it off, which discards its suspended state.
+func guestSuspendedColdChanges(d resourceChanges) []string {
+	var cold []string
+	for key, attr := range resourceGUEST().Schema {
+		if !attr.ForceNew && !guestSettingKeys[key] && d.HasChange(key) {
+			cold = append(cold, key)
+		}
+	}
+	sort.Strings(cold)
+	return cold
+}
+
+// guestSuspendedUpdateError refuses cold changes to a guest that stays suspended.
+// Powering it off would silently discard its suspended state, so power has to be
+// set to on or off to apply them.
+func guestSuspendedUpdateError(cold []string) error {
+	return fmt.Errorf("Error: the guest is suspended and changes to %s would power it off, discarding its suspended state. Set power to on or off to apply them.",
+		strings.Join(cold, ", "))
+}
+
+// guestToolsRunning tells if VMware tools are running in the guest.
+func guestToolsRunning(c *Config, vmid string) bool {
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		log.Printf("[guestToolsRunning] Failed to get govmomi client: %s\n", err)
+		return false
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		log.Printf("[guestToolsRunning] %s\n", err)
+		return false
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(gc.Context(), vm.Reference(), []string{"guest.toolsRunningStatus"}, &vmMo)
+	if err != nil {
+		log.Printf("[guestToolsRunning] Failed to get guest properties: %s\n", err)
+		return false
+	}
+	return vmMo.Guest != nil && vmMo.Guest.ToolsRunningStatus == string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+}
+
+// guestHotUpdate applies the hot changes to a powered on guest with one
+// reconfigure.  Removed guestinfo keys are emptied, which removes them.
+func guestHotUpdate(c *Config, vmid string, d resourceChanges, guestinfo map[string]interface{}, removed_guestinfo []string) error {
+	log.Printf("[guestHotUpdate]\n")
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+
+	var spec types.VirtualMachineConfigSpec
+	changed := false
+
+	if d.HasChange("notes") {
+		spec.Annotation = d.Get("notes").(string)
+		changed = true
+	}
+
+	if d.HasChange("guestinfo") || d.HasChange("sensitive_guestinfo") || d.HasChange("guestinfo_encoding") {
+		keys := make([]string, 0, len(guestinfo))
+		for key := range guestinfo {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			spec.ExtraConfig = append(spec.ExtraConfig, &types.OptionValue{Key: "guestinfo." + key, Value: guestinfo[key].(string)})
+		}
+		for _, key := range removed_guestinfo {
+			spec.ExtraConfig = append(spec.ExtraConfig, &types.OptionValue{Key: "guestinfo." + key, Value: ""})
+		}
+		changed = changed || len(spec.ExtraConfig) > 0
+	}
+
+	if d.HasChange("numvcpus") {
+		numvcpus, _ := strconv.Atoi(d.Get("numvcpus").(string))
+		spec.NumCPUs = int32(numvcpus)
+		changed = true
+	}
+	if d.HasChange("memsize") {
+		memsize, _ := strconv.Atoi(d.Get("memsize").(string))
+		spec.MemoryMB = int64(memsize)
+		changed = true
+	}
+
+	//  The boot disk only grows.
+	if d.HasChange("boot_disk_size") {
+		size, _ := strconv.Atoi(d.Get("boot_disk_size").(string))
+		devices, err := vm.Device(gc.Context())
+		if err != nil {
+			return fmt.Errorf("Failed to get guest devices: %s\n", err)
+		}
+		disk := bootVirtualDisk(devices)
+		capacity := int64(size) * 1024 * 1024
+		if disk != nil && capacity > disk.CapacityInKB {
+			log.Printf("[guestHotUpdate] Growing boot disk to %dG\n", size)
+			disk.CapacityInKB = capacity
+			disk.CapacityInBytes = capacity * 1024
+			spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationEdit,
+				Device:    disk,
+			})
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	task, err := vm.Reconfigure(gc.Context(), spec)
+	if err == nil {
+		err = waitForTask(gc.Context(), task)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to update powered on guest: %s\n", err)
+	}
+	return nil
+}
+
+// resourceGUESTCustomizeDiff plans requires_reboot when an update of a powered
+// on or suspended guest has cold changes.  Cold changes to a guest that stays
+// suspended are refused.  A create plans the adopt_changes.
+func resourceGUESTCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" {
+		return guestAdoptCustomizeDiff(d, m.(*Config))
+	}
+	old_power, new_power := d.GetChange("power")
+	if old_power.(string) == "suspended" {
+		cold := guestSuspendedColdChanges(d)
+		switch {
+		case len(cold) == 0:
+			return nil
+		case new_power.(string) == "suspended":
+			return guestSuspendedUpdateError(cold)
+		case new_power.(string) == "on":
+			log.Printf("[resourceGUESTCustomizeDiff] requires reboot: %v\n", cold)
+			return d.SetNew("requires_reboot", true)
+		}
+		return nil
+	}
+	if old_power.(string) != "on" || new_power.(string) == "off" {
+		return nil
+	}
+
+	//  Only ask the host when it matters.
+	tools_running := false
+	if d.HasChange("guestinfo") || d.HasChange("sensitive_guestinfo") || d.HasChange("guestinfo_encoding") {
+		tools_running = guestToolsRunning(m.(*Config), d.Id())
+	}
+	if cold := guestColdChanges(d, tools_running); len(cold) > 0 {
+		log.Printf("[resourceGUESTCustomizeDiff] requires reboot: %v\n", cold)
+		return d.SetNew("requires_reboot", true)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package esxi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// testChanges is a resourceChanges from old and new values.  Missing new
+// values are unchanged.
+type testChanges struct {
+	old map[string]interface{}
+	new map[string]interface{}
+}
+
+func (d testChanges) Get(key string) interface{} {
+	_, new := d.GetChange(key)
+	return new
+}
+
+func (d testChanges) GetChange(key string) (interface{}, interface{}) {
+	old, ok := d.old[key]
+	if !ok {
+		old = resourceGUEST().Schema[key].ZeroValue()
+	}
+	if new, ok := d.new[key]; ok {
+		return old, new
+	}
+	return old, old
+}
+
+func (d testChanges) HasChange(key string) bool {
+	old, new := d.GetChange(key)
+	return !reflect.DeepEqual(old, new)
+}
+
+// TestGuestColdChanges verifies updates are classified as hot or cold
+func TestGuestColdChanges(t *testing.T) {
+	nic := func(network string, connected bool) []interface{} {
+		return []interface{}{map[string]interface{}{
+			"virtual_network": network, "mac_address": "", "nic_type": "vmxnet3", "connected": connected, "start_connected": true,
+		}}
+	}
+	hot_add := map[string]interface{}{"numvcpus": "2", "memsize": "2048", "cpu_hot_add_enabled": true, "memory_hot_add_enabled": true}
+
+	for _, tc := range []struct {
+		name          string
+		changes       testChanges
+		tools_running bool
+		cold          []string
+	}{
+		{"notes", testChanges{map[string]interface{}{"notes": "a"}, map[string]interface{}{"notes": "b"}}, false, nil},
+		{"cleared notes", testChanges{map[string]interface{}{"notes": "a"}, map[string]interface{}{"notes": ""}}, false, []string{"notes"}},
+		{"nic connected", testChanges{map[string]interface{}{"network_interfaces": nic("VM Network", true)}, map[string]interface{}{"network_interfaces": nic("VM Network", false)}}, false, nil},
+		{"nic network", testChanges{map[string]interface{}{"network_interfaces": nic("VM Network", true)}, map[string]interface{}{"network_interfaces": nic("lan", true)}}, false, []string{"network_interfaces"}},
+		{"guestinfo with tools", testChanges{nil, map[string]interface{}{"guestinfo": map[string]interface{}{"role": "web"}}}, true, nil},
+		{"guestinfo without tools", testChanges{nil, map[string]interface{}{"guestinfo": map[string]interface{}{"role": "web"}}}, false, []string{"guestinfo"}},
+		{"boot disk and allocation", testChanges{map[string]interface{}{"boot_disk_size": "16"}, map[string]interface{}{"boot_disk_size": "32", "cpu_shares": "high"}}, false, nil},
+		{"hot-add", testChanges{hot_add, map[string]interface{}{"numvcpus": "4", "memsize": "4096"}}, false, nil},
+		{"hot remove", testChanges{hot_add, map[string]interface{}{"numvcpus": "1"}}, false, []string{"numvcpus"}},
+		{"hot-add disabled", testChanges{map[string]interface{}{"numvcpus": "2", "memsize": "2048"}, map[string]interface{}{"numvcpus": "4", "memsize": "4096"}}, false, []string{"memsize", "numvcpus"}},
+		{"enable hot-add", testChanges{map[string]interface{}{"numvcpus": "2"}, map[string]interface{}{"numvcpus": "4", "cpu_hot_add_enabled": true}}, false, []string{"cpu_hot_add_enabled", "numvcpus"}},
+		{"reboot and shutdown", testChanges{nil, map[string]interface{}{"reboot_trigger": map[string]interface{}{"kernel": "5.15"}, "shutdown_behavior": "hard"}}, false, nil},
+		{"cold keys", testChanges{nil, map[string]interface{}{"virthwver": "19", "extra_config": map[string]interface{}{"tools.syncTime": "FALSE"}}}, false, []string{"extra_config", "virthwver"}},
+	} {
+		if cold := guestColdChanges(tc.changes, tc.tools_running); !reflect.DeepEqual(cold, tc.cold) {
+			t.Errorf("%s: expected cold changes %q, got %q", tc.name, tc.cold, cold)
+		}
+	}
+}
+
+// TestGuestSettingsChanged verifies a suspended guest is only left suspended for setting changes
+func TestGuestSettingsChanged(t *testing.T) {
+	settings := testChanges{map[string]interface{}{"power": "suspended"}, map[string]interface{}{"power": "on", "reboot_trigger": map[string]interface{}{"v": "2"}}}
+	if !guestSettingsChanged(settings) {
+		t.Error("Expected power and reboot_trigger to be settings")
+	}
+	if guestSettingsChanged(testChanges{nil, map[string]interface{}{"notes": "web"}}) {
+		t.Error("Expected notes to change the guest")
+	}
+}
+
+// TestGuestSuspendedColdChanges verifies cold changes to a suspended guest are listed and refused
+func TestGuestSuspendedColdChanges(t *testing.T) {
+	changes := testChanges{map[string]interface{}{"power": "suspended"}, map[string]interface{}{"notes": "web", "memsize": "4096", "shutdown_behavior": "hard"}}
+	cold := guestSuspendedColdChanges(changes)
+	if !reflect.DeepEqual(cold, []string{"memsize", "notes"}) {
+		t.Errorf("unexpected cold changes %q", cold)
+	}
+	if err := guestSuspendedUpdateError(cold); err == nil || !strings.Contains(err.Error(), "memsize, notes") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// TestGuestHotUpdateGovmomi verifies hot changes are applied to a running guest with the vcsim simulator
+func TestGuestHotUpdateGovmomi(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("Failed to find a guest: %v", err)
+	}
+	vm := vms[0]
+
+	changes := testChanges{
+		old: map[string]interface{}{"notes": "", "numvcpus": "1", "memsize": "32", "boot_disk_size": "1"},
+		new: map[string]interface{}{"notes": "web server", "numvcpus": "2", "memsize": "64", "boot_disk_size": "20",
+			"guestinfo": map[string]interface{}{"role": "web"}},
+	}
+	err = guestHotUpdate(config, vm.Reference().Value, changes, map[string]interface{}{"role": "web"}, []string{"old"})
+	if err != nil {
+		t.Fatalf("Failed to update guest: %v", err)
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(client.Context(), vm.Reference(), []string{"config"}, &vmMo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vmMo.Config.Annotation != "web server" {
+		t.Errorf("Expected notes to be set, got %q", vmMo.Config.Annotation)
+	}
+	if vmMo.Config.Hardware.NumCPU != 2 || vmMo.Config.Hardware.MemoryMB != 64 {
+		t.Errorf("Expected 2 cpus and 64MB, got %d and %dMB", vmMo.Config.Hardware.NumCPU, vmMo.Config.Hardware.MemoryMB)
+	}
+	role := ""
+	for _, option := range vmMo.Config.ExtraConfig {
+		if value := option.GetOptionValue(); value.Key == "guestinfo.role" {
+			role, _ = value.Value.(string)
+		}
+	}
+	if role != "web" {
+		t.Errorf("Expected guestinfo.role to be set, got %q", role)
+	}
+	devices, err := vm.Device(client.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk := bootVirtualDisk(devices); disk == nil || disk.CapacityInKB != 20*1024*1024 {
+		t.Errorf("Expected the boot disk to grow to 20G, got %+v", disk)
+	}
+}
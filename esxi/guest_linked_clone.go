@@ -0,0 +1,277 @@
+package esxi
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// diskParentFiles returns the files a disk's delta chain is built on, nearest first.
+func diskParentFiles(disk *types.VirtualDisk) []string {
+	var files []string
+	switch backing := disk.Backing.(type) {
+	case *types.VirtualDiskFlatVer2BackingInfo:
+		for parent := backing.Parent; parent != nil; parent = parent.Parent {
+			files = append(files, parent.FileName)
+		}
+	case *types.VirtualDiskSeSparseBackingInfo:
+		for parent := backing.Parent; parent != nil; parent = parent.Parent {
+			files = append(files, parent.FileName)
+		}
+	case *types.VirtualDiskSparseVer2BackingInfo:
+		for parent := backing.Parent; parent != nil; parent = parent.Parent {
+			files = append(files, parent.FileName)
+		}
+	}
+	return files
+}
+
+// linkedClonesOf returns the names of the guests, other than vmid, with a disk
+// built on a file matched by match.
+func linkedClonesOf(vms []mo.VirtualMachine, vmid string, match func(file string) bool) []string {
+	var names []string
+	for _, vm := range vms {
+		if vm.Self.Value == vmid || vm.Config == nil {
+			continue
+		}
+		devices := object.VirtualDeviceList(vm.Config.Hardware.Device)
+	disks:
+		for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+			for _, file := range diskParentFiles(device.(*types.VirtualDisk)) {
+				if match(file) {
+					names = append(names, unescapeEntityName(vm.Name))
+					break disks
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// guestLinkedClones returns the names of the guests with a disk built on a file
+// matched by match, other than vmid itself.
+func guestLinkedClones(c *Config, vmid string, match func(file string) bool) ([]string, error) {
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vms, err := listVirtualMachines(gc.Context(), gc.Client.Client, []string{"name", "config.hardware.device"})
+	if err != nil {
+		return nil, err
+	}
+	return linkedClonesOf(vms, vmid, match), nil
+}
+
+// guestSnapshotLinkedClones returns the names of the linked clones that would be
+// broken by removing a snapshot.  Removing a snapshot consolidates the disks it
+// froze with the disks of its child snapshots, so clones of either are affected.
+func guestSnapshotLinkedClones(c *Config, vmid, snapshot_id string, remove_children bool) ([]string, error) {
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return nil, err
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(gc.Context(), vm.Reference(), []string{"snapshot"}, &vmMo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest properties: %w", err)
+	}
+	if vmMo.Snapshot == nil {
+		return nil, nil
+	}
+
+	node := findSnapshotNode(vmMo.Snapshot.RootSnapshotList, snapshot_id)
+	if node == nil {
+		return nil, nil
+	}
+	refs := []types.ManagedObjectReference{node.Snapshot}
+	for _, child := range node.ChildSnapshotList {
+		refs = append(refs, child.Snapshot)
+		if remove_children {
+			refs = append(refs, snapshotTreeRefs(child.ChildSnapshotList)...)
+		}
+	}
+
+	frozen := make(map[string]bool)
+	for _, ref := range refs {
+		var snapshotMo mo.VirtualMachineSnapshot
+		err = vm.Properties(gc.Context(), ref, []string{"config.hardware.device"}, &snapshotMo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get snapshot properties: %w", err)
+		}
+		for _, disk := range cloneDisksFromDevices(snapshotMo.Config.Hardware.Device, "") {
+			frozen[disk.Source] = true
+		}
+	}
+
+	return guestLinkedClones(c, vmid, func(file string) bool { return frozen[file] })
+}
+
+// findSnapshotNode finds a snapshot in a snapshot tree
+func findSnapshotNode(tree []types.VirtualMachineSnapshotTree, snapshot_id string) *types.VirtualMachineSnapshotTree {
+	for i := range tree {
+		if tree[i].Snapshot.Value == snapshot_id {
+			return &tree[i]
+		}
+		if node := findSnapshotNode(tree[i].ChildSnapshotList, snapshot_id); node != nil {
+			return node
+		}
+	}
+	return nil
+}
+
+// snapshotTreeRefs returns every snapshot in a snapshot tree
+func snapshotTreeRefs(tree []types.VirtualMachineSnapshotTree) []types.ManagedObjectReference {
+	var refs []types.ManagedObjectReference
+	for _, node := range tree {
+		refs = append(refs, node.Snapshot)
+		refs = append(refs, snapshotTreeRefs(node.ChildSnapshotList)...)
+	}
+	return refs
+}
+
+// guestDirLinkedClones returns the names of the linked clones built on the disks
+// in a guest's directory.
+func guestDirLinkedClones(c *Config, vmid string) ([]string, error) {
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return nil, err
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(gc.Context(), vm.Reference(), []string{"config.files.vmPathName"}, &vmMo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest properties: %w", err)
+	}
+	if vmMo.Config == nil {
+		return nil, nil
+	}
+
+	var vmx_path object.DatastorePath
+	if !vmx_path.FromString(vmMo.Config.Files.VmPathName) {
+		return nil, fmt.Errorf("invalid vmx path %q", vmMo.Config.Files.VmPathName)
+	}
+	dir_path := object.DatastorePath{Datastore: vmx_path.Datastore, Path: path.Dir(vmx_path.Path)}
+	dir := dir_path.String() + "/"
+
+	return guestLinkedClones(c, vmid, func(file string) bool { return strings.HasPrefix(file, dir) })
+}
+
+// guestDeltaDisks returns the paths of a guest's linked clone delta disks, the disks
+// built on a disk in another guest's directory.  Delta disks made by the guest's own
+// snapshots, and any disk built on one of virtual_disk_ids, are not returned.
+func guestDeltaDisks(c *Config, vmid string, virtual_disk_ids []string) ([]string, error) {
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vms, err := listVirtualMachines(gc.Context(), gc.Client.Client, []string{"config.files.vmPathName", "config.hardware.device"})
+	if err != nil {
+		return nil, err
+	}
+
+	var devices object.VirtualDeviceList
+	var other_dirs []string
+	for _, vm := range vms {
+		if vm.Config == nil {
+			continue
+		}
+		if vm.Self.Value == vmid {
+			devices = object.VirtualDeviceList(vm.Config.Hardware.Device)
+			continue
+		}
+		var vmx_path object.DatastorePath
+		if vmx_path.FromString(vm.Config.Files.VmPathName) {
+			dir_path := object.DatastorePath{Datastore: vmx_path.Datastore, Path: path.Dir(vmx_path.Path)}
+			other_dirs = append(other_dirs, dir_path.String()+"/")
+		}
+	}
+
+	return linkedCloneDeltaDisks(devices, other_dirs, virtual_disk_ids), nil
+}
+
+// linkedCloneDeltaDisks returns the paths of the disks whose base disk is in one of
+// other_dirs.  Disks with a file in virtual_disk_ids are never returned.
+func linkedCloneDeltaDisks(devices object.VirtualDeviceList, other_dirs []string, virtual_disk_ids []string) []string {
+	virtual_disks := make(map[string]bool)
+	for _, id := range virtual_disk_ids {
+		virtual_disks[id] = true
+	}
+
+	var deltas []string
+disks:
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		disk := device.(*types.VirtualDisk)
+		parents := diskParentFiles(disk)
+		if len(parents) == 0 {
+			continue
+		}
+		backing, ok := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo)
+		if !ok {
+			continue
+		}
+		file := backing.GetVirtualDeviceFileBackingInfo().FileName
+
+		for _, chain_file := range append([]string{file}, parents...) {
+			if virtual_disks[vmfsPath(chain_file)] {
+				continue disks
+			}
+		}
+
+		base := parents[len(parents)-1]
+		in_other_dir := false
+		for _, dir := range other_dirs {
+			if strings.HasPrefix(base, dir) {
+				in_other_dir = true
+				break
+			}
+		}
+		if in_other_dir {
+			if disk_path := vmfsPath(file); disk_path != "" {
+				deltas = append(deltas, disk_path)
+			}
+		}
+	}
+	return deltas
+}
+
+// vmfsPath converts a datastore path, such as [ds1] vm/vm.vmdk, to a path on the
+// esxi host.  It returns "" if file is not a datastore path.
+func vmfsPath(file string) string {
+	var disk_path object.DatastorePath
+	if !disk_path.FromString(file) {
+		return ""
+	}
+	return "/vmfs/volumes/" + disk_path.Datastore + "/" + disk_path.Path
+}
+
+// guestDetachDisks removes every disk, including the boot disk, from a guest's vmx.
+func guestDetachDisks(c *Config, vmid string) error {
+	vmx_contents, err := readVmx_contents(c, vmid)
+	if err != nil {
+		return fmt.Errorf("Failed to get vmx contents: %s\n", err)
+	}
+
+	doc := vmx.Parse(vmx_contents)
+	vmxRemoveDisks(doc, vmx.Slot{})
+	return writeVmxTransaction(c, vmid, doc.String(), 0)
+}
@@ -0,0 +1,100 @@
+package esxi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// testCloneDisk returns a disk whose backing is a delta chain, child first
+func testCloneDisk(key, controllerKey, unit int32, chain ...string) *types.VirtualDisk {
+	var backing *types.VirtualDiskFlatVer2BackingInfo
+	for i := len(chain) - 1; i >= 0; i-- {
+		backing = &types.VirtualDiskFlatVer2BackingInfo{
+			VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: chain[i]},
+			Parent:                       backing,
+		}
+	}
+	return &types.VirtualDisk{
+		VirtualDevice: types.VirtualDevice{
+			Key:           key,
+			ControllerKey: controllerKey,
+			UnitNumber:    &unit,
+			Backing:       backing,
+		},
+	}
+}
+
+// TestLinkedClonesOf verifies guests are found by the files their delta disks are built on
+func TestLinkedClonesOf(t *testing.T) {
+	scsi := &types.ParaVirtualSCSIController{}
+	scsi.Key = 1000
+
+	guest := func(vmid, name string, disks ...types.BaseVirtualDevice) mo.VirtualMachine {
+		vm := mo.VirtualMachine{Config: &types.VirtualMachineConfigInfo{}}
+		vm.Self = types.ManagedObjectReference{Type: "VirtualMachine", Value: vmid}
+		vm.Name = name
+		vm.Config.Hardware.Device = append([]types.BaseVirtualDevice{scsi}, disks...)
+		return vm
+	}
+	vms := []mo.VirtualMachine{
+		guest("1", "base", testCloneDisk(2000, 1000, 0, "[ds1] base/base-000002.vmdk", "[ds1] base/base-000001.vmdk", "[ds1] base/base.vmdk")),
+		guest("2", "runner-b", testCloneDisk(2000, 1000, 0, "[ds1] runner-b/runner-b.vmdk", "[ds1] base/base-000001.vmdk", "[ds1] base/base.vmdk")),
+		guest("3", "runner-a", testCloneDisk(2000, 1000, 0, "[ds1] runner-a/runner-a.vmdk", "[ds1] base/base.vmdk")),
+		guest("4", "other", testCloneDisk(2000, 1000, 0, "[ds1] other/other.vmdk")),
+	}
+
+	frozen := map[string]bool{"[ds1] base/base.vmdk": true}
+	clones := linkedClonesOf(vms, "1", func(file string) bool { return frozen[file] })
+	if !reflect.DeepEqual(clones, []string{"runner-a", "runner-b"}) {
+		t.Errorf("linkedClonesOf = %q", clones)
+	}
+
+	frozen = map[string]bool{"[ds1] base/base-000002.vmdk": true}
+	if clones := linkedClonesOf(vms, "1", func(file string) bool { return frozen[file] }); len(clones) != 0 {
+		t.Errorf("the running delta has no linked clones, got %q", clones)
+	}
+
+	clones = linkedClonesOf(vms, "1", func(file string) bool { return strings.HasPrefix(file, "[ds1] base/") })
+	if len(clones) != 2 {
+		t.Errorf("expected both clones of the base guest, got %q", clones)
+	}
+}
+
+// TestLinkedCloneDeltaDisks verifies only disks built on another guest's disks are
+// linked clone deltas
+func TestLinkedCloneDeltaDisks(t *testing.T) {
+	scsi := &types.ParaVirtualSCSIController{}
+	scsi.Key = 1000
+
+	devices := object.VirtualDeviceList{
+		scsi,
+		//  linked clone boot disk, with a snapshot of its own
+		testCloneDisk(2000, 1000, 0, "[ds1] runner/runner-000001.vmdk", "[ds1] runner/runner.vmdk", "[ds1] base/base.vmdk"),
+		//  a disk of the guest's own, with a snapshot
+		testCloneDisk(2001, 1000, 1, "[ds1] runner/runner_1-000001.vmdk", "[ds1] runner/runner_1.vmdk"),
+		//  an attached esxi_virtual_disk, with a snapshot
+		testCloneDisk(2002, 1000, 2, "[ds1] runner/data-000001.vmdk", "[ds1] disks/data.vmdk"),
+		//  an attached esxi_virtual_disk stored in another guest's directory
+		testCloneDisk(2003, 1000, 3, "[ds1] runner/shared-000001.vmdk", "[ds1] base/shared.vmdk"),
+		//  a flat disk
+		testCloneDisk(2004, 1000, 4, "[ds1] runner/runner_2.vmdk"),
+	}
+	other_dirs := []string{"[ds1] base/", "[ds1] other/"}
+	virtual_disk_ids := []string{"/vmfs/volumes/ds1/disks/data.vmdk", "/vmfs/volumes/ds1/base/shared.vmdk"}
+
+	deltas := linkedCloneDeltaDisks(devices, other_dirs, virtual_disk_ids)
+	expected := []string{"/vmfs/volumes/ds1/runner/runner-000001.vmdk"}
+	if !reflect.DeepEqual(deltas, expected) {
+		t.Errorf("linkedCloneDeltaDisks = %q, expected %q", deltas, expected)
+	}
+
+	//  A guest that is not a linked clone has no deltas, even with snapshots.
+	if deltas := linkedCloneDeltaDisks(devices[:3], []string{"[ds1] other/"}, nil); len(deltas) != 0 {
+		t.Errorf("expected no deltas, got %q", deltas)
+	}
+}
@@ -0,0 +1,438 @@
+package esxi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guestNIC is a guest network interface.
+type guestNIC struct {
+	VirtualNetwork string // port group name, or distributed port group key
+	MacAddress     string // static MAC address, empty if the MAC is generated
+	NicType        string
+	Connected      bool
+	StartConnected bool
+
+	ActiveMacAddress string   // read only, the static or generated MAC address in use
+	IPAddresses      []string // read only, the IPs reported by VMware tools
+}
+
+// Get network_interfaces from the resource config.
+func guestNICsFromResourceData(d resourceChanges) ([]guestNIC, error) {
+	count := d.Get("network_interfaces.#").(int)
+	nics := make([]guestNIC, 0, count)
+	for i := 0; i < count; i++ {
+		prefix := fmt.Sprintf("network_interfaces.%d.", i)
+
+		nic := guestNIC{
+			VirtualNetwork: d.Get(prefix + "virtual_network").(string),
+			MacAddress:     d.Get(prefix + "mac_address").(string),
+			NicType:        d.Get(prefix + "nic_type").(string),
+			Connected:      d.Get(prefix + "connected").(bool),
+			StartConnected: d.Get(prefix + "start_connected").(bool),
+		}
+		if nic.VirtualNetwork == "" {
+			return nil, fmt.Errorf("Error: network_interfaces.%d.virtual_network is required", i)
+		}
+		if validateNICType(nic.NicType) == false {
+			return nil, fmt.Errorf("Error: invalid nic_type. %s\nMust be vlance flexible e1000 e1000e vmxnet vmxnet2 or vmxnet3", nic.NicType)
+		}
+		nics = append(nics, nic)
+	}
+	return nics, nil
+}
+
+// Convert network interfaces to the network_interfaces attribute.
+func guestNICsToResourceData(nics []guestNIC) []map[string]interface{} {
+	if len(nics) == 0 {
+		return nil
+	}
+	out := make([]map[string]interface{}, 0, len(nics))
+	for _, nic := range nics {
+		out = append(out, map[string]interface{}{
+			"virtual_network": nic.VirtualNetwork,
+			"mac_address":     nic.MacAddress,
+			"nic_type":        nic.NicType,
+			"connected":       nic.Connected,
+			"start_connected": nic.StartConnected,
+			"ip_addresses":    nic.IPAddresses,
+		})
+	}
+	return out
+}
+
+// guestDVSPortgroups returns the names of the distributed port groups the host is
+// connected to, by port group key.  If they can't be read, none are returned.
+func guestDVSPortgroups(c *Config) map[string]string {
+	portgroups := make(map[string]string)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		log.Printf("[guestDVSPortgroups] Failed to get govmomi client: %s\n", err)
+		return portgroups
+	}
+	list, err := listDVSPortgroups(gc.Context(), gc.Client.Client, []string{"name", "key"})
+	if err != nil {
+		log.Printf("[guestDVSPortgroups] %s\n", err)
+		return portgroups
+	}
+	for _, portgroup := range list {
+		portgroups[portgroup.Key] = unescapeEntityName(portgroup.Name)
+	}
+	return portgroups
+}
+
+// guestNICsFromVmx reads the network interfaces from a vmx file, ordered by ethernet
+// number.  The runtime connected state isn't in the vmx, so Connected is set to
+// StartConnected.  An interface on a distributed port group in dvs_portgroups
+// (names by key) is given the port group's name.
+func guestNICsFromVmx(doc *vmx.Document, dvs_portgroups map[string]string) []guestNIC {
+	ethernets := doc.Ethernets()
+	sort.Slice(ethernets, func(i, j int) bool { return ethernets[i].Index < ethernets[j].Index })
+
+	nics := make([]guestNIC, 0, len(ethernets))
+	for _, eth := range ethernets {
+		if !eth.Present {
+			continue
+		}
+		nic := guestNIC{
+			VirtualNetwork: eth.NetworkName,
+			NicType:        eth.VirtualDev,
+			Connected:      eth.StartConnected,
+			StartConnected: eth.StartConnected,
+		}
+		if nic.VirtualNetwork == "" {
+			nic.VirtualNetwork = eth.DVSPortgroupID
+			if name, ok := dvs_portgroups[eth.DVSPortgroupID]; ok {
+				nic.VirtualNetwork = name
+			}
+		}
+		//  Generated MACs aren't saved.  They are dynamic and would be turned into
+		//  static MACs on the next update.
+		if strings.EqualFold(eth.AddressType, "static") {
+			nic.MacAddress = eth.Address
+			nic.ActiveMacAddress = eth.Address
+		} else {
+			nic.ActiveMacAddress = eth.GeneratedAddress
+		}
+		nics = append(nics, nic)
+	}
+	return nics
+}
+
+// guestNICsToVmx updates the network interfaces in a vmx file.  Interfaces are matched
+// to existing ethernet devices in order, extra devices are removed and new ones are
+// added using the lowest free ethernet numbers.  On create all existing devices are
+// replaced.  A distributed port group in dvs_portgroups (names by key) may be given
+// by key or name, and networkName is always set to its name.
+func guestNICsToVmx(doc *vmx.Document, nics []guestNIC, iscreate bool, dvs_portgroups map[string]string) {
+	var existing []int
+	for _, eth := range doc.Ethernets() {
+		if iscreate || !eth.Present {
+			doc.DeleteDevice(vmx.EthernetName(eth.Index))
+			continue
+		}
+		existing = append(existing, eth.Index)
+	}
+	sort.Ints(existing)
+
+	//  Remove devices that are no longer configured.
+	for len(existing) > len(nics) {
+		removed := existing[len(existing)-1]
+		log.Printf("[guestNICsToVmx] Delete %s\n", vmx.EthernetName(removed))
+		doc.DeleteDevice(vmx.EthernetName(removed))
+		existing = existing[:len(existing)-1]
+	}
+
+	//  Define default nic type.
+	defaultNetworkType := "e1000"
+	if len(nics) > 0 && nics[0].NicType != "" {
+		defaultNetworkType = nics[0].NicType
+	}
+
+	used := make(map[int]bool)
+	for _, index := range existing {
+		used[index] = true
+	}
+	next := 0
+
+	for i, nic := range nics {
+		networkName := nic.VirtualNetwork
+		if name, ok := dvs_portgroups[nic.VirtualNetwork]; ok {
+			networkName = name
+		}
+
+		var ethernet string
+		if i < len(existing) {
+			ethernet = vmx.EthernetName(existing[i])
+			log.Printf("[guestNICsToVmx] Modify %s: %s\n", ethernet, networkName)
+
+			//  Keep a distributed port group backing if it hasn't changed.
+			portgroupId := doc.Value(ethernet + ".dvs.portgroupId")
+			if portgroupId == "" || (portgroupId != nic.VirtualNetwork && dvs_portgroups[portgroupId] != networkName) {
+				deleteVmxKeysWithPrefix(doc, ethernet+".dvs.")
+				doc.Set(ethernet+".networkName", networkName)
+			}
+			if nic.NicType != "" {
+				doc.Set(ethernet+".virtualDev", nic.NicType)
+			}
+		} else {
+			for used[next] {
+				next++
+			}
+			used[next] = true
+			ethernet = vmx.EthernetName(next)
+			log.Printf("[guestNICsToVmx] Create %s: %s\n", ethernet, networkName)
+
+			networkType := nic.NicType
+			if networkType == "" {
+				networkType = defaultNetworkType
+			}
+			doc.Set(ethernet+".networkName", networkName)
+			doc.Set(ethernet+".virtualDev", networkType)
+			doc.Set(ethernet+".present", "TRUE")
+		}
+
+		//  MAC address, static or generated.
+		isStatic := strings.EqualFold(doc.Value(ethernet+".addressType"), "static")
+		if nic.MacAddress != "" {
+			if !isStatic || !strings.EqualFold(doc.Value(ethernet+".address"), nic.MacAddress) {
+				log.Printf("[guestNICsToVmx] %s static MAC: %s\n", ethernet, nic.MacAddress)
+				doc.Delete(ethernet + ".generatedAddress")
+				doc.Delete(ethernet + ".generatedAddressOffset")
+				doc.Set(ethernet+".addressType", "static")
+				doc.Set(ethernet+".address", nic.MacAddress)
+			}
+		} else if isStatic || !doc.Has(ethernet+".addressType") {
+			//  A new MAC is generated when the guest is reloaded.
+			log.Printf("[guestNICsToVmx] %s generated MAC\n", ethernet)
+			doc.Delete(ethernet + ".address")
+			doc.Set(ethernet+".addressType", "generated")
+		}
+
+		if doc.Bool(ethernet+".startConnected", true) != nic.StartConnected {
+			doc.SetBool(ethernet+".startConnected", nic.StartConnected)
+		}
+	}
+}
+
+func deleteVmxKeysWithPrefix(doc *vmx.Document, prefix string) {
+	for _, key := range doc.Keys() {
+		if strings.HasPrefix(strings.ToLower(key), strings.ToLower(prefix)) {
+			doc.Delete(key)
+		}
+	}
+}
+
+// guestGetNICConnected returns the connected state of a running guest's network
+// interfaces, keyed by MAC address.
+func guestGetNICConnected(c *Config, vmid string) (map[string]bool, error) {
+	log.Printf("[guestGetNICConnected]\n")
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := vm.Device(gc.Context())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get guest devices: %s\n", err)
+	}
+
+	connected := make(map[string]bool)
+	for _, device := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		card := device.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+		if card.Connectable != nil {
+			connected[strings.ToLower(card.MacAddress)] = card.Connectable.Connected
+		}
+	}
+	return connected, nil
+}
+
+// guestSetNICConnected connects or disconnects a running guest's network interfaces.
+// nics must be in ethernet number order, as returned by guestNICsFromVmx.
+func guestSetNICConnected(c *Config, vmid string, nics []guestNIC) error {
+	log.Printf("[guestSetNICConnected]\n")
+
+	vmx_contents, err := readVmx_contents(c, vmid)
+	if err != nil {
+		return fmt.Errorf("Failed to get vmx contents: %s\n", err)
+	}
+	//  The MAC of each configured interface, so it can be found in the device list.
+	wanted := make(map[string]bool)
+	for i, nic := range guestNICsFromVmx(vmx.Parse(vmx_contents), nil) {
+		if i < len(nics) {
+			wanted[strings.ToLower(nic.ActiveMacAddress)] = nics[i].Connected
+		}
+	}
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+	devices, err := vm.Device(gc.Context())
+	if err != nil {
+		return fmt.Errorf("Failed to get guest devices: %s\n", err)
+	}
+
+	var errs []string
+	for _, device := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		card := device.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+		connected, ok := wanted[strings.ToLower(card.MacAddress)]
+		if !ok || card.Connectable == nil || card.Connectable.Connected == connected {
+			continue
+		}
+
+		log.Printf("[guestSetNICConnected] %s connected: %t\n", card.MacAddress, connected)
+		if connected {
+			err = devices.Connect(device)
+		} else {
+			err = devices.Disconnect(device)
+		}
+		if err == nil {
+			err = vm.EditDevice(gc.Context(), device)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", card.MacAddress, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("Failed to set network interface connected state: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// guestIPAddresses are the IPs of a running guest reported by VMware tools.
+// Loopback and link-local IPs are left out.
+type guestIPAddresses struct {
+	ByMAC map[string][]string // the IPs of each network interface, by lowercase MAC address
+	All   []string            // every IP, the guest's network interfaces first
+}
+
+// usableGuestIP tells if a guest IP can be reached from outside the guest
+func usableGuestIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && !parsed.IsLoopback() && !parsed.IsLinkLocalUnicast() && !parsed.IsUnspecified()
+}
+
+// guestIPAddressesFromGuestInfo reads the IPs of guest.net.  Interfaces that
+// aren't network interfaces of the guest, such as docker bridges, have no
+// device and are listed last.
+func guestIPAddressesFromGuestInfo(guest *types.GuestInfo) guestIPAddresses {
+	ips := guestIPAddresses{ByMAC: make(map[string][]string)}
+	if guest == nil {
+		return ips
+	}
+
+	nics := append([]types.GuestNicInfo(nil), guest.Net...)
+	sort.SliceStable(nics, func(i, j int) bool {
+		if (nics[i].DeviceConfigId < 0) != (nics[j].DeviceConfigId < 0) {
+			return nics[j].DeviceConfigId < 0
+		}
+		return nics[i].DeviceConfigId < nics[j].DeviceConfigId
+	})
+
+	seen := make(map[string]bool)
+	for _, nic := range nics {
+		mac := strings.ToLower(nic.MacAddress)
+		for _, ip := range nic.IpAddress {
+			if !usableGuestIP(ip) {
+				continue
+			}
+			if nic.DeviceConfigId >= 0 && mac != "" {
+				ips.ByMAC[mac] = append(ips.ByMAC[mac], ip)
+			}
+			if !seen[ip] {
+				seen[ip] = true
+				ips.All = append(ips.All, ip)
+			}
+		}
+	}
+	if usableGuestIP(guest.IpAddress) && !seen[guest.IpAddress] {
+		ips.All = append(ips.All, guest.IpAddress)
+	}
+	return ips
+}
+
+// preferred returns the IP for ip_address: the first IP in the first of
+// preferred_ip_cidrs that has one, else the first IPv4, else the first IP.
+func (ips guestIPAddresses) preferred(preferred_ip_cidrs []string) string {
+	for _, cidr := range preferred_ip_cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips.All {
+			if ipnet.Contains(net.ParseIP(ip)) {
+				return ip
+			}
+		}
+	}
+	for _, ip := range ips.All {
+		if net.ParseIP(ip).To4() != nil {
+			return ip
+		}
+	}
+	if len(ips.All) > 0 {
+		return ips.All[0]
+	}
+	return ""
+}
+
+// Get preferred_ip_cidrs from the resource config.
+func guestPreferredIPCidrs(d *schema.ResourceData) []string {
+	var cidrs []string
+	for _, cidr := range d.Get("preferred_ip_cidrs").([]interface{}) {
+		if s, ok := cidr.(string); ok && s != "" {
+			cidrs = append(cidrs, s)
+		}
+	}
+	return cidrs
+}
+
+// guestGetIPAddresses returns the IPs of a running guest reported by VMware tools.
+// If there are none yet, it waits for one until the guest has been up for
+// guest_startup_timeout seconds.
+func guestGetIPAddresses(c *Config, vmid string, guest_startup_timeout int) (guestIPAddresses, error) {
+	log.Printf("[guestGetIPAddresses]\n")
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return guestIPAddresses{}, fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return guestIPAddresses{}, err
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(gc.Context(), vm.Reference(), []string{"guest.ipAddress", "guest.net", "summary.quickStats.uptimeSeconds"}, &vmMo)
+	if err != nil {
+		return guestIPAddresses{}, fmt.Errorf("Failed to get guest IP addresses: %s\n", err)
+	}
+	ips := guestIPAddressesFromGuestInfo(vmMo.Guest)
+
+	uptime := int(vmMo.Summary.QuickStats.UptimeSeconds)
+	if len(ips.All) > 0 || uptime >= guest_startup_timeout {
+		return ips, nil
+	}
+	log.Printf("[guestGetIPAddresses] Waiting up to %ds for an IP address\n", guest_startup_timeout-uptime)
+	return waitForGuestIPAddresses(gc.Context(), vm, time.Duration(guest_startup_timeout-uptime)*time.Second)
+}
@@ -0,0 +1,235 @@
+package esxi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const testNICVmx = `ethernet0.virtualDev = "vmxnet3"
+ethernet0.networkName = "VM Network"
+ethernet0.addressType = "generated"
+ethernet0.generatedAddress = "00:0c:29:e1:e3:a7"
+ethernet0.generatedAddressOffset = "0"
+ethernet0.present = "TRUE"
+ethernet2.virtualDev = "e1000"
+ethernet2.addressType = "static"
+ethernet2.address = "00:50:56:01:02:03"
+ethernet2.dvs.switchId = "50 2d 1a"
+ethernet2.dvs.portgroupId = "dvportgroup-21"
+ethernet2.present = "TRUE"
+ethernet10.virtualDev = "vmxnet3"
+ethernet10.networkName = "Storage"
+ethernet10.addressType = "generated"
+ethernet10.generatedAddress = "00:0c:29:e1:e3:b1"
+ethernet10.startConnected = "FALSE"
+ethernet10.present = "TRUE"
+`
+
+// TestGuestNICsFromVmx verifies interfaces are read in ethernet order with distributed port groups
+func TestGuestNICsFromVmx(t *testing.T) {
+	expected := []guestNIC{
+		{VirtualNetwork: "VM Network", NicType: "vmxnet3", Connected: true, StartConnected: true, ActiveMacAddress: "00:0c:29:e1:e3:a7"},
+		{VirtualNetwork: "dvportgroup-21", MacAddress: "00:50:56:01:02:03", NicType: "e1000", Connected: true, StartConnected: true, ActiveMacAddress: "00:50:56:01:02:03"},
+		{VirtualNetwork: "Storage", NicType: "vmxnet3", ActiveMacAddress: "00:0c:29:e1:e3:b1"},
+	}
+	if nics := guestNICsFromVmx(vmx.Parse(testNICVmx), nil); !reflect.DeepEqual(nics, expected) {
+		t.Errorf("guestNICsFromVmx =\n%+v\nexpected\n%+v", nics, expected)
+	}
+}
+
+// TestGuestNICsToVmx verifies MAC address switching, device numbering and removal
+func TestGuestNICsToVmx(t *testing.T) {
+	doc := vmx.Parse(testNICVmx)
+	guestNICsToVmx(doc, []guestNIC{
+		{VirtualNetwork: "VM Network", MacAddress: "00:50:56:0a:0b:0c", StartConnected: true},
+		{VirtualNetwork: "dvportgroup-21", StartConnected: false},
+		{VirtualNetwork: "Storage", NicType: "vmxnet3", StartConnected: true},
+		{VirtualNetwork: "Backup", StartConnected: true},
+	}, false, nil)
+
+	expected := map[string]string{
+		"ethernet0.addressType":     "static",
+		"ethernet0.address":         "00:50:56:0a:0b:0c",
+		"ethernet2.addressType":     "generated",
+		"ethernet2.dvs.portgroupId": "dvportgroup-21",
+		"ethernet2.startConnected":  "FALSE",
+		"ethernet10.startConnected": "TRUE",
+		"ethernet1.networkName":     "Backup",
+		"ethernet1.virtualDev":      "e1000",
+		"ethernet1.addressType":     "generated",
+		"ethernet1.present":         "TRUE",
+	}
+	for key, value := range expected {
+		if got := doc.Value(key); got != value {
+			t.Errorf("%s = %q, expected %q", key, got, value)
+		}
+	}
+	for _, key := range []string{"ethernet0.generatedAddress", "ethernet0.generatedAddressOffset", "ethernet2.address", "ethernet2.networkName", "ethernet1.startConnected"} {
+		if doc.Has(key) {
+			t.Errorf("%s should not be set", key)
+		}
+	}
+
+	//  A new port group replaces the distributed port group backing.
+	doc = vmx.Parse(testNICVmx)
+	guestNICsToVmx(doc, []guestNIC{{VirtualNetwork: "VM Network", StartConnected: true}, {VirtualNetwork: "Lab", StartConnected: true}}, false, nil)
+	if doc.Has("ethernet2.dvs.switchId") || doc.Value("ethernet2.networkName") != "Lab" {
+		t.Errorf("ethernet2 should be moved to Lab:\n%s", doc)
+	}
+	if doc.HasDevice("ethernet10") || !doc.HasDevice("ethernet0") {
+		t.Errorf("only the last interfaces should be removed:\n%s", doc)
+	}
+
+	//  Removing a static MAC switches back to a generated one.
+	guestNICsToVmx(doc, []guestNIC{{VirtualNetwork: "VM Network", MacAddress: "00:50:56:0a:0b:0c", StartConnected: true}}, false, nil)
+	guestNICsToVmx(doc, []guestNIC{{VirtualNetwork: "VM Network", StartConnected: true}}, false, nil)
+	if doc.Value("ethernet0.addressType") != "generated" || doc.Has("ethernet0.address") || doc.HasDevice("ethernet2") {
+		t.Errorf("ethernet0 should switch back to a generated MAC:\n%s", doc)
+	}
+
+	//  Create replaces all existing interfaces.
+	doc = vmx.Parse(testNICVmx)
+	guestNICsToVmx(doc, []guestNIC{{VirtualNetwork: "VM Network", NicType: "e1000e", StartConnected: true}}, true, nil)
+	if nics := guestNICsFromVmx(doc, nil); len(nics) != 1 || nics[0].NicType != "e1000e" || doc.Has("ethernet0.generatedAddress") {
+		t.Errorf("unexpected interfaces after create: %+v\n%s", nics, doc)
+	}
+}
+
+// TestGuestNICsDVSPortgroups verifies distributed port groups are named, not keyed
+func TestGuestNICsDVSPortgroups(t *testing.T) {
+	portgroups := map[string]string{"dvportgroup-21": "DPG Lab", "dvportgroup-22": "DPG Prod"}
+
+	nics := guestNICsFromVmx(vmx.Parse(testNICVmx), portgroups)
+	if nics[1].VirtualNetwork != "DPG Lab" {
+		t.Errorf("expected the port group name, got %q", nics[1].VirtualNetwork)
+	}
+
+	//  The backing is kept whether the port group is given by name or key.
+	for _, network := range []string{"DPG Lab", "dvportgroup-21"} {
+		doc := vmx.Parse(testNICVmx)
+		guestNICsToVmx(doc, []guestNIC{{VirtualNetwork: "VM Network", StartConnected: true}, {VirtualNetwork: network, StartConnected: true}}, false, portgroups)
+		if doc.Value("ethernet2.dvs.portgroupId") != "dvportgroup-21" || doc.Has("ethernet2.networkName") {
+			t.Errorf("%s: expected the distributed port group backing to be kept:\n%s", network, doc)
+		}
+	}
+
+	//  New interfaces get the port group name.
+	doc := vmx.Parse(testNICVmx)
+	guestNICsToVmx(doc, []guestNIC{{VirtualNetwork: "dvportgroup-22", StartConnected: true}, {VirtualNetwork: "DPG Lab", StartConnected: true}}, true, portgroups)
+	if doc.Value("ethernet0.networkName") != "DPG Prod" || doc.Value("ethernet1.networkName") != "DPG Lab" {
+		t.Errorf("expected port group names:\n%s", doc)
+	}
+
+	//  Moving an interface to another distributed port group sets its name.
+	doc = vmx.Parse(testNICVmx)
+	guestNICsToVmx(doc, []guestNIC{{VirtualNetwork: "VM Network", StartConnected: true}, {VirtualNetwork: "dvportgroup-22", StartConnected: true}}, false, portgroups)
+	if doc.Has("ethernet2.dvs.portgroupId") || doc.Value("ethernet2.networkName") != "DPG Prod" {
+		t.Errorf("expected ethernet2 on DPG Prod:\n%s", doc)
+	}
+}
+
+// TestGuestIPAddresses verifies guest IPs are mapped by MAC and the preferred one is chosen
+func TestGuestIPAddresses(t *testing.T) {
+	guest := &types.GuestInfo{
+		IpAddress: "172.17.0.1",
+		Net: []types.GuestNicInfo{
+			{MacAddress: "02:42:ac:11:00:01", DeviceConfigId: -1, IpAddress: []string{"172.17.0.1"}},
+			{MacAddress: "00:50:56:00:00:02", DeviceConfigId: 4001, IpAddress: []string{"fe80::2", "2001:db8::2"}},
+			{MacAddress: "00:50:56:00:00:01", DeviceConfigId: 4000, IpAddress: []string{"fe80::1", "10.0.0.5", "127.0.0.1"}},
+		},
+	}
+
+	ips := guestIPAddressesFromGuestInfo(guest)
+	if expected := []string{"10.0.0.5", "2001:db8::2", "172.17.0.1"}; !reflect.DeepEqual(ips.All, expected) {
+		t.Errorf("Expected IPs %q, got %q", expected, ips.All)
+	}
+	expected := map[string][]string{"00:50:56:00:00:01": {"10.0.0.5"}, "00:50:56:00:00:02": {"2001:db8::2"}}
+	if !reflect.DeepEqual(ips.ByMAC, expected) {
+		t.Errorf("Expected IPs by MAC %q, got %q", expected, ips.ByMAC)
+	}
+
+	for _, test := range []struct {
+		cidrs    []string
+		expected string
+	}{
+		{nil, "10.0.0.5"},
+		{[]string{"192.168.0.0/16"}, "10.0.0.5"},
+		{[]string{"2001:db8::/32", "10.0.0.0/8"}, "2001:db8::2"},
+		{[]string{"172.16.0.0/12"}, "172.17.0.1"},
+	} {
+		if ip := ips.preferred(test.cidrs); ip != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.cidrs, test.expected, ip)
+		}
+	}
+
+	//  IPv6 only guests
+	ips = guestIPAddressesFromGuestInfo(&types.GuestInfo{Net: []types.GuestNicInfo{{MacAddress: "00:50:56:00:00:01", DeviceConfigId: 4000, IpAddress: []string{"2001:db8::1"}}}})
+	if ip := ips.preferred(nil); ip != "2001:db8::1" {
+		t.Errorf("Expected the IPv6 address, got %q", ip)
+	}
+}
+
+// TestWaitForGuestIPAddressesGovmomi verifies waiting for VMware tools to report an IP with the vcsim simulator
+func TestWaitForGuestIPAddressesGovmomi(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("Failed to find a guest: %v", err)
+	}
+	vm := vms[0]
+
+	if _, err = waitForGuestIPAddresses(client.Context(), vm, 200*time.Millisecond); err == nil {
+		t.Error("Expected a timeout without an IP address")
+	}
+
+	//  vcsim sets guest properties from SET. keys.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		task, err := vm.Reconfigure(client.Context(), types.VirtualMachineConfigSpec{
+			ExtraConfig: []types.BaseOptionValue{&types.OptionValue{Key: "SET.guest.ipAddress", Value: "10.1.2.3"}},
+		})
+		if err == nil {
+			task.Wait(client.Context())
+		}
+	}()
+
+	ips, err := waitForGuestIPAddresses(client.Context(), vm, 5*time.Second)
+	if err != nil || ips.preferred(nil) != "10.1.2.3" {
+		t.Errorf("Expected IP 10.1.2.3, got %q, %v", ips.All, err)
+	}
+
+	//  Without startup time left, the IPs are read without waiting.
+	ips, err = guestGetIPAddresses(config, vm.Reference().Value, 0)
+	if err != nil || ips.preferred(nil) != "10.1.2.3" {
+		t.Errorf("Expected IP 10.1.2.3, got %q, %v", ips.All, err)
+	}
+}
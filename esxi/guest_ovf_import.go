@@ -0,0 +1,252 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vmware/govmomi/nfc"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/ovf/importer"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ovfUploadConcurrency is the number of disks uploaded through the NFC lease at once
+const ovfUploadConcurrency = 4
+
+// ovfArchive returns the archive to read an ovf or ova source from, and the path
+// of the ovf descriptor in it.  An ova is read as a stream, from a local file or
+// a URL, without unpacking it.
+func ovfArchive(gc *GovmomiClient, src_path string) (importer.Archive, string, error) {
+	opener := importer.Opener{Client: gc.Client.Client}
+
+	//  A URL may have a query string after the file name.
+	name := src_path
+	if importer.IsRemotePath(src_path) {
+		name = strings.SplitN(src_path, "?", 2)[0]
+	}
+
+	switch strings.ToLower(path.Ext(name)) {
+	case ".ova":
+		return &importer.TapeArchive{Path: src_path, Opener: opener}, "*.ovf", nil
+	case ".ovf":
+		return &importer.FileArchive{Path: src_path, Opener: opener}, src_path, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported source %s, expected an .ovf or .ova file", src_path)
+	}
+}
+
+// ovfDiskProvisioning maps boot_disk_type to the ovf import's disk provisioning
+func ovfDiskProvisioning(boot_disk_type string) string {
+	switch boot_disk_type {
+	case "zeroedthick":
+		return string(types.OvfCreateImportSpecParamsDiskProvisioningTypeThick)
+	case "eagerzeroedthick":
+		return string(types.OvfCreateImportSpecParamsDiskProvisioningTypeEagerZeroedThick)
+	default:
+		return string(types.OvfCreateImportSpecParamsDiskProvisioningTypeThin)
+	}
+}
+
+// ovfNetworkMapping maps every network of the ovf to virtual_network, as
+// ovftool's --network did.  The guest's interfaces are set from
+// network_interfaces after the import.
+func ovfNetworkMapping(envelope *ovf.Envelope, virtual_network string) []importer.Network {
+	if envelope.Network == nil || virtual_network == "" {
+		return nil
+	}
+
+	var networks []importer.Network
+	for _, network := range envelope.Network.Networks {
+		networks = append(networks, importer.Network{Name: network.Name, Network: virtual_network})
+	}
+	return networks
+}
+
+// ovfPropertyMapping returns ovf_properties in key order
+func ovfPropertyMapping(ovf_properties map[string]string) []importer.Property {
+	var properties []importer.Property
+	for key, value := range ovf_properties {
+		properties = append(properties, importer.Property{KeyValue: importer.KeyValue{Key: key, Value: value}})
+	}
+	sort.Slice(properties, func(i, j int) bool { return properties[i].Key < properties[j].Key })
+	return properties
+}
+
+// ovfEnvironment returns the ovf environment document for guestinfo.ovfEnv.
+// A standalone esxi host doesn't deliver the ovf environment to its guests, so
+// it's injected in the vmx, as ovftool's --X:injectOvfEnv did.
+func ovfEnvironment(about types.AboutInfo, vmid string, properties []importer.Property) string {
+	var env_properties []ovf.EnvProperty
+	for _, property := range properties {
+		env_properties = append(env_properties, ovf.EnvProperty{Key: property.Key, Value: property.Value})
+	}
+
+	env := ovf.Env{
+		EsxID: vmid,
+		Platform: &ovf.PlatformSection{
+			Kind:    about.Name,
+			Version: about.Version,
+			Vendor:  about.Vendor,
+			Locale:  "US",
+		},
+		Property: &ovf.PropertySection{
+			Properties: env_properties,
+		},
+	}
+	return env.MarshalManual()
+}
+
+// ovfUpload uploads the files of an import through its NFC lease, a few disks at
+// a time.  The first failed upload aborts the lease.
+func ovfUpload(gc *GovmomiClient, imp *importer.Importer, lease *nfc.Lease, info *nfc.LeaseInfo) error {
+	updater := lease.StartUpdater(gc.Context(), info)
+	defer updater.Done()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var upload_err error
+	limit := make(chan struct{}, ovfUploadConcurrency)
+
+	for _, item := range info.Items {
+		wg.Add(1)
+		go func(item nfc.FileItem) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			mu.Lock()
+			failed := upload_err != nil
+			mu.Unlock()
+			if failed {
+				return
+			}
+
+			log.Printf("[ovfUpload] Uploading %s\n", item.Path)
+			if err := imp.Upload(gc.Context(), lease, item); err != nil {
+				mu.Lock()
+				if upload_err == nil {
+					upload_err = fmt.Errorf("failed to upload %s: %w", item.Path, err)
+				}
+				mu.Unlock()
+			}
+		}(item)
+	}
+	wg.Wait()
+
+	if upload_err != nil {
+		_ = lease.Abort(gc.Context(), &types.LocalizedMethodFault{
+			Fault:            &types.FileFault{},
+			LocalizedMessage: upload_err.Error(),
+		})
+		return upload_err
+	}
+
+	return lease.Complete(gc.Context())
+}
+
+// guestImportOvf creates a guest from an ovf or ova source, without ovftool.  The
+// ovf is parsed with govmomi, the guest is created by the host's OvfManager and
+// the disks are streamed to the host through the NFC lease.  ovf_properties are
+// set in the guest's vApp options and injected as guestinfo.ovfEnv.
+func guestImportOvf(c *Config, guest_name, disk_store, src_path, resource_pool_name, boot_disk_type string,
+	virtual_networks []guestNIC, ovf_properties map[string]string, rollback *guestRollback) (string, error) {
+	log.Printf("[guestImportOvf] %s from %s\n", guest_name, src_path)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	archive, ovf_path, err := ovfArchive(gc, src_path)
+	if err != nil {
+		return "", err
+	}
+
+	descriptor, err := importer.ReadOvf(ovf_path, archive)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", src_path, err)
+	}
+	envelope, err := importer.ReadEnvelope(descriptor)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", src_path, err)
+	}
+
+	datastore, err := gc.Finder.Datastore(gc.Context(), disk_store)
+	if err != nil {
+		return "", fmt.Errorf("failed to find disk store %s: %w", disk_store, err)
+	}
+
+	poolID, err := getPoolID(c, resource_pool_name)
+	if err != nil {
+		return "", fmt.Errorf("failed to use resource pool %s: %w", resource_pool_name, err)
+	}
+	pool := object.NewResourcePool(gc.Client.Client, types.ManagedObjectReference{Type: "ResourcePool", Value: poolID})
+
+	folders, err := gc.Datacenter.Folders(gc.Context())
+	if err != nil {
+		return "", fmt.Errorf("failed to get vm folder: %w", err)
+	}
+
+	imp := &importer.Importer{
+		Log: func(msg string) (int, error) {
+			log.Printf("[guestImportOvf] %s", strings.TrimLeft(msg, "\r"))
+			return len(msg), nil
+		},
+		Client:       gc.Client.Client,
+		Finder:       gc.Finder,
+		Datacenter:   gc.Datacenter,
+		Datastore:    datastore,
+		ResourcePool: pool,
+		Folder:       folders.VmFolder,
+		Archive:      archive,
+	}
+
+	virtual_network := ""
+	if len(virtual_networks) > 0 {
+		virtual_network = virtual_networks[0].VirtualNetwork
+	}
+	properties := ovfPropertyMapping(ovf_properties)
+	options := importer.Options{
+		Name:             &guest_name,
+		DiskProvisioning: ovfDiskProvisioning(boot_disk_type),
+		NetworkMapping:   ovfNetworkMapping(envelope, virtual_network),
+		PropertyMapping:  properties,
+	}
+
+	info, lease, err := imp.ImportVApp(gc.Context(), ovf_path, options)
+	if err != nil {
+		return "", fmt.Errorf("failed to import %s: %w", src_path, err)
+	}
+
+	//  The host removes the guest if the lease is aborted.
+	if err = ovfUpload(gc, imp, lease, info); err != nil {
+		return "", err
+	}
+
+	vmid := info.Entity.Value
+	rollback.add("import ovf", func() error {
+		return guestDESTROY(c, vmid, 0, shutdownBehaviorHard, nil)
+	})
+
+	if len(properties) > 0 {
+		vm := object.NewVirtualMachine(gc.Client.Client, info.Entity)
+		env := ovfEnvironment(gc.Client.ServiceContent.About, vmid, properties)
+
+		task, err := vm.Reconfigure(gc.Context(), types.VirtualMachineConfigSpec{
+			ExtraConfig: []types.BaseOptionValue{&types.OptionValue{Key: "guestinfo.ovfEnv", Value: env}},
+		})
+		if err != nil {
+			return vmid, fmt.Errorf("failed to inject ovf environment: %w", err)
+		}
+		if err = waitForTask(gc.Context(), task); err != nil {
+			return vmid, fmt.Errorf("failed to inject ovf environment: %w", err)
+		}
+	}
+
+	return vmid, nil
+}
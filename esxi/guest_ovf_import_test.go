@@ -0,0 +1,216 @@
+package esxi
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// testOvf is a guest with two disks, a network and a user configurable property
+const testOvf = `<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1"
+          xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1"
+          xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData"
+          xmlns:vssd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData">
+  <References>
+    <File ovf:href="appliance-disk1.vmdk" ovf:id="file1"/>
+    <File ovf:href="appliance-disk2.vmdk" ovf:id="file2"/>
+  </References>
+  <DiskSection>
+    <Info>Virtual disk information</Info>
+    <Disk ovf:capacity="1" ovf:capacityAllocationUnits="byte * 2^30" ovf:diskId="vmdisk1" ovf:fileRef="file1"
+          ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/>
+    <Disk ovf:capacity="1" ovf:capacityAllocationUnits="byte * 2^30" ovf:diskId="vmdisk2" ovf:fileRef="file2"
+          ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/>
+  </DiskSection>
+  <NetworkSection>
+    <Info>The list of logical networks</Info>
+    <Network ovf:name="nat">
+      <Description>The nat network</Description>
+    </Network>
+  </NetworkSection>
+  <VirtualSystem ovf:id="appliance">
+    <Info>A virtual machine</Info>
+    <Name>appliance</Name>
+    <OperatingSystemSection ovf:id="36">
+      <Info>The kind of installed guest operating system</Info>
+    </OperatingSystemSection>
+    <ProductSection ovf:required="false">
+      <Info>Appliance properties</Info>
+      <Property ovf:key="hostname" ovf:type="string" ovf:userConfigurable="true"/>
+    </ProductSection>
+    <VirtualHardwareSection>
+      <Info>Virtual hardware requirements</Info>
+      <System>
+        <vssd:ElementName>Virtual Hardware Family</vssd:ElementName>
+        <vssd:InstanceID>0</vssd:InstanceID>
+        <vssd:VirtualSystemType>vmx-13</vssd:VirtualSystemType>
+      </System>
+      <Item>
+        <rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits>
+        <rasd:ElementName>1 virtual CPU(s)</rasd:ElementName>
+        <rasd:InstanceID>1</rasd:InstanceID>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>1</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits>
+        <rasd:ElementName>512MB of memory</rasd:ElementName>
+        <rasd:InstanceID>2</rasd:InstanceID>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>512</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:Address>0</rasd:Address>
+        <rasd:ElementName>scsiController0</rasd:ElementName>
+        <rasd:InstanceID>3</rasd:InstanceID>
+        <rasd:ResourceSubType>lsilogic</rasd:ResourceSubType>
+        <rasd:ResourceType>6</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>0</rasd:AddressOnParent>
+        <rasd:ElementName>disk0</rasd:ElementName>
+        <rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource>
+        <rasd:InstanceID>4</rasd:InstanceID>
+        <rasd:Parent>3</rasd:Parent>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>1</rasd:AddressOnParent>
+        <rasd:ElementName>disk1</rasd:ElementName>
+        <rasd:HostResource>ovf:/disk/vmdisk2</rasd:HostResource>
+        <rasd:InstanceID>5</rasd:InstanceID>
+        <rasd:Parent>3</rasd:Parent>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>7</rasd:AddressOnParent>
+        <rasd:AutomaticAllocation>true</rasd:AutomaticAllocation>
+        <rasd:Connection>nat</rasd:Connection>
+        <rasd:ElementName>ethernet0</rasd:ElementName>
+        <rasd:InstanceID>6</rasd:InstanceID>
+        <rasd:ResourceSubType>E1000</rasd:ResourceSubType>
+        <rasd:ResourceType>10</rasd:ResourceType>
+      </Item>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`
+
+// writeTestOvf writes testOvf and its disks to dir, and packs them into an ova
+func writeTestOvf(t *testing.T, dir string) (string, string) {
+	files := map[string]string{
+		"appliance.ovf":        testOvf,
+		"appliance-disk1.vmdk": "disk1",
+		"appliance-disk2.vmdk": "disk2",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ova_path := filepath.Join(dir, "appliance.ova")
+	f, err := os.Create(ova_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	//  The descriptor is the first file of an ova.
+	tw := tar.NewWriter(f)
+	for _, name := range []string{"appliance.ovf", "appliance-disk1.vmdk", "appliance-disk2.vmdk"} {
+		err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))})
+		if err == nil {
+			_, err = tw.Write([]byte(files[name]))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "appliance.ovf"), ova_path
+}
+
+// TestGuestImportOvfGovmomi tests importing an ovf and a streamed ova through the NFC lease
+func TestGuestImportOvfGovmomi(t *testing.T) {
+	model := simulator.ESX()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	ds, err := client.Finder.DefaultDatastore(client.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ovf_path, ova_path := writeTestOvf(t, t.TempDir())
+	networks := []guestNIC{{VirtualNetwork: "VM Network"}}
+
+	for _, src_path := range []string{ovf_path, ova_path} {
+		guest_name := "imported" + filepath.Ext(src_path)
+		vmid, err := guestImportOvf(config, guest_name, ds.Name(), src_path, "/", "thin", networks,
+			map[string]string{"hostname": "web1"}, &guestRollback{})
+		if err != nil {
+			t.Fatalf("Failed to import %s: %v", src_path, err)
+		}
+
+		vm := object.NewVirtualMachine(client.Client.Client, types.ManagedObjectReference{Type: "VirtualMachine", Value: vmid})
+		var vmMo mo.VirtualMachine
+		err = vm.Properties(client.Context(), vm.Reference(), []string{"name", "config"}, &vmMo)
+		if err != nil {
+			t.Fatalf("Failed to read imported guest: %v", err)
+		}
+		if vmMo.Name != guest_name {
+			t.Errorf("Expected guest %s, got %s", guest_name, vmMo.Name)
+		}
+
+		devices := object.VirtualDeviceList(vmMo.Config.Hardware.Device)
+		if disks := devices.SelectByType((*types.VirtualDisk)(nil)); len(disks) != 2 {
+			t.Errorf("Expected 2 disks in %s, got %d", guest_name, len(disks))
+		}
+
+		env := ""
+		for _, option := range vmMo.Config.ExtraConfig {
+			if value := option.GetOptionValue(); value.Key == "guestinfo.ovfEnv" {
+				env, _ = value.Value.(string)
+			}
+		}
+		if !strings.Contains(env, `oe:key="hostname" oe:value="web1"`) {
+			t.Errorf("Expected hostname in guestinfo.ovfEnv of %s, got %q", guest_name, env)
+		}
+	}
+
+	_, err = guestImportOvf(config, "img", ds.Name(), "/local_path/centos-7.img", "/", "thin", nil, nil, &guestRollback{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported source") {
+		t.Errorf("Expected img sources to be rejected, got %v", err)
+	}
+}
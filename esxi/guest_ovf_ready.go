@@ -0,0 +1,111 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ovfPropertiesPollInterval is how often the guest is checked while waiting for
+// it to process ovf_properties.
+var ovfPropertiesPollInterval = 5 * time.Second
+
+// ovfPropertiesReady is the condition that tells the guest has processed its
+// ovf_properties.  Only one of them is set.
+type ovfPropertiesReady struct {
+	GuestinfoKey   string // the guest sets guestinfo.<key>
+	GuestinfoValue string // ... to this value, or to any value if empty
+	ToolsRunning   bool   // VMware tools report running
+	GuestShutdown  bool   // the guest powers itself off
+}
+
+// Convert the ovf_properties_ready block.  It returns nil if it's not set.
+func ovfPropertiesReadyFromResourceData(d *schema.ResourceData) (*ovfPropertiesReady, error) {
+	if d.Get("ovf_properties_ready.#").(int) == 0 {
+		return nil, nil
+	}
+
+	ready := &ovfPropertiesReady{
+		GuestinfoKey:   d.Get("ovf_properties_ready.0.guestinfo_key").(string),
+		GuestinfoValue: d.Get("ovf_properties_ready.0.guestinfo_value").(string),
+		ToolsRunning:   d.Get("ovf_properties_ready.0.tools_running").(bool),
+		GuestShutdown:  d.Get("ovf_properties_ready.0.guest_shutdown").(bool),
+	}
+
+	conditions := 0
+	for _, set := range []bool{ready.GuestinfoKey != "", ready.ToolsRunning, ready.GuestShutdown} {
+		if set {
+			conditions++
+		}
+	}
+	if conditions != 1 {
+		return nil, fmt.Errorf("Error: ovf_properties_ready: set one of guestinfo_key, tools_running or guest_shutdown")
+	}
+	if ready.GuestinfoValue != "" && ready.GuestinfoKey == "" {
+		return nil, fmt.Errorf("Error: ovf_properties_ready: guestinfo_value requires guestinfo_key")
+	}
+	return ready, nil
+}
+
+// met tells if the guest is ready
+func (ready ovfPropertiesReady) met(vmMo mo.VirtualMachine) bool {
+	switch {
+	case ready.GuestShutdown:
+		return vmMo.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff
+	case ready.ToolsRunning:
+		return vmMo.Guest != nil && vmMo.Guest.ToolsRunningStatus == string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+	case ready.GuestinfoKey != "":
+		if vmMo.Config == nil {
+			return false
+		}
+		for _, option := range vmMo.Config.ExtraConfig {
+			value := option.GetOptionValue()
+			if value.Key != "guestinfo."+ready.GuestinfoKey {
+				continue
+			}
+			s, _ := value.Value.(string)
+			return s != "" && (ready.GuestinfoValue == "" || s == ready.GuestinfoValue)
+		}
+	}
+	return false
+}
+
+// guestWaitOvfPropertiesReady waits up to timeout for a guest to be ready.
+func guestWaitOvfPropertiesReady(c *Config, vmid string, ready ovfPropertiesReady, timeout time.Duration) error {
+	log.Printf("[guestWaitOvfPropertiesReady] Waiting up to %s for vmid %s: %+v\n", timeout, vmid, ready)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var vmMo mo.VirtualMachine
+		err = vm.Properties(gc.Context(), vm.Reference(), []string{"runtime.powerState", "guest.toolsRunningStatus", "config.extraConfig"}, &vmMo)
+		if err != nil {
+			return fmt.Errorf("failed to get guest properties: %w", err)
+		}
+		if ready.met(vmMo) {
+			return nil
+		}
+
+		//  Only guest_shutdown expects the guest to power off.
+		if !ready.GuestShutdown && vmMo.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff {
+			return fmt.Errorf("guest powered off before it was ready")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("guest not ready after %s", timeout)
+		}
+		time.Sleep(ovfPropertiesPollInterval)
+	}
+}
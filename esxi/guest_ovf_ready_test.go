@@ -0,0 +1,123 @@
+package esxi
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// TestOvfPropertiesReadyMet verifies each ovf_properties_ready condition
+func TestOvfPropertiesReadyMet(t *testing.T) {
+	guestinfo := func(key, value string) []types.BaseOptionValue {
+		return []types.BaseOptionValue{&types.OptionValue{Key: key, Value: value}}
+	}
+
+	tests := []struct {
+		name  string
+		ready ovfPropertiesReady
+		vmMo  mo.VirtualMachine
+		met   bool
+	}{
+		{"shutdown, powered off", ovfPropertiesReady{GuestShutdown: true},
+			mo.VirtualMachine{Runtime: types.VirtualMachineRuntimeInfo{PowerState: types.VirtualMachinePowerStatePoweredOff}}, true},
+		{"shutdown, powered on", ovfPropertiesReady{GuestShutdown: true},
+			mo.VirtualMachine{Runtime: types.VirtualMachineRuntimeInfo{PowerState: types.VirtualMachinePowerStatePoweredOn}}, false},
+		{"tools running", ovfPropertiesReady{ToolsRunning: true},
+			mo.VirtualMachine{Guest: &types.GuestInfo{ToolsRunningStatus: "guestToolsRunning"}}, true},
+		{"tools not running", ovfPropertiesReady{ToolsRunning: true},
+			mo.VirtualMachine{Guest: &types.GuestInfo{ToolsRunningStatus: "guestToolsNotRunning"}}, false},
+		{"key set", ovfPropertiesReady{GuestinfoKey: "ovf.done"},
+			mo.VirtualMachine{Config: &types.VirtualMachineConfigInfo{ExtraConfig: guestinfo("guestinfo.ovf.done", "yes")}}, true},
+		{"key empty", ovfPropertiesReady{GuestinfoKey: "ovf.done"},
+			mo.VirtualMachine{Config: &types.VirtualMachineConfigInfo{ExtraConfig: guestinfo("guestinfo.ovf.done", "")}}, false},
+		{"key missing", ovfPropertiesReady{GuestinfoKey: "ovf.done"},
+			mo.VirtualMachine{Config: &types.VirtualMachineConfigInfo{ExtraConfig: guestinfo("guestinfo.other", "yes")}}, false},
+		{"key value", ovfPropertiesReady{GuestinfoKey: "ovf.status", GuestinfoValue: "done"},
+			mo.VirtualMachine{Config: &types.VirtualMachineConfigInfo{ExtraConfig: guestinfo("guestinfo.ovf.status", "done")}}, true},
+		{"key other value", ovfPropertiesReady{GuestinfoKey: "ovf.status", GuestinfoValue: "done"},
+			mo.VirtualMachine{Config: &types.VirtualMachineConfigInfo{ExtraConfig: guestinfo("guestinfo.ovf.status", "running")}}, false},
+	}
+
+	for _, test := range tests {
+		if met := test.ready.met(test.vmMo); met != test.met {
+			t.Errorf("%s: expected met %v, got %v", test.name, test.met, met)
+		}
+	}
+}
+
+// TestGuestWaitOvfPropertiesReadyGovmomi verifies waiting for a guest with the vcsim simulator
+func TestGuestWaitOvfPropertiesReadyGovmomi(t *testing.T) {
+	defer func(interval time.Duration) { ovfPropertiesPollInterval = interval }(ovfPropertiesPollInterval)
+	ovfPropertiesPollInterval = 10 * time.Millisecond
+
+	model := simulator.ESX()
+	defer model.Remove()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("Failed to find a guest: %v", err)
+	}
+	vm := vms[0]
+	vmid := vm.Reference().Value
+
+	//  The guest hasn't set the key yet.
+	ready := ovfPropertiesReady{GuestinfoKey: "ovf.done"}
+	err = guestWaitOvfPropertiesReady(config, vmid, ready, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "guest not ready after") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+
+	task, err := vm.Reconfigure(client.Context(), types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{&types.OptionValue{Key: "guestinfo.ovf.done", Value: "yes"}},
+	})
+	if err == nil {
+		err = task.Wait(client.Context())
+	}
+	if err != nil {
+		t.Fatalf("Failed to set guestinfo: %v", err)
+	}
+	if err = guestWaitOvfPropertiesReady(config, vmid, ready, time.Second); err != nil {
+		t.Errorf("Expected the guestinfo key to be ready, got %v", err)
+	}
+
+	task, err = vm.PowerOff(client.Context())
+	if err == nil {
+		err = task.Wait(client.Context())
+	}
+	if err != nil {
+		t.Fatalf("Failed to power off guest: %v", err)
+	}
+	if err = guestWaitOvfPropertiesReady(config, vmid, ovfPropertiesReady{GuestShutdown: true}, time.Second); err != nil {
+		t.Errorf("Expected the guest shutdown to be ready, got %v", err)
+	}
+	err = guestWaitOvfPropertiesReady(config, vmid, ovfPropertiesReady{ToolsRunning: true}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "powered off before it was ready") {
+		t.Errorf("Expected a powered off error, got %v", err)
+	}
+}
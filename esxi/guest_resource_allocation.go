@@ -0,0 +1,173 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guestResourceAllocationKeys are the esxi_guest attributes of a guest's
+// resource allocation.
+var guestResourceAllocationKeys = []string{
+	"cpu_reservation", "cpu_limit", "cpu_shares",
+	"mem_reservation", "mem_limit", "mem_shares", "mem_reservation_locked_to_max",
+}
+
+// sharesRe matches the shares of a guest's cpu or memory
+var sharesRe = regexp.MustCompile(`^(low|normal|high|[1-9][0-9]*)$`)
+
+// guestResourceAllocation is the cpu and memory allocation of a guest.  A limit
+// of 0 is unlimited.
+type guestResourceAllocation struct {
+	CpuReservation int // MHz
+	CpuLimit       int // MHz
+	CpuShares      string
+	MemReservation int // MB
+	MemLimit       int // MB
+	MemShares      string
+
+	MemReservationLockedToMax bool
+}
+
+// Get the resource allocation from the resource config.
+func guestResourceAllocationFromResourceData(d *schema.ResourceData) guestResourceAllocation {
+	return guestResourceAllocation{
+		CpuReservation:            d.Get("cpu_reservation").(int),
+		CpuLimit:                  d.Get("cpu_limit").(int),
+		CpuShares:                 d.Get("cpu_shares").(string),
+		MemReservation:            d.Get("mem_reservation").(int),
+		MemLimit:                  d.Get("mem_limit").(int),
+		MemShares:                 d.Get("mem_shares").(string),
+		MemReservationLockedToMax: d.Get("mem_reservation_locked_to_max").(bool),
+	}
+}
+
+// guestResourceAllocationConfigured tells if any resource allocation attribute
+// is set.  Otherwise a new guest keeps the allocation of its source.
+func guestResourceAllocationConfigured(d *schema.ResourceData) bool {
+	for _, key := range guestResourceAllocationKeys {
+		if _, ok := d.GetOk(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// guestResourceAllocationChanged tells if any resource allocation attribute changed.
+func guestResourceAllocationChanged(d *schema.ResourceData) bool {
+	for _, key := range guestResourceAllocationKeys {
+		if d.HasChange(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the resource allocation attributes.  With mem_reservation_locked_to_max,
+// the memory reservation follows memsize, so mem_reservation isn't read back.
+func guestResourceAllocationToResourceData(d *schema.ResourceData, allocation guestResourceAllocation) {
+	d.Set("cpu_reservation", allocation.CpuReservation)
+	d.Set("cpu_limit", allocation.CpuLimit)
+	d.Set("cpu_shares", allocation.CpuShares)
+	if !allocation.MemReservationLockedToMax {
+		d.Set("mem_reservation", allocation.MemReservation)
+	}
+	d.Set("mem_limit", allocation.MemLimit)
+	d.Set("mem_shares", allocation.MemShares)
+	d.Set("mem_reservation_locked_to_max", allocation.MemReservationLockedToMax)
+}
+
+// guestAllocationInfo builds the allocation of a guest's cpu or memory.  Unlike
+// resource pools, guests have no expandable reservation.
+func guestAllocationInfo(reservation int, limit int, shares string) *types.ResourceAllocationInfo {
+	allocation := buildAllocationInfo(reservation, "", limit, shares)
+	allocation.ExpandableReservation = nil
+	if allocation.Reservation == nil {
+		allocation.Reservation = types.NewInt64(0)
+	}
+	return &allocation
+}
+
+// configSpec returns the reconfigure spec that sets the allocation
+func (allocation guestResourceAllocation) configSpec() types.VirtualMachineConfigSpec {
+	return types.VirtualMachineConfigSpec{
+		CpuAllocation:                guestAllocationInfo(allocation.CpuReservation, allocation.CpuLimit, allocation.CpuShares),
+		MemoryAllocation:             guestAllocationInfo(allocation.MemReservation, allocation.MemLimit, allocation.MemShares),
+		MemoryReservationLockedToMax: types.NewBool(allocation.MemReservationLockedToMax),
+	}
+}
+
+// guestResourceAllocationFromConfig reads the allocation of a guest
+func guestResourceAllocationFromConfig(config *types.VirtualMachineConfigInfo) guestResourceAllocation {
+	var allocation guestResourceAllocation
+	if config == nil {
+		return allocation
+	}
+
+	read := func(info *types.ResourceAllocationInfo) (reservation int, limit int, shares string) {
+		if info == nil {
+			return 0, 0, ""
+		}
+		if info.Reservation != nil {
+			reservation = int(*info.Reservation)
+		}
+		if info.Limit != nil && *info.Limit >= 0 {
+			limit = int(*info.Limit)
+		}
+		return reservation, limit, sharesString(info.Shares)
+	}
+	allocation.CpuReservation, allocation.CpuLimit, allocation.CpuShares = read(config.CpuAllocation)
+	allocation.MemReservation, allocation.MemLimit, allocation.MemShares = read(config.MemoryAllocation)
+	if config.MemoryReservationLockedToMax != nil {
+		allocation.MemReservationLockedToMax = *config.MemoryReservationLockedToMax
+	}
+	return allocation
+}
+
+// guestGetResourceAllocation returns the cpu and memory allocation of a guest.
+func guestGetResourceAllocation(c *Config, vmid string) (guestResourceAllocation, error) {
+	log.Printf("[guestGetResourceAllocation]\n")
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return guestResourceAllocation{}, fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return guestResourceAllocation{}, err
+	}
+
+	var vmMo mo.VirtualMachine
+	err = vm.Properties(gc.Context(), vm.Reference(), []string{"config.cpuAllocation", "config.memoryAllocation", "config.memoryReservationLockedToMax"}, &vmMo)
+	if err != nil {
+		return guestResourceAllocation{}, fmt.Errorf("Failed to get guest resource allocation: %s\n", err)
+	}
+	return guestResourceAllocationFromConfig(vmMo.Config), nil
+}
+
+// guestSetResourceAllocation sets the cpu and memory allocation of a guest.
+func guestSetResourceAllocation(c *Config, vmid string, allocation guestResourceAllocation) error {
+	log.Printf("[guestSetResourceAllocation] %+v\n", allocation)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+
+	task, err := vm.Reconfigure(gc.Context(), allocation.configSpec())
+	if err == nil {
+		err = waitForTask(gc.Context(), task)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to set guest resource allocation: %s\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package esxi
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// TestGuestResourceAllocationConfigSpec verifies the allocation reads back from its reconfigure spec
+func TestGuestResourceAllocationConfigSpec(t *testing.T) {
+	for _, allocation := range []guestResourceAllocation{
+		{CpuShares: "normal", MemShares: "normal"},
+		{CpuReservation: 1000, CpuLimit: 2000, CpuShares: "high", MemReservation: 4096, MemLimit: 8192, MemShares: "2500"},
+		{CpuShares: "low", MemShares: "normal", MemReservationLockedToMax: true},
+	} {
+		spec := allocation.configSpec()
+		if spec.CpuAllocation.ExpandableReservation != nil || spec.MemoryAllocation.ExpandableReservation != nil {
+			t.Errorf("%+v: guests have no expandable reservation", allocation)
+		}
+		if allocation.CpuLimit == 0 && *spec.CpuAllocation.Limit != -1 {
+			t.Errorf("%+v: expected an unlimited cpu, got %d", allocation, *spec.CpuAllocation.Limit)
+		}
+
+		got := guestResourceAllocationFromConfig(&types.VirtualMachineConfigInfo{
+			CpuAllocation:                spec.CpuAllocation,
+			MemoryAllocation:             spec.MemoryAllocation,
+			MemoryReservationLockedToMax: spec.MemoryReservationLockedToMax,
+		})
+		if got != allocation {
+			t.Errorf("Expected %+v, got %+v", allocation, got)
+		}
+	}
+}
+
+// TestGuestResourceAllocationGovmomi verifies setting a guest's allocation with the vcsim simulator
+func TestGuestResourceAllocationGovmomi(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("Failed to find a guest: %v", err)
+	}
+	vmid := vms[0].Reference().Value
+
+	allocation := guestResourceAllocation{CpuReservation: 500, CpuShares: "4000", MemReservation: 256, MemLimit: 1024, MemShares: "low"}
+	if err = guestSetResourceAllocation(config, vmid, allocation); err != nil {
+		t.Fatalf("Failed to set resource allocation: %v", err)
+	}
+	got, err := guestGetResourceAllocation(config, vmid)
+	if err != nil {
+		t.Fatalf("Failed to get resource allocation: %v", err)
+	}
+	if got != allocation {
+		t.Errorf("Expected %+v, got %+v", allocation, got)
+	}
+}
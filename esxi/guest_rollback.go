@@ -0,0 +1,93 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// guestRollback records the steps completed while creating a guest, so a failed create
+// can be undone in reverse order.
+type guestRollback struct {
+	steps []guestRollbackStep
+}
+
+type guestRollbackStep struct {
+	desc string
+	undo func() error
+}
+
+// add records a completed step and how to undo it.
+func (r *guestRollback) add(desc string, undo func() error) {
+	log.Printf("[guestRollback] done: %s\n", desc)
+	r.steps = append(r.steps, guestRollbackStep{desc: desc, undo: undo})
+}
+
+// undo undoes the recorded steps, newest first.  Earlier steps depend on later ones
+// being undone (a folder can't be removed while the guest is registered), so undo
+// stops at the first failure.
+func (r *guestRollback) undo() error {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		log.Printf("[guestRollback] undo: %s\n", step.desc)
+
+		err := step.undo()
+		if err != nil {
+			var remaining []string
+			for j := i; j >= 0; j-- {
+				remaining = append(remaining, r.steps[j].desc)
+			}
+			r.steps = r.steps[:i+1]
+			return fmt.Errorf("Failed to undo %s: %s (not undone: %s)", step.desc,
+				strings.TrimSpace(err.Error()), strings.Join(remaining, ", "))
+		}
+	}
+
+	r.steps = nil
+	return nil
+}
+
+// guestUnregister powers off a guest and removes it from the inventory, leaving its files.
+func guestUnregister(c *Config, vmid string) error {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Printf("[guestUnregister]\n")
+
+	_, err := guestPowerOff(c, vmid, 0, shutdownBehaviorHard)
+	if err != nil {
+		return fmt.Errorf("Failed to power off: %s\n", err)
+	}
+
+	remote_cmd := shellCommand("vim-cmd", "vmsvc/unregister", vmid)
+	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmsvc/unregister")
+	if err != nil {
+		log.Printf("[guestUnregister] Failed unregister vmid: %s\n", stdout)
+		return fmt.Errorf("Failed to unregister vm: %s\n", err)
+	}
+	return nil
+}
+
+// removeRemotePath removes a file or directory on the esxi host.
+func removeRemotePath(c *Config, path string) error {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Printf("[removeRemotePath] %s\n", path)
+
+	remote_cmd := shellCommand("rm", "-fr", path)
+	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "remove path")
+	if err != nil {
+		return fmt.Errorf("Failed to remove %s: %s %s\n", path, stdout, err)
+	}
+	return nil
+}
+
+// removeRemoteDisk deletes a vmdk and its extents on the esxi host.
+func removeRemoteDisk(c *Config, vmdk_path string) error {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Printf("[removeRemoteDisk] %s\n", vmdk_path)
+
+	remote_cmd := shellCommand("vmkfstools", "-U", vmdk_path)
+	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "vmkfstools (delete disk)")
+	if err != nil {
+		return fmt.Errorf("Failed to delete disk %s: %s %s\n", vmdk_path, stdout, err)
+	}
+	return nil
+}
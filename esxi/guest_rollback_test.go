@@ -0,0 +1,64 @@
+package esxi
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestGuestRollbackUndo verifies steps are undone newest first
+func TestGuestRollbackUndo(t *testing.T) {
+	var undone []string
+	rollback := &guestRollback{}
+	for _, step := range []string{"create guest path", "write guest_name.vmx file", "make boot disk", "register guest"} {
+		step := step
+		rollback.add(step, func() error {
+			undone = append(undone, step)
+			return nil
+		})
+	}
+
+	if err := rollback.undo(); err != nil {
+		t.Fatalf("undo failed: %s", err)
+	}
+
+	expected := []string{"register guest", "make boot disk", "write guest_name.vmx file", "create guest path"}
+	if !reflect.DeepEqual(undone, expected) {
+		t.Errorf("undone in wrong order:\n got: %q\nwant: %q", undone, expected)
+	}
+
+	//  Steps are only undone once.
+	undone = nil
+	if err := rollback.undo(); err != nil || len(undone) != 0 {
+		t.Errorf("second undo should do nothing, undid %q, err %v", undone, err)
+	}
+}
+
+// TestGuestRollbackUndoStopsOnFailure verifies earlier steps are kept when an undo fails
+func TestGuestRollbackUndoStopsOnFailure(t *testing.T) {
+	var undone []string
+	rollback := &guestRollback{}
+	rollback.add("create guest path", func() error {
+		undone = append(undone, "create guest path")
+		return nil
+	})
+	rollback.add("register guest", func() error {
+		return errors.New("vim-cmd failed\n")
+	})
+	rollback.add("grow boot disk", func() error {
+		undone = append(undone, "grow boot disk")
+		return nil
+	})
+
+	err := rollback.undo()
+	if err == nil {
+		t.Fatal("expected undo to fail")
+	}
+	if !strings.Contains(err.Error(), "register guest: vim-cmd failed") || !strings.Contains(err.Error(), "not undone: register guest, create guest path") {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(undone, []string{"grow boot disk"}) {
+		t.Errorf("folder must not be removed while the guest is registered, undid %q", undone)
+	}
+}
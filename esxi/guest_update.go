@@ -13,10 +13,6 @@ func resourceGUESTUpdate(d *schema.ResourceData, m interface{}) error {
 	c := m.(*Config)
 	log.Printf("[resourceGUESTUpdate]\n")
 
-	var virtual_networks [10][3]string
-	var virtual_disks [60][2]string
-	var i int
-	var err error
 	var did_grow bool
 
 	vmid := d.Id()
@@ -28,35 +24,29 @@ func resourceGUESTUpdate(d *schema.ResourceData, m interface{}) error {
 	guest_shutdown_timeout := d.Get("guest_shutdown_timeout").(int)
 	notes := d.Get("notes").(string)
 	boot_firmware := d.Get("boot_firmware").(string)
-	lanAdaptersCount := d.Get("network_interfaces.#").(int)
 	power := d.Get("power").(string)
+	shutdown_behavior := d.Get("shutdown_behavior").(string)
+	vmx_backup_retention := d.Get("vmx_backup_retention").(int)
 
-	guestinfo, ok := d.Get("guestinfo").(map[string]interface{})
-	if !ok {
-		return errors.New("guestinfo is wrong type")
+	//  Keys removed from guestinfo are removed from the vmx.
+	guestinfo, removed_guestinfo, err := guestinfoFromResourceData(d)
+	if err != nil {
+		return err
 	}
 
-	if lanAdaptersCount > 10 {
-		lanAdaptersCount = 10
-	}
-	for i := 0; i < lanAdaptersCount; i++ {
-		prefix := fmt.Sprintf("network_interfaces.%d.", i)
+	//  Keys removed from extra_config are removed from the vmx.
+	old_extra_config, new_extra_config := d.GetChange("extra_config")
+	extra_config := new_extra_config.(map[string]interface{})
+	removed_extra_config := removedExtraConfigKeys(old_extra_config.(map[string]interface{}), extra_config)
 
-		if attr, ok := d.Get(prefix + "virtual_network").(string); ok && attr != "" {
-			virtual_networks[i][0] = d.Get(prefix + "virtual_network").(string)
-		}
-		if attr, ok := d.Get(prefix + "mac_address").(string); ok && attr != "" {
-			virtual_networks[i][1] = d.Get(prefix + "mac_address").(string)
-		}
-		if attr, ok := d.Get(prefix + "nic_type").(string); ok && attr != "" {
-			virtual_networks[i][2] = d.Get(prefix + "nic_type").(string)
-		}
+	virtual_networks, err := guestNICsFromResourceData(d)
+	if err != nil {
+		return err
 	}
 
-	//  Validate virtual_disks
-	virtualDiskCount := d.Get("virtual_disks.#").(int)
-	if virtualDiskCount > 59 {
-		virtualDiskCount = 59
+	wait_for, err := guestWaitForFromResourceData(d)
+	if err != nil {
+		return err
 	}
 
 	// Validate guestOS
@@ -64,63 +54,174 @@ func resourceGUESTUpdate(d *schema.ResourceData, m interface{}) error {
 		return errors.New("Error: invalid guestos.  see https://github.com/josenk/vagrant-vmware-esxi/wiki/VMware-ESXi-6.5-guestOS-types")
 	}
 
-	for i = 0; i < virtualDiskCount; i++ {
-		prefix := fmt.Sprintf("virtual_disks.%d.", i)
-
-		if attr, ok := d.Get(prefix + "virtual_disk_id").(string); ok && attr != "" {
-			virtual_disks[i][0] = d.Get(prefix + "virtual_disk_id").(string)
-		}
+	//  Validate virtual_disks and controllers
+	virtual_disks, err := guestDisksFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	controllers, err := guestControllersFromResourceData(d)
+	if err != nil {
+		return err
+	}
 
-		if attr, ok := d.Get(prefix + "slot").(string); ok && attr != "" {
-			// todo validate slots are in format "0-3:0-15"
-			virtual_disks[i][1] = d.Get(prefix + "slot").(string)
-		}
+	//  Removing every cdrom block removes the guest's cdroms.
+	cdroms, err := guestCdromsFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	if cdroms == nil && d.HasChange("cdrom") {
+		cdroms = []guestCdrom{}
 	}
 
-	//
-	//   Power off guest if it's powered on.
-	//
-	currentpowerstate := guestPowerGetState(c, vmid)
-	if currentpowerstate == "on" || currentpowerstate == "suspended" {
-		_, err = guestPowerOff(c, vmid, guest_shutdown_timeout)
+	//  cores_per_socket must still divide numvcpus if only numvcpus changed.
+	inumvcpus, _ := strconv.Atoi(numvcpus)
+	var cpu *guestCPU
+	if guestCPUChanged(d) || d.HasChange("numvcpus") {
+		guest_cpu := guestCPUFromResourceData(d)
+		err = guestValidateCPU(c, guest_cpu, inumvcpus)
 		if err != nil {
-			return fmt.Errorf("Failed to power off: %s\n", err)
+			return err
+		}
+		if guestCPUChanged(d) {
+			cpu = &guest_cpu
 		}
 	}
 
-	//
-	//  make updates to vmx file
-	//
-	imemsize, _ := strconv.Atoi(memsize)
-	inumvcpus, _ := strconv.Atoi(numvcpus)
+	boot_options := guestBootOptionsFromResourceData(d)
 	ivirthwver, _ := strconv.Atoi(virthwver)
-	err = updateVmx_contents(c, vmid, false, imemsize, inumvcpus, ivirthwver, guestos, virtual_networks, boot_firmware, virtual_disks, notes, guestinfo)
+	err = boot_options.validate(boot_firmware, ivirthwver)
 	if err != nil {
-		fmt.Println("Failed to update vmx file.")
-		return fmt.Errorf("Failed to update vmx file: %s\n", err)
+		return err
 	}
 
 	//
-	//  Grow boot disk to boot_disk_size
+	//  A powered on guest is only powered off for cold changes.
 	//
-	boot_disk_vmdkPATH, _ := getBootDiskPath(c, vmid)
+	currentpowerstate := guestPowerGetState(c, vmid)
+	hot := false
+	switch currentpowerstate {
+	case "on":
+		tools_running := false
+		if d.HasChange("guestinfo") || d.HasChange("sensitive_guestinfo") || d.HasChange("guestinfo_encoding") {
+			tools_running = guestToolsRunning(c, vmid)
+		}
+		cold := guestColdChanges(d, tools_running)
+		hot = len(cold) == 0
+		if !hot {
+			log.Printf("[resourceGUESTUpdate] Powering off for: %v\n", cold)
+		}
+	case "suspended":
+		cold := guestSuspendedColdChanges(d)
+		if len(cold) > 0 && power == "suspended" {
+			return guestSuspendedUpdateError(cold)
+		}
+		hot = len(cold) == 0
+	}
 
-	did_grow, err = growVirtualDisk(c, boot_disk_vmdkPATH, boot_disk_size)
-	if err != nil {
-		return fmt.Errorf("Failed to grow virtual disk: %s\n", err)
+	if hot {
+		err = guestHotUpdate(c, vmid, d, guestinfo, removed_guestinfo)
+		if err != nil {
+			return err
+		}
+		if power == "off" {
+			_, err = guestPowerOff(c, vmid, guest_shutdown_timeout, shutdown_behavior)
+			if err != nil {
+				return fmt.Errorf("Failed to power off: %s\n", err)
+			}
+		}
+	} else {
+		//
+		//   Power off guest if it's powered on.
+		//
+		if currentpowerstate == "on" || currentpowerstate == "suspended" {
+			_, err = guestPowerOff(c, vmid, guest_shutdown_timeout, shutdown_behavior)
+			if err != nil {
+				return fmt.Errorf("Failed to power off: %s\n", err)
+			}
+		}
+
+		//
+		//  make updates to vmx file
+		//
+		imemsize, _ := strconv.Atoi(memsize)
+		err = updateVmx_contents(c, vmid, false, imemsize, inumvcpus, cpu, ivirthwver, guestos, virtual_networks, boot_firmware, virtual_disks, controllers, cdroms, notes, guestinfo, removed_guestinfo, extra_config, removed_extra_config, vmx_backup_retention)
+		if err != nil {
+			fmt.Println("Failed to update vmx file.")
+			return fmt.Errorf("Failed to update vmx file: %s\n", err)
+		}
+
+		//
+		//  Grow boot disk to boot_disk_size
+		//
+		boot_disk_vmdkPATH, _ := getBootDiskPath(c, vmid)
+
+		did_grow, err = growVirtualDisk(c, boot_disk_vmdkPATH, boot_disk_size)
+		if err != nil {
+			return fmt.Errorf("Failed to grow virtual disk: %s\n", err)
+		}
+
+		if did_grow {
+			err = guestReload(c, vmid)
+		}
 	}
 
-	if did_grow {
-		err = guestReload(c, vmid)
+	if guestBootOptionsChanged(d) {
+		err = guestSetBootOptions(c, vmid, boot_options)
+		if err != nil {
+			return err
+		}
 	}
 
-	//  power on
-	if power == "on" {
+	if autostart := guestAutoStartFromResourceData(d); autostart != nil && d.HasChange("autostart") {
+		err = guestSetAutoStart(c, vmid, *autostart)
+		if err != nil {
+			return err
+		}
+	}
+
+	if guestResourceAllocationChanged(d) {
+		err = guestSetResourceAllocation(c, vmid, guestResourceAllocationFromResourceData(d))
+		if err != nil {
+			return err
+		}
+	}
+
+	//  power on.  A guest is suspended once it's on, unless it stays suspended.
+	if power == "on" || (power == "suspended" && !(hot && currentpowerstate == "suspended")) {
 		_, err = guestPowerOn(c, vmid)
 		if err != nil {
 			fmt.Println("Failed to power on.")
 			return fmt.Errorf("Failed to power on: %s\n", err)
 		}
+		//  A guest that was powered off for the update has just booted.
+		if hot && d.HasChange("reboot_trigger") {
+			_, err = guestReboot(c, vmid, shutdown_behavior)
+			if err != nil {
+				return fmt.Errorf("Failed to reboot: %s\n", err)
+			}
+		}
+		err = guestSetNICConnected(c, vmid, virtual_networks)
+		if err != nil {
+			return err
+		}
+		if len(cdroms) > 0 {
+			err = guestSetCdromConnected(c, vmid, cdroms)
+			if err != nil {
+				return err
+			}
+		}
+		if wait_for != nil {
+			err = guestWaitForReady(c, vmid, *wait_for)
+			if err != nil {
+				return fmt.Errorf("Failed to wait for guest: %s\n", err)
+			}
+		}
+		if power == "suspended" {
+			_, err = guestPowerSuspend(c, vmid)
+			if err != nil {
+				return fmt.Errorf("Failed to suspend: %s\n", err)
+			}
+		}
 	}
 
 	return resourceGUESTRead(d, m)
@@ -0,0 +1,253 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+)
+
+// Backups are written next to the vmx file as <name>.vmx.<UTC timestamp>.bak.  The
+// timestamp has microseconds, so writes in the same second don't overwrite each
+// other's backups.  Backups named with whole seconds, by older versions, are still
+// recognised when pruning.
+const vmxBackupTimeFormat = "20060102T150405.000000Z"
+
+var vmxBackupTimeFormats = []string{vmxBackupTimeFormat, "20060102T150405Z"}
+
+// vmxFileOps are the operations a vmx transaction makes on the esxi host.
+type vmxFileOps interface {
+	copy(src, dst string) error
+	read(path string) (string, error)
+	write(contents, path string) error
+	remove(path string) error
+	reload() error
+}
+
+// sshVmxFileOps makes vmx transaction operations over ssh and govmomi.
+type sshVmxFileOps struct {
+	c    *Config
+	vmid string
+}
+
+func (o sshVmxFileOps) copy(src, dst string) error {
+	remote_cmd := shellCommand("cp", "-p", src, dst)
+	stdout, err := runRemoteSshCommand(getConnectionInfo(o.c), remote_cmd, "copy guest_name.vmx file")
+	if err != nil {
+		return fmt.Errorf("%s %s", err, stdout)
+	}
+	return nil
+}
+
+func (o sshVmxFileOps) read(path string) (string, error) {
+	return runRemoteSshCommand(getConnectionInfo(o.c), shellCommand("cat", path), "read guest_name.vmx backup")
+}
+
+func (o sshVmxFileOps) write(contents, path string) error {
+	_, err := writeContentToRemoteFile(getConnectionInfo(o.c), contents, path, "write guest_name.vmx file")
+	return err
+}
+
+func (o sshVmxFileOps) remove(path string) error {
+	stdout, err := runRemoteSshCommand(getConnectionInfo(o.c), shellCommand("rm", "-f", path), "remove guest_name.vmx backup")
+	if err != nil {
+		return fmt.Errorf("%s %s", err, stdout)
+	}
+	return nil
+}
+
+func (o sshVmxFileOps) reload() error {
+	return guestReloadChecked(o.c, o.vmid)
+}
+
+// writeVmxTransaction replaces a guest's vmx file and reloads the guest.  The current
+// file is backed up first, and restored if the write or the reload fails.  Only the
+// newest vmx_backup_retention backups are kept.
+func writeVmxTransaction(c *Config, vmid string, vmx_contents string, vmx_backup_retention int) error {
+	log.Printf("[writeVmxTransaction]\n")
+
+	dst_vmx_file, err := getDst_vmx_file(c, vmid)
+	if err != nil {
+		return fmt.Errorf("Failed to get vmx file location: %s\n", err)
+	}
+
+	err = runVmxTransaction(sshVmxFileOps{c: c, vmid: vmid}, dst_vmx_file, vmx_contents, time.Now())
+	if err != nil {
+		return err
+	}
+
+	pruneVmxBackups(c, dst_vmx_file, vmx_backup_retention)
+	return nil
+}
+
+// runVmxTransaction backs up dst_vmx_file, writes vmx_contents to it and reloads the
+// guest, restoring the backup if the write or the reload fails.  Once the vmx file
+// is updated or restored, the guestinfo values are stripped from the backup.
+func runVmxTransaction(ops vmxFileOps, dst_vmx_file string, vmx_contents string, now time.Time) error {
+	err := validateVmxContents(vmx_contents)
+	if err != nil {
+		return fmt.Errorf("Refusing to write invalid vmx file: %s\n", err)
+	}
+
+	backup_file := vmxBackupName(dst_vmx_file, now)
+	err = ops.copy(dst_vmx_file, backup_file)
+	if err != nil {
+		log.Printf("[writeVmxTransaction] Failed backup vmx file: %s\n", err)
+		return fmt.Errorf("Failed to backup vmx file: %s\n", err)
+	}
+
+	err = ops.write(vmx_contents, dst_vmx_file)
+	if err == nil {
+		err = ops.reload()
+	}
+	if err != nil {
+		log.Printf("[writeVmxTransaction] Update failed, restoring %s: %s\n", backup_file, err)
+		restore_err := ops.copy(backup_file, dst_vmx_file)
+		if restore_err == nil {
+			restore_err = ops.reload()
+		}
+		if restore_err != nil {
+			return fmt.Errorf("Failed to update vmx file: %s\nFailed to restore backup %s: %s\n", err, backup_file, restore_err)
+		}
+		stripVmxBackupGuestinfo(ops, backup_file)
+		return fmt.Errorf("Failed to update vmx file, restored backup %s: %s\n", backup_file, err)
+	}
+
+	stripVmxBackupGuestinfo(ops, backup_file)
+	return nil
+}
+
+// stripVmxBackupGuestinfo removes the guestinfo keys from a backup, as they may hold
+// sensitive_guestinfo values.  If that fails the backup is removed instead.
+func stripVmxBackupGuestinfo(ops vmxFileOps, backup_file string) {
+	contents, err := ops.read(backup_file)
+	if err == nil {
+		doc := vmx.Parse(contents)
+		if doc.DeleteDevice("guestinfo") == 0 {
+			return
+		}
+		err = ops.write(doc.String(), backup_file)
+	}
+	if err != nil {
+		log.Printf("[writeVmxTransaction] Failed strip guestinfo from %s, removing it: %s\n", backup_file, err)
+		if err = ops.remove(backup_file); err != nil {
+			log.Printf("[writeVmxTransaction] Failed remove %s: %s\n", backup_file, err)
+		}
+	}
+}
+
+// guestReloadChecked reloads a guest and fails if the host could not load its vmx file.
+func guestReloadChecked(c *Config, vmid string) error {
+	err := guestReload(c, vmid)
+	if err != nil {
+		return err
+	}
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+	state, err := getConnectionState(gc.Context(), vm)
+	if err != nil {
+		return err
+	}
+	if state != "connected" {
+		return fmt.Errorf("guest is %s after reload", state)
+	}
+	return nil
+}
+
+// pruneVmxBackups removes all but the newest keep backups of a vmx file.
+func pruneVmxBackups(c *Config, dst_vmx_file string, keep int) {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Printf("[pruneVmxBackups]\n")
+
+	//  The glob is left unquoted so the shell expands it.
+	remote_cmd := "ls -1d " + shellQuote(dst_vmx_file) + ".*.bak"
+	stdout, err := runRemoteSshCommand(esxiConnInfo, remote_cmd, "list vmx backups")
+	if err != nil {
+		return
+	}
+
+	prune := vmxBackupsToPrune(dst_vmx_file, strings.Split(stdout, "\n"), keep)
+	if len(prune) == 0 {
+		return
+	}
+	remote_cmd = shellCommand("rm", append([]string{"-f"}, prune...)...)
+	stdout, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "remove old vmx backups")
+	if err != nil {
+		log.Printf("[pruneVmxBackups] Failed remove old backups: %s\n", stdout)
+	}
+}
+
+func vmxBackupName(dst_vmx_file string, t time.Time) string {
+	return dst_vmx_file + "." + t.UTC().Format(vmxBackupTimeFormat) + ".bak"
+}
+
+// vmxBackupsToPrune returns the backups of dst_vmx_file that are older than the newest
+// keep.  Files that are not backups made by this provider are never returned.
+func vmxBackupsToPrune(dst_vmx_file string, files []string, keep int) []string {
+	prefix := dst_vmx_file + "."
+
+	var backups []string
+	times := make(map[string]time.Time)
+	for _, file := range files {
+		file = strings.TrimSpace(file)
+		if !strings.HasPrefix(file, prefix) || !strings.HasSuffix(file, ".bak") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(file, prefix), ".bak")
+		t, ok := parseVmxBackupTime(stamp)
+		if !ok {
+			continue
+		}
+		backups = append(backups, file)
+		times[file] = t
+	}
+
+	if keep < 0 {
+		keep = 0
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	//  Newest first.
+	sort.SliceStable(backups, func(i, j int) bool { return times[backups[i]].After(times[backups[j]]) })
+	return backups[keep:]
+}
+
+func parseVmxBackupTime(stamp string) (time.Time, bool) {
+	for _, format := range vmxBackupTimeFormats {
+		if t, err := time.Parse(format, stamp); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// validateVmxContents checks vmx contents before they replace a guest's vmx file.
+// Lines the provider doesn't understand, such as unquoted values and comments, are
+// kept by vmx.Parse and left alone here, so hand edited vmx files can be updated.
+func validateVmxContents(vmx_contents string) error {
+	doc := vmx.Parse(vmx_contents)
+
+	for _, key := range []string{"config.version", "virtualHW.version"} {
+		value, ok := doc.Get(key)
+		if !ok {
+			return fmt.Errorf("missing required key %s", key)
+		}
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("required key %s is empty", key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package esxi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+)
+
+// TestVmxBackupsToPrune verifies only the oldest provider backups are pruned
+func TestVmxBackupsToPrune(t *testing.T) {
+	vmx := "/vmfs/volumes/My DS/vm1/vm1.vmx"
+	files := []string{
+		vmx + ".20260101T120000Z.bak",
+		vmx + ".20260301T120000Z.bak",
+		vmx + ".20260201T120000Z.bak",
+		vmx + ".20260401T120000Z.bak",
+		vmx + ".20260401T120000.000250Z.bak",
+		vmx + ".manual.bak",
+		vmx + "f",
+		"/vmfs/volumes/My DS/vm1/vm10.vmx.20250101T120000Z.bak",
+		"",
+	}
+
+	tests := map[int][]string{
+		6: nil,
+		5: nil,
+		4: {vmx + ".20260101T120000Z.bak"},
+		2: {vmx + ".20260301T120000Z.bak", vmx + ".20260201T120000Z.bak", vmx + ".20260101T120000Z.bak"},
+		0: {vmx + ".20260401T120000.000250Z.bak", vmx + ".20260401T120000Z.bak", vmx + ".20260301T120000Z.bak", vmx + ".20260201T120000Z.bak", vmx + ".20260101T120000Z.bak"},
+	}
+
+	for keep, expected := range tests {
+		got := vmxBackupsToPrune(vmx, files, keep)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("vmxBackupsToPrune(keep=%d) = %q, expected %q", keep, got, expected)
+		}
+	}
+
+	name := vmxBackupName(vmx, time.Date(2026, 10, 18, 9, 30, 5, 0, time.UTC))
+	if name != vmx+".20261018T093005.000000Z.bak" {
+		t.Errorf("unexpected backup name %q", name)
+	}
+	if got := vmxBackupsToPrune(vmx, []string{name}, 0); len(got) != 1 {
+		t.Errorf("backup %q should be recognised", name)
+	}
+
+	later := vmxBackupName(vmx, time.Date(2026, 10, 18, 9, 30, 5, 1000, time.UTC))
+	if later == name {
+		t.Errorf("backups in the same second share the name %q", name)
+	}
+}
+
+// TestValidateVmxContents verifies broken vmx contents are rejected before they are written
+func TestValidateVmxContents(t *testing.T) {
+	valid := strings.Join([]string{
+		`.encoding = "UTF-8"`,
+		`config.version = "8"`,
+		`virtualHW.version = "13"`,
+		`displayName = "vm |22one|22"`,
+		``,
+		`# comment`,
+		`scsi0:0.fileName = "vm1.vmx"`,
+		`memSize=1024`,
+		`not a valid line`,
+	}, "\n")
+
+	if err := validateVmxContents(valid); err != nil {
+		t.Errorf("valid vmx rejected: %s", err)
+	}
+
+	hand_edited, err := ioutil.ReadFile("vmx/testdata/hand-edited.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateVmxContents(string(hand_edited)); err != nil {
+		t.Errorf("hand edited vmx rejected: %s", err)
+	}
+
+	invalid := map[string]string{
+		"missing virtualHW.version": `config.version = "8"`,
+		"missing config.version":    `virtualHW.version = "13"`,
+		"empty virtualHW.version":   "config.version = \"8\"\nvirtualHW.version = \"\"",
+		"commented out":             "config.version = \"8\"\n# virtualHW.version = \"13\"",
+	}
+
+	for name, contents := range invalid {
+		if err := validateVmxContents(contents); err == nil {
+			t.Errorf("%s: expected vmx to be rejected", name)
+		}
+	}
+}
+
+// fakeVmxFileOps keeps vmx files in memory
+type fakeVmxFileOps struct {
+	files      map[string]string
+	reloadErrs []error
+	reloads    int
+}
+
+func (o *fakeVmxFileOps) copy(src, dst string) error {
+	contents, ok := o.files[src]
+	if !ok {
+		return fmt.Errorf("%s: no such file", src)
+	}
+	o.files[dst] = contents
+	return nil
+}
+
+func (o *fakeVmxFileOps) read(path string) (string, error) {
+	contents, ok := o.files[path]
+	if !ok {
+		return "", fmt.Errorf("%s: no such file", path)
+	}
+	return contents, nil
+}
+
+func (o *fakeVmxFileOps) write(contents, path string) error {
+	o.files[path] = contents
+	return nil
+}
+
+func (o *fakeVmxFileOps) remove(path string) error {
+	delete(o.files, path)
+	return nil
+}
+
+func (o *fakeVmxFileOps) reload() error {
+	o.reloads++
+	if len(o.reloadErrs) == 0 {
+		return nil
+	}
+	err := o.reloadErrs[0]
+	o.reloadErrs = o.reloadErrs[1:]
+	return err
+}
+
+// TestRunVmxTransactionHandEdited verifies a hand edited vmx file is updated in place,
+// and restored from its backup when the reload fails
+func TestRunVmxTransactionHandEdited(t *testing.T) {
+	contents, err := ioutil.ReadFile("vmx/testdata/hand-edited.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst_vmx_file := "/vmfs/volumes/ds1/vm/vm.vmx"
+	now := time.Date(2026, 10, 18, 9, 30, 5, 0, time.UTC)
+	backup_file := vmxBackupName(dst_vmx_file, now)
+
+	doc := vmx.Parse(string(contents))
+	doc.Set("memSize", "2048")
+	updated := doc.String()
+
+	ops := &fakeVmxFileOps{files: map[string]string{dst_vmx_file: string(contents)}}
+	if err := runVmxTransaction(ops, dst_vmx_file, updated, now); err != nil {
+		t.Fatalf("transaction failed: %s", err)
+	}
+	if ops.files[dst_vmx_file] != updated {
+		t.Errorf("vmx file not updated:\n%s", ops.files[dst_vmx_file])
+	}
+	if ops.files[backup_file] != string(contents) {
+		t.Errorf("backup does not hold the original vmx file:\n%s", ops.files[backup_file])
+	}
+	for _, line := range []string{"not a valid line", "# memory", "sched."} {
+		if !strings.Contains(ops.files[dst_vmx_file], line) {
+			t.Errorf("line %q was not kept", line)
+		}
+	}
+
+	ops = &fakeVmxFileOps{
+		files:      map[string]string{dst_vmx_file: string(contents)},
+		reloadErrs: []error{fmt.Errorf("guest is invalid after reload")},
+	}
+	if err := runVmxTransaction(ops, dst_vmx_file, updated, now); err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+	if ops.files[dst_vmx_file] != string(contents) {
+		t.Errorf("vmx file not restored:\n%s", ops.files[dst_vmx_file])
+	}
+	if ops.reloads != 2 {
+		t.Errorf("expected the guest to be reloaded twice, got %d", ops.reloads)
+	}
+}
+
+// TestRunVmxTransactionStripsGuestinfo verifies backups don't keep guestinfo values
+func TestRunVmxTransactionStripsGuestinfo(t *testing.T) {
+	original := strings.Join([]string{
+		`config.version = "8"`,
+		`virtualHW.version = "13"`,
+		`guestinfo.password = "secret"`,
+		`guestinfo.userdata.encoding = "base64"`,
+		`memSize = "1024"`,
+	}, "\n")
+	dst_vmx_file := "/vmfs/volumes/ds1/vm/vm.vmx"
+	now := time.Date(2026, 10, 18, 9, 30, 5, 0, time.UTC)
+	backup_file := vmxBackupName(dst_vmx_file, now)
+
+	for _, reloadErrs := range [][]error{nil, {fmt.Errorf("guest is invalid after reload")}} {
+		ops := &fakeVmxFileOps{files: map[string]string{dst_vmx_file: original}, reloadErrs: reloadErrs}
+		runVmxTransaction(ops, dst_vmx_file, strings.Replace(original, "1024", "2048", 1), now)
+
+		backup, ok := ops.files[backup_file]
+		if !ok {
+			t.Fatalf("backup %s missing", backup_file)
+		}
+		if strings.Contains(backup, "guestinfo") || !strings.Contains(backup, `memSize = "1024"`) {
+			t.Errorf("expected the backup without guestinfo, got:\n%s", backup)
+		}
+		if !strings.Contains(ops.files[dst_vmx_file], "secret") {
+			t.Errorf("guestinfo removed from the vmx file:\n%s", ops.files[dst_vmx_file])
+		}
+	}
+}
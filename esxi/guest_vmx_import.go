@@ -0,0 +1,174 @@
+package esxi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// vmdkExtentRe matches an extent of a vmdk descriptor, such as
+// RW 16777216 VMFS "disk-flat.vmdk"
+var vmdkExtentRe = regexp.MustCompile(`(?m)^\s*(?:RW|RDONLY|NOACCESS)\s+\d+\s+\w+\s+"([^"]+)"`)
+
+// vmdkDescriptorExtents returns the extent files of a vmdk descriptor
+func vmdkDescriptorExtents(descriptor string) []string {
+	var extents []string
+	for _, match := range vmdkExtentRe.FindAllStringSubmatch(descriptor, -1) {
+		extents = append(extents, match[1])
+	}
+	return extents
+}
+
+// vmdkFiles returns the local files of a vmdk: the vmdk itself, and its extents
+// if it's a descriptor.  A sparse vmdk embeds its descriptor and has no extents.
+func vmdkFiles(vmdk_file string) ([]string, error) {
+	f, err := os.Open(vmdk_file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	//  Descriptors are small, the extents are the large files.
+	head := make([]byte, 64*1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	files := []string{vmdk_file}
+	if !bytes.Contains(head, []byte("# Disk DescriptorFile")) || bytes.HasPrefix(head, []byte("KDMV")) {
+		return files, nil
+	}
+	for _, extent := range vmdkDescriptorExtents(string(head)) {
+		files = append(files, filepath.Join(filepath.Dir(vmdk_file), extent))
+	}
+	return files, nil
+}
+
+// vmxDiskAdapterType returns the adapter type of a disk in a vmx, from its
+// controller, as cloneDiskAdapterType does for the devices of a guest.
+func vmxDiskAdapterType(doc *vmx.Document, slot vmx.Slot) types.VirtualDiskAdapterType {
+	switch slot.Bus {
+	case "ide", "sata":
+		return types.VirtualDiskAdapterTypeIde
+	case "scsi":
+		if strings.EqualFold(doc.Value(slot.ControllerName()+".virtualDev"), "buslogic") {
+			return types.VirtualDiskAdapterTypeBusLogic
+		}
+	}
+	return types.VirtualDiskAdapterTypeLsiLogic
+}
+
+// vmxImportDisks returns the disks of a local vmx source and their local vmdk
+// files, in the same order.  Each disk is uploaded to its own directory under
+// staging, as Source.  The disk named after the vmx is the boot disk and comes
+// first, then the other disks in slot order.
+func vmxImportDisks(doc *vmx.Document, vmx_file, guest_name string, staging object.DatastorePath) ([]cloneDisk, []string) {
+	vmx_name := strings.TrimSuffix(filepath.Base(vmx_file), filepath.Ext(vmx_file))
+
+	var sources []vmx.Disk
+	for _, disk := range doc.Disks() {
+		if !disk.Present || disk.IsCdrom() || !strings.HasSuffix(strings.ToLower(disk.FileName), ".vmdk") {
+			continue
+		}
+		sources = append(sources, disk)
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		i_boot := strings.TrimSuffix(filepath.Base(sources[i].FileName), ".vmdk") == vmx_name
+		j_boot := strings.TrimSuffix(filepath.Base(sources[j].FileName), ".vmdk") == vmx_name
+		if i_boot != j_boot {
+			return i_boot
+		}
+		return slotLess(sources[i].Slot, sources[j].Slot)
+	})
+
+	var disks []cloneDisk
+	var local_files []string
+	for i, disk := range sources {
+		local_file := disk.FileName
+		if !filepath.IsAbs(local_file) {
+			local_file = filepath.Join(filepath.Dir(vmx_file), local_file)
+		}
+		source := staging
+		source.Path = fmt.Sprintf("%s/%d/%s", staging.Path, i, filepath.Base(local_file))
+
+		disks = append(disks, cloneDisk{
+			Slot:        disk.Slot,
+			Source:      source.String(),
+			AdapterType: vmxDiskAdapterType(doc, disk.Slot),
+		})
+		local_files = append(local_files, local_file)
+	}
+	nameCloneDisks(disks, guest_name)
+	return disks, local_files
+}
+
+// guestImportVmx creates a guest from a local vmx source, without ovftool.  The
+// disks are uploaded to a staging directory in the guest's directory, then
+// copied as for clone_from_vm, which converts hosted disks to the esxi format.
+func guestImportVmx(c *Config, guest_name, disk_store, src_path, resource_pool_name, boot_disk_type string, rollback *guestRollback) error {
+	esxiConnInfo := getConnectionInfo(c)
+	log.Printf("[guestImportVmx] %s from %s\n", guest_name, src_path)
+
+	contents, err := os.ReadFile(src_path)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %s\n", src_path, err)
+	}
+	doc := vmx.Parse(string(contents))
+
+	staging := object.DatastorePath{Datastore: disk_store, Path: guest_name + "/.vmx-source"}
+	disks, local_files := vmxImportDisks(doc, src_path, guest_name, staging)
+	if len(disks) == 0 {
+		return fmt.Errorf("Source %s has no disks\n", src_path)
+	}
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("Failed to get govmomi client: %s\n", err)
+	}
+
+	fullPATH, err := guestCreateDir(c, disk_store, guest_name, rollback)
+	if err != nil {
+		return err
+	}
+	staging_dir := fullPATH + "/.vmx-source"
+	defer func() {
+		if err := removeRemotePath(c, staging_dir); err != nil {
+			log.Printf("[guestImportVmx] Failed to remove %s: %s\n", staging_dir, err)
+		}
+	}()
+
+	for i, local_file := range local_files {
+		files, err := vmdkFiles(local_file)
+		if err != nil {
+			return fmt.Errorf("Failed to read disk %s: %s\n", local_file, err)
+		}
+
+		disk_dir := staging_dir + "/" + strconv.Itoa(i)
+		remote_cmd := shellCommand("mkdir", "-p", disk_dir)
+		if _, err = runRemoteSshCommand(esxiConnInfo, remote_cmd, "create staging path"); err != nil {
+			return fmt.Errorf("Failed to create staging path %s: %s\n", disk_dir, err)
+		}
+		for _, file := range files {
+			log.Printf("[guestImportVmx] Upload %s to %s\n", file, disk_dir)
+			err = copyFileToRemote(esxiConnInfo, file, disk_dir+"/"+filepath.Base(file), "upload disk")
+			if err != nil {
+				return fmt.Errorf("Failed to upload %s: %s\n", file, err)
+			}
+		}
+	}
+
+	return guestCloneRegister(c, gc, doc, disks, disk_store, guest_name, false, boot_disk_type, resource_pool_name, rollback)
+}
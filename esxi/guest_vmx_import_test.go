@@ -0,0 +1,79 @@
+package esxi
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cars/terraform-provider-esxi/esxi/vmx"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const testVmdkDescriptor = `# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="twoGbMaxExtentSparse"
+
+# Extent description
+RW 4192256 SPARSE "centos-7-s001.vmdk"
+RW 4192256 SPARSE "centos-7-s002.vmdk"
+
+# The Disk Data Base
+ddb.adapterType = "lsilogic"
+`
+
+// TestVmdkFiles verifies a descriptor is uploaded with its extents, and a sparse vmdk alone
+func TestVmdkFiles(t *testing.T) {
+	dir := t.TempDir()
+	descriptor := filepath.Join(dir, "centos-7.vmdk")
+	os.WriteFile(descriptor, []byte(testVmdkDescriptor), 0644)
+
+	files, err := vmdkFiles(descriptor)
+	expected := []string{descriptor, filepath.Join(dir, "centos-7-s001.vmdk"), filepath.Join(dir, "centos-7-s002.vmdk")}
+	if err != nil || !reflect.DeepEqual(files, expected) {
+		t.Errorf("vmdkFiles = %q, %v, expected %q", files, err, expected)
+	}
+
+	//  A monolithic sparse disk embeds a descriptor naming itself.
+	sparse := filepath.Join(dir, "data.vmdk")
+	os.WriteFile(sparse, []byte("KDMV\x01\x00\x00\x00# Disk DescriptorFile\nRW 4192256 SPARSE \"data.vmdk\"\n"), 0644)
+	if files, err = vmdkFiles(sparse); err != nil || !reflect.DeepEqual(files, []string{sparse}) {
+		t.Errorf("vmdkFiles = %q, %v, expected only %s", files, err, sparse)
+	}
+}
+
+// TestVmxImportDisks verifies the disks of a vmx source are staged boot disk first, with their adapter types
+func TestVmxImportDisks(t *testing.T) {
+	doc := vmx.Parse(`scsi0.virtualDev = "buslogic"
+scsi0.present = "TRUE"
+scsi0:0.fileName = "data.vmdk"
+scsi0:0.present = "TRUE"
+sata0.present = "TRUE"
+sata0:0.fileName = "centos-7.vmdk"
+sata0:0.present = "TRUE"
+sata0:1.deviceType = "cdrom-image"
+sata0:1.fileName = "centos.iso"
+sata0:1.present = "TRUE"
+scsi0:1.fileName = "/disks/logs.vmdk"
+scsi0:1.present = "TRUE"
+scsi0:2.fileName = "old.vmdk"
+scsi0:2.present = "FALSE"
+`)
+	staging := object.DatastorePath{Datastore: "ds1", Path: "web/.vmx-source"}
+	disks, local_files := vmxImportDisks(doc, "/images/centos-7.vmx", "web", staging)
+
+	expected := []cloneDisk{
+		{Slot: vmx.Slot{Bus: "sata"}, Source: "[ds1] web/.vmx-source/0/centos-7.vmdk", File: "web.vmdk", AdapterType: types.VirtualDiskAdapterTypeIde},
+		{Slot: vmx.Slot{Bus: "scsi"}, Source: "[ds1] web/.vmx-source/1/data.vmdk", File: "web_1.vmdk", AdapterType: types.VirtualDiskAdapterTypeBusLogic},
+		{Slot: vmx.Slot{Bus: "scsi", Unit: 1}, Source: "[ds1] web/.vmx-source/2/logs.vmdk", File: "web_2.vmdk", AdapterType: types.VirtualDiskAdapterTypeBusLogic},
+	}
+	if !reflect.DeepEqual(disks, expected) {
+		t.Errorf("vmxImportDisks =\n%+v\nexpected\n%+v", disks, expected)
+	}
+	if expected := []string{"/images/centos-7.vmdk", "/images/data.vmdk", "/disks/logs.vmdk"}; !reflect.DeepEqual(local_files, expected) {
+		t.Errorf("local files = %q, expected %q", local_files, expected)
+	}
+}
@@ -0,0 +1,233 @@
+package esxi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guestWaitForPollInterval is how often the tcp_port condition is checked.
+var guestWaitForPollInterval = 5 * time.Second
+
+// guestWaitFor are the conditions a powered on guest must meet before create
+// and update return.  Every condition that is set must be met.
+type guestWaitFor struct {
+	ToolsRunning   bool
+	IPCidr         *net.IPNet // an IP of the guest is in this network
+	AllNICsHaveIP  bool
+	TCPPort        int    // open on the guest's IP, from the provider machine
+	GuestinfoKey   string // the guest sets guestinfo.<key>
+	GuestinfoValue string // ... to this value, or to any value if empty
+	Timeout        time.Duration
+}
+
+// Convert the wait_for block.  It returns nil if it's not set.
+func guestWaitForFromResourceData(d *schema.ResourceData) (*guestWaitFor, error) {
+	if d.Get("wait_for.#").(int) == 0 {
+		return nil, nil
+	}
+
+	wait_for := &guestWaitFor{
+		ToolsRunning:   d.Get("wait_for.0.tools_running").(bool),
+		AllNICsHaveIP:  d.Get("wait_for.0.all_nics_have_ip").(bool),
+		TCPPort:        d.Get("wait_for.0.tcp_port").(int),
+		GuestinfoKey:   d.Get("wait_for.0.guestinfo_key").(string),
+		GuestinfoValue: d.Get("wait_for.0.guestinfo_value").(string),
+		Timeout:        time.Duration(d.Get("wait_for.0.timeout").(int)) * time.Second,
+	}
+
+	if cidr := d.Get("wait_for.0.ip_cidr").(string); cidr != "" {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Error: wait_for: invalid ip_cidr %q: %s", cidr, err)
+		}
+		wait_for.IPCidr = ipnet
+	}
+	if wait_for.GuestinfoValue != "" && wait_for.GuestinfoKey == "" {
+		return nil, fmt.Errorf("Error: wait_for: guestinfo_value requires guestinfo_key")
+	}
+	if !wait_for.ToolsRunning && wait_for.IPCidr == nil && !wait_for.AllNICsHaveIP && wait_for.TCPPort == 0 && wait_for.GuestinfoKey == "" {
+		return nil, fmt.Errorf("Error: wait_for: set at least one condition")
+	}
+	return wait_for, nil
+}
+
+// guestIP returns the guest IP the tcp_port condition is checked on: the first
+// IP in ip_cidr if it's set, else the IP reported by VMware tools.
+func (wait_for guestWaitFor) guestIP(vmMo mo.VirtualMachine) string {
+	if wait_for.IPCidr == nil {
+		if vmMo.Guest != nil && usableGuestIP(vmMo.Guest.IpAddress) {
+			return vmMo.Guest.IpAddress
+		}
+		return ""
+	}
+	if vmMo.Guest == nil {
+		return ""
+	}
+	for _, nic := range vmMo.Guest.Net {
+		for _, ip := range nic.IpAddress {
+			if parsed := net.ParseIP(ip); parsed != nil && wait_for.IPCidr.Contains(parsed) {
+				return ip
+			}
+		}
+	}
+	return ""
+}
+
+// unmet returns the conditions reported by the esxi host that the guest doesn't
+// meet yet.  tcp_port is checked separately, from the provider machine.
+func (wait_for guestWaitFor) unmet(vmMo mo.VirtualMachine) []string {
+	var unmet []string
+
+	if wait_for.ToolsRunning {
+		if vmMo.Guest == nil || vmMo.Guest.ToolsRunningStatus != string(types.VirtualMachineToolsRunningStatusGuestToolsRunning) {
+			unmet = append(unmet, "tools_running")
+		}
+	}
+
+	if wait_for.IPCidr != nil && wait_for.guestIP(vmMo) == "" {
+		unmet = append(unmet, "ip_cidr "+wait_for.IPCidr.String())
+	}
+
+	if wait_for.AllNICsHaveIP {
+		ips := guestIPAddressesFromGuestInfo(vmMo.Guest).ByMAC
+		nics := 0
+		if vmMo.Config != nil {
+			for _, device := range vmMo.Config.Hardware.Device {
+				card, ok := device.(types.BaseVirtualEthernetCard)
+				if !ok {
+					continue
+				}
+				nics++
+				if len(ips[strings.ToLower(card.GetVirtualEthernetCard().MacAddress)]) == 0 {
+					nics = -1
+					break
+				}
+			}
+		}
+		if nics <= 0 {
+			unmet = append(unmet, "all_nics_have_ip")
+		}
+	}
+
+	if wait_for.GuestinfoKey != "" {
+		set := false
+		if vmMo.Config != nil {
+			for _, option := range vmMo.Config.ExtraConfig {
+				value := option.GetOptionValue()
+				if value.Key != "guestinfo."+wait_for.GuestinfoKey {
+					continue
+				}
+				s, _ := value.Value.(string)
+				set = s != "" && (wait_for.GuestinfoValue == "" || s == wait_for.GuestinfoValue)
+			}
+		}
+		if !set {
+			if wait_for.GuestinfoValue != "" {
+				unmet = append(unmet, fmt.Sprintf("guestinfo.%s = %q", wait_for.GuestinfoKey, wait_for.GuestinfoValue))
+			} else {
+				unmet = append(unmet, "guestinfo."+wait_for.GuestinfoKey)
+			}
+		}
+	}
+
+	return unmet
+}
+
+// tcpPortOpen tells if a tcp port accepts connections from the provider machine
+func tcpPortOpen(ip string, port int, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// guestWaitForReady waits for a powered on guest to meet the wait_for
+// conditions.  The guest's properties are watched with the property collector,
+// then tcp_port is polled.  On timeout, the error names the unmet conditions.
+func guestWaitForReady(c *Config, vmid string, wait_for guestWaitFor) error {
+	log.Printf("[guestWaitForReady] Waiting up to %s for vmid %s\n", wait_for.Timeout, vmid)
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	vm, err := getVMByID(gc, vmid)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(gc.Context(), wait_for.Timeout)
+	defer cancel()
+
+	//  Every change only triggers a new read of the guest, as extraConfig
+	//  changes are reported by key.
+	var vmMo mo.VirtualMachine
+	var read_err error
+	unmet := wait_for.unmet(vmMo)
+	props := []string{"runtime.powerState", "guest.toolsRunningStatus", "guest.ipAddress", "guest.net", "config.hardware.device", "config.extraConfig"}
+	err = property.Wait(ctx, property.DefaultCollector(vm.Client()), vm.Reference(), props, func([]types.PropertyChange) bool {
+		vmMo = mo.VirtualMachine{}
+		if read_err = vm.Properties(ctx, vm.Reference(), props, &vmMo); read_err != nil {
+			return true
+		}
+		unmet = wait_for.unmet(vmMo)
+		return len(unmet) == 0 || vmMo.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff
+	})
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			if wait_for.TCPPort != 0 {
+				unmet = append(unmet, fmt.Sprintf("tcp_port %d", wait_for.TCPPort))
+			}
+			return fmt.Errorf("timeout after %s waiting for %s", wait_for.Timeout, strings.Join(unmet, ", "))
+		}
+		return fmt.Errorf("failed to wait for guest: %w", err)
+	}
+	if read_err != nil {
+		return fmt.Errorf("failed to get guest properties: %w", read_err)
+	}
+	if len(unmet) > 0 {
+		return fmt.Errorf("guest powered off while waiting for %s", strings.Join(unmet, ", "))
+	}
+
+	if wait_for.TCPPort == 0 {
+		return nil
+	}
+	condition := fmt.Sprintf("tcp_port %d", wait_for.TCPPort)
+	ip := wait_for.guestIP(vmMo)
+	for {
+		//  The guest IP may not be known until now.
+		if ip == "" {
+			vmMo = mo.VirtualMachine{}
+			if err = vm.Properties(ctx, vm.Reference(), []string{"guest.ipAddress", "guest.net"}, &vmMo); err == nil {
+				ip = wait_for.guestIP(vmMo)
+			}
+		}
+		if ip != "" && tcpPortOpen(ip, wait_for.TCPPort, guestWaitForPollInterval) {
+			log.Printf("[guestWaitForReady] %s:%d is open\n", ip, wait_for.TCPPort)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if ip != "" {
+				condition = fmt.Sprintf("tcp_port %d on %s", wait_for.TCPPort, ip)
+			}
+			return fmt.Errorf("timeout after %s waiting for %s", wait_for.Timeout, condition)
+		case <-time.After(guestWaitForPollInterval):
+		}
+	}
+}
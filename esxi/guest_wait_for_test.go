@@ -0,0 +1,140 @@
+package esxi
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// TestGuestWaitForUnmet verifies the unmet wait_for conditions are named
+func TestGuestWaitForUnmet(t *testing.T) {
+	_, cidr, _ := net.ParseCIDR("192.168.1.0/24")
+	wait_for := guestWaitFor{ToolsRunning: true, IPCidr: cidr, AllNICsHaveIP: true, GuestinfoKey: "ready", GuestinfoValue: "yes"}
+
+	nic := func(mac string) types.BaseVirtualDevice {
+		return &types.VirtualVmxnet3{VirtualVmxnet: types.VirtualVmxnet{VirtualEthernetCard: types.VirtualEthernetCard{MacAddress: mac}}}
+	}
+	vmMo := mo.VirtualMachine{
+		Config: &types.VirtualMachineConfigInfo{
+			Hardware:    types.VirtualHardware{Device: []types.BaseVirtualDevice{nic("00:50:56:00:00:01"), nic("00:50:56:00:00:02")}},
+			ExtraConfig: []types.BaseOptionValue{&types.OptionValue{Key: "guestinfo.ready", Value: "no"}},
+		},
+		Guest: &types.GuestInfo{
+			ToolsRunningStatus: "guestToolsNotRunning",
+			Net: []types.GuestNicInfo{
+				{MacAddress: "00:50:56:00:00:01", IpAddress: []string{"fe80::1", "10.0.0.5"}},
+				{MacAddress: "00:50:56:00:00:02", IpAddress: []string{"fe80::2"}},
+			},
+		},
+	}
+
+	expected := []string{"tools_running", "ip_cidr 192.168.1.0/24", "all_nics_have_ip", `guestinfo.ready = "yes"`}
+	if unmet := wait_for.unmet(vmMo); !reflect.DeepEqual(unmet, expected) {
+		t.Errorf("Expected unmet %q, got %q", expected, unmet)
+	}
+
+	vmMo.Guest.ToolsRunningStatus = "guestToolsRunning"
+	vmMo.Guest.Net[1].MacAddress = "00:50:56:00:00:02"
+	vmMo.Guest.Net[1].IpAddress = []string{"fe80::2", "192.168.1.20"}
+	vmMo.Config.ExtraConfig = []types.BaseOptionValue{&types.OptionValue{Key: "guestinfo.ready", Value: "yes"}}
+	if unmet := wait_for.unmet(vmMo); len(unmet) != 0 {
+		t.Errorf("Expected every condition to be met, got %q", unmet)
+	}
+	if ip := wait_for.guestIP(vmMo); ip != "192.168.1.20" {
+		t.Errorf("Expected the guest IP in ip_cidr, got %q", ip)
+	}
+
+	//  A guest without network interfaces never has an IP on all of them.
+	if unmet := (guestWaitFor{AllNICsHaveIP: true}).unmet(mo.VirtualMachine{Config: &types.VirtualMachineConfigInfo{}}); !reflect.DeepEqual(unmet, []string{"all_nics_have_ip"}) {
+		t.Errorf("Expected all_nics_have_ip to be unmet, got %q", unmet)
+	}
+}
+
+// TestTcpPortOpen verifies tcp ports are checked from the provider machine
+func TestTcpPortOpen(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+
+	if !tcpPortOpen("127.0.0.1", port, time.Second) {
+		t.Errorf("Expected port %d to be open", port)
+	}
+	l.Close()
+	if tcpPortOpen("127.0.0.1", port, time.Second) {
+		t.Errorf("Expected port %d to be closed", port)
+	}
+}
+
+// TestGuestWaitForReadyGovmomi verifies waiting for a guest with the vcsim simulator
+func TestGuestWaitForReadyGovmomi(t *testing.T) {
+	defer func(interval time.Duration) { guestWaitForPollInterval = interval }(guestWaitForPollInterval)
+	guestWaitForPollInterval = 10 * time.Millisecond
+
+	model := simulator.ESX()
+	defer model.Remove()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("Failed to find a guest: %v", err)
+	}
+	vm := vms[0]
+	vmid := vm.Reference().Value
+
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	wait_for := guestWaitFor{IPCidr: cidr, GuestinfoKey: "ready", Timeout: 200 * time.Millisecond}
+
+	err = guestWaitForReady(config, vmid, wait_for)
+	if err == nil || !strings.Contains(err.Error(), "waiting for ip_cidr 10.0.0.0/8, guestinfo.ready") {
+		t.Errorf("Expected a timeout naming the conditions, got %v", err)
+	}
+
+	//  vcsim sets guest properties from SET. keys.
+	task, err := vm.Reconfigure(client.Context(), types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: "SET.guest.ipAddress", Value: "10.1.2.3"},
+			&types.OptionValue{Key: "guestinfo.ready", Value: "yes"},
+		},
+	})
+	if err == nil {
+		err = task.Wait(client.Context())
+	}
+	if err != nil {
+		t.Fatalf("Failed to reconfigure guest: %v", err)
+	}
+
+	wait_for.Timeout = 2 * time.Second
+	if err = guestWaitForReady(config, vmid, wait_for); err != nil {
+		t.Errorf("Expected the guest to be ready, got %v", err)
+	}
+
+}
@@ -0,0 +1,23 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceHOSTAUTOSTARTCreate(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceHOSTAUTOSTARTCreate]")
+
+	err := hostAutoStartUpdate(c, hostAutoStartFromResourceData(d))
+	if err != nil {
+		return fmt.Errorf("Failed to set host autostart: %s\n", err)
+	}
+
+	//  There is one autostart configuration per host.
+	d.SetId(c.esxiHostName)
+
+	return resourceHOSTAUTOSTARTRead(d, m)
+}
@@ -0,0 +1,22 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceHOSTAUTOSTARTDelete(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceHOSTAUTOSTARTDelete]")
+
+	//  The host keeps its autostart configuration, so restore the ESXi defaults.
+	err := hostAutoStartUpdate(c, hostAutoStartDefaults)
+	if err != nil {
+		return fmt.Errorf("Failed to reset host autostart: %s\n", err)
+	}
+
+	d.SetId("")
+	return nil
+}
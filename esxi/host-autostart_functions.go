@@ -0,0 +1,136 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// autoStartStopActions are the stop actions of the host defaults.  Guests can
+// also use systemDefault.
+var autoStartStopActions = []string{"none", "powerOff", "suspend", "guestShutdown"}
+
+// hostAutoStart are the host-wide autostart defaults.
+type hostAutoStart struct {
+	Enabled          bool
+	StartDelay       int // seconds
+	StopDelay        int // seconds
+	StopAction       string
+	WaitForHeartbeat bool
+}
+
+// hostAutoStartDefaults are the autostart defaults of a new ESXi host.
+var hostAutoStartDefaults = hostAutoStart{StartDelay: 120, StopDelay: 120, StopAction: "powerOff"}
+
+// autoStartStopAction returns a stop action in the case used by the schema.  The
+// host reports its default stop action as PowerOff.
+func autoStartStopAction(action string) string {
+	for _, known := range append(autoStartStopActions, "systemDefault") {
+		if strings.EqualFold(action, known) {
+			return known
+		}
+	}
+	return action
+}
+
+// Get the host autostart defaults from the resource config.
+func hostAutoStartFromResourceData(d *schema.ResourceData) hostAutoStart {
+	return hostAutoStart{
+		Enabled:          d.Get("enabled").(bool),
+		StartDelay:       d.Get("start_delay").(int),
+		StopDelay:        d.Get("stop_delay").(int),
+		StopAction:       d.Get("stop_action").(string),
+		WaitForHeartbeat: d.Get("wait_for_heartbeat").(bool),
+	}
+}
+
+// hostAutoStartFromDefaults reads the host defaults
+func hostAutoStartFromDefaults(defaults *types.AutoStartDefaults) hostAutoStart {
+	var autostart hostAutoStart
+	if defaults == nil {
+		return autostart
+	}
+	autostart.Enabled = defaults.Enabled != nil && *defaults.Enabled
+	autostart.StartDelay = int(defaults.StartDelay)
+	autostart.StopDelay = int(defaults.StopDelay)
+	autostart.StopAction = autoStartStopAction(defaults.StopAction)
+	autostart.WaitForHeartbeat = defaults.WaitForHeartbeat != nil && *defaults.WaitForHeartbeat
+	return autostart
+}
+
+// defaults returns the host defaults to reconfigure
+func (autostart hostAutoStart) defaults() *types.AutoStartDefaults {
+	return &types.AutoStartDefaults{
+		Enabled:          types.NewBool(autostart.Enabled),
+		StartDelay:       int32(autostart.StartDelay),
+		StopDelay:        int32(autostart.StopDelay),
+		StopAction:       autostart.StopAction,
+		WaitForHeartbeat: types.NewBool(autostart.WaitForHeartbeat),
+	}
+}
+
+// hostAutoStartConfig returns the host's autostart manager and its configuration.
+func hostAutoStartConfig(c *Config) (*GovmomiClient, types.ManagedObjectReference, types.HostAutoStartManagerConfig, error) {
+	var config types.HostAutoStartManagerConfig
+
+	gc, err := c.GetGovmomiClient()
+	if err != nil {
+		return nil, types.ManagedObjectReference{}, config, fmt.Errorf("failed to get govmomi client: %w", err)
+	}
+
+	host, err := getHostSystem(gc.Context(), gc.Finder)
+	if err != nil {
+		return nil, types.ManagedObjectReference{}, config, err
+	}
+	manager, err := getHostAutoStartManager(gc.Context(), host)
+	if err != nil {
+		return nil, types.ManagedObjectReference{}, config, err
+	}
+
+	var managerMo mo.HostAutoStartManager
+	err = property.DefaultCollector(gc.Client.Client).RetrieveOne(gc.Context(), manager, []string{"config"}, &managerMo)
+	if err != nil {
+		return nil, types.ManagedObjectReference{}, config, fmt.Errorf("failed to get autostart config: %w", err)
+	}
+	return gc, manager, managerMo.Config, nil
+}
+
+// hostAutoStartReconfigure applies an autostart spec to the host.
+func hostAutoStartReconfigure(gc *GovmomiClient, manager types.ManagedObjectReference, spec types.HostAutoStartManagerConfig) error {
+	_, err := methods.ReconfigureAutostart(gc.Context(), gc.Client.Client, &types.ReconfigureAutostart{
+		This: manager,
+		Spec: spec,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconfigure autostart: %w", err)
+	}
+	return nil
+}
+
+// hostAutoStartRead returns the host-wide autostart defaults.
+func hostAutoStartRead(c *Config) (hostAutoStart, error) {
+	log.Printf("[hostAutoStartRead]\n")
+
+	_, _, config, err := hostAutoStartConfig(c)
+	if err != nil {
+		return hostAutoStart{}, err
+	}
+	return hostAutoStartFromDefaults(config.Defaults), nil
+}
+
+// hostAutoStartUpdate sets the host-wide autostart defaults.
+func hostAutoStartUpdate(c *Config, autostart hostAutoStart) error {
+	log.Printf("[hostAutoStartUpdate] %+v\n", autostart)
+
+	gc, manager, _, err := hostAutoStartConfig(c)
+	if err != nil {
+		return err
+	}
+	return hostAutoStartReconfigure(gc, manager, types.HostAutoStartManagerConfig{Defaults: autostart.defaults()})
+}
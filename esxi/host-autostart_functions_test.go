@@ -0,0 +1,117 @@
+package esxi
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// testAutoStartManager stands in for the host's autostart manager, which vcsim
+// doesn't have.
+type testAutoStartManager struct {
+	mo.HostAutoStartManager
+}
+
+func (m *testAutoStartManager) ReconfigureAutostart(req *types.ReconfigureAutostart) soap.HasFault {
+	if req.Spec.Defaults != nil {
+		m.Config.Defaults = req.Spec.Defaults
+	}
+	for _, info := range req.Spec.PowerInfo {
+		found := false
+		for i := range m.Config.PowerInfo {
+			if m.Config.PowerInfo[i].Key == info.Key {
+				m.Config.PowerInfo[i] = info
+				found = true
+			}
+		}
+		if !found {
+			m.Config.PowerInfo = append(m.Config.PowerInfo, info)
+		}
+	}
+	return &methods.ReconfigureAutostartBody{Res: &types.ReconfigureAutostartResponse{}}
+}
+
+// TestHostAutoStartGovmomi verifies the host defaults and guest autostart entries with the vcsim simulator
+func TestHostAutoStartGovmomi(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := simulator.DefaultLogin.Password()
+	config := &Config{
+		esxiHostName:    s.URL.String(),
+		esxiHostSSLport: "443",
+		esxiUserName:    simulator.DefaultLogin.Username(),
+		esxiPassword:    password,
+	}
+
+	client, err := config.GetGovmomiClient()
+	if err != nil {
+		t.Fatalf("Failed to get govmomi client: %v", err)
+	}
+	defer config.CloseGovmomiClient()
+
+	host, err := getHostSystem(client.Context(), client.Finder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := getHostAutoStartManager(client.Context(), host)
+	if err != nil {
+		t.Fatalf("Failed to get the autostart manager: %v", err)
+	}
+	stand_in := &testAutoStartManager{}
+	stand_in.Self = manager
+	stand_in.Config.Defaults = hostAutoStartDefaults.defaults()
+	model.Map().Put(stand_in)
+
+	autostart, err := hostAutoStartRead(config)
+	if err != nil {
+		t.Fatalf("Failed to read host autostart: %v", err)
+	}
+	if autostart != hostAutoStartDefaults {
+		t.Errorf("Expected %+v, got %+v", hostAutoStartDefaults, autostart)
+	}
+
+	expected := hostAutoStart{Enabled: true, StartDelay: 30, StopDelay: 60, StopAction: "guestShutdown", WaitForHeartbeat: true}
+	if err = hostAutoStartUpdate(config, expected); err != nil {
+		t.Fatalf("Failed to update host autostart: %v", err)
+	}
+	if autostart, _ = hostAutoStartRead(config); autostart != expected {
+		t.Errorf("Expected %+v, got %+v", expected, autostart)
+	}
+
+	vms, err := client.Finder.VirtualMachineList(client.Context(), "*")
+	if err != nil || len(vms) == 0 {
+		t.Fatalf("Failed to find a guest: %v", err)
+	}
+	vmid := vms[0].Reference().Value
+
+	if guest, err := guestGetAutoStart(config, vmid); err != nil || guest != nil {
+		t.Errorf("Expected no autostart entry, got %+v, %v", guest, err)
+	}
+
+	for _, guest := range []guestAutoStart{
+		{Enabled: true, StartOrder: 2, StartDelay: -1, StopAction: "systemDefault", WaitForHeartbeat: "yes"},
+		//  A disabled guest keeps no start order.
+		{Enabled: false, StartOrder: 0, StartDelay: 10, StopAction: "suspend", WaitForHeartbeat: "systemDefault"},
+	} {
+		if err = guestSetAutoStart(config, vmid, guest); err != nil {
+			t.Fatalf("Failed to set guest autostart: %v", err)
+		}
+		got, err := guestGetAutoStart(config, vmid)
+		if err != nil || got == nil || *got != guest {
+			t.Errorf("Expected %+v, got %+v, %v", guest, got, err)
+		}
+	}
+}
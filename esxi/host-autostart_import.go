@@ -0,0 +1,24 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceHOSTAUTOSTARTImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	c := m.(*Config)
+	log.Println("[resourceHOSTAUTOSTARTImport]")
+
+	results := make([]*schema.ResourceData, 1, 1)
+	results[0] = d
+
+	_, err := hostAutoStartRead(c)
+	if err != nil {
+		return results, fmt.Errorf("Failed to get host autostart: %s\n", err)
+	}
+	d.SetId(c.esxiHostName)
+
+	return results, nil
+}
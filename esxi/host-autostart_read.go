@@ -0,0 +1,26 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceHOSTAUTOSTARTRead(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceHOSTAUTOSTARTRead]")
+
+	autostart, err := hostAutoStartRead(c)
+	if err != nil {
+		return fmt.Errorf("Failed to refresh host autostart: %s\n", err)
+	}
+
+	d.Set("enabled", autostart.Enabled)
+	d.Set("start_delay", autostart.StartDelay)
+	d.Set("stop_delay", autostart.StopDelay)
+	d.Set("stop_action", autostart.StopAction)
+	d.Set("wait_for_heartbeat", autostart.WaitForHeartbeat)
+
+	return nil
+}
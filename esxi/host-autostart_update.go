@@ -0,0 +1,20 @@
+package esxi
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceHOSTAUTOSTARTUpdate(d *schema.ResourceData, m interface{}) error {
+	c := m.(*Config)
+	log.Println("[resourceHOSTAUTOSTARTUpdate]")
+
+	err := hostAutoStartUpdate(c, hostAutoStartFromResourceData(d))
+	if err != nil {
+		return fmt.Errorf("Failed to update host autostart: %s\n", err)
+	}
+
+	return resourceHOSTAUTOSTARTRead(d, m)
+}
@@ -57,19 +57,25 @@ func Provider() terraform.ResourceProvider {
 			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
-			"esxi_guest":         resourceGUEST(),
-			"esxi_resource_pool": resourceRESOURCEPOOL(),
-			"esxi_virtual_disk":  resourceVIRTUALDISK(),
-			"esxi_vswitch":       resourceVSWITCH(),
-			"esxi_portgroup":     resourcePORTGROUP(),
+			"esxi_guest":          resourceGUEST(),
+			"esxi_guest_snapshot": resourceGUESTSNAPSHOT(),
+			"esxi_guest_export":   resourceGUESTEXPORT(),
+			"esxi_resource_pool":  resourceRESOURCEPOOL(),
+			"esxi_virtual_disk":   resourceVIRTUALDISK(),
+			"esxi_vswitch":        resourceVSWITCH(),
+			"esxi_portgroup":      resourcePORTGROUP(),
+			"esxi_host_autostart": resourceHOSTAUTOSTART(),
+			"esxi_guest_exec":     resourceGUESTEXEC(),
+			"esxi_guest_file":     resourceGUESTFILE(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{
-			"esxi_guest":         dataSourceGuest(),
-			"esxi_portgroup":     dataSourcePortgroup(),
-			"esxi_resource_pool": dataSourceResourcePool(),
-			"esxi_vswitch":       dataSourceVswitch(),
-			"esxi_virtual_disk":  dataSourceVirtualDisk(),
-			"esxi_host":          dataSourceEsxiHost(),
+			"esxi_guest":           dataSourceGuest(),
+			"esxi_guest_snapshots": dataSourceGuestSnapshots(),
+			"esxi_portgroup":       dataSourcePortgroup(),
+			"esxi_resource_pool":   dataSourceResourcePool(),
+			"esxi_vswitch":         dataSourceVswitch(),
+			"esxi_virtual_disk":    dataSourceVirtualDisk(),
+			"esxi_host":            dataSourceEsxiHost(),
 		},
 		ConfigureFunc: configureProvider,
 	}
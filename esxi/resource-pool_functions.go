@@ -148,15 +148,7 @@ func resourcePoolRead(c *Config, pool_id string) (string, int, string, int, stri
 	if poolMo.Config.CpuAllocation.Limit != nil && *poolMo.Config.CpuAllocation.Limit >= 0 {
 		cpu_max = int(*poolMo.Config.CpuAllocation.Limit)
 	}
-	if poolMo.Config.CpuAllocation.Shares != nil {
-		if poolMo.Config.CpuAllocation.Shares.Level == types.SharesLevelNormal ||
-			poolMo.Config.CpuAllocation.Shares.Level == types.SharesLevelLow ||
-			poolMo.Config.CpuAllocation.Shares.Level == types.SharesLevelHigh {
-			cpu_shares = string(poolMo.Config.CpuAllocation.Shares.Level)
-		} else if poolMo.Config.CpuAllocation.Shares.Level == types.SharesLevelCustom {
-			cpu_shares = fmt.Sprintf("%d", poolMo.Config.CpuAllocation.Shares.Shares)
-		}
-	}
+	cpu_shares = sharesString(poolMo.Config.CpuAllocation.Shares)
 
 	// Extract Memory allocation
 	if poolMo.Config.MemoryAllocation.Reservation != nil {
@@ -170,15 +162,7 @@ func resourcePoolRead(c *Config, pool_id string) (string, int, string, int, stri
 	if poolMo.Config.MemoryAllocation.Limit != nil && *poolMo.Config.MemoryAllocation.Limit >= 0 {
 		mem_max = int(*poolMo.Config.MemoryAllocation.Limit)
 	}
-	if poolMo.Config.MemoryAllocation.Shares != nil {
-		if poolMo.Config.MemoryAllocation.Shares.Level == types.SharesLevelNormal ||
-			poolMo.Config.MemoryAllocation.Shares.Level == types.SharesLevelLow ||
-			poolMo.Config.MemoryAllocation.Shares.Level == types.SharesLevelHigh {
-			mem_shares = string(poolMo.Config.MemoryAllocation.Shares.Level)
-		} else if poolMo.Config.MemoryAllocation.Shares.Level == types.SharesLevelCustom {
-			mem_shares = fmt.Sprintf("%d", poolMo.Config.MemoryAllocation.Shares.Shares)
-		}
-	}
+	mem_shares = sharesString(poolMo.Config.MemoryAllocation.Shares)
 
 	// Get pool name
 	resource_pool_name, err := getPoolNAME(c, pool_id)
@@ -349,6 +333,13 @@ func buildAllocationInfo(min int, min_expandable string, max int, shares string)
 	}
 
 	// Set shares
+	allocation.Shares = parseSharesInfo(shares)
+
+	return allocation
+}
+
+// parseSharesInfo parses low, normal, high or a custom number of shares
+func parseSharesInfo(shares string) *types.SharesInfo {
 	sharesInfo := &types.SharesInfo{}
 	switch strings.ToLower(shares) {
 	case "low":
@@ -366,7 +357,19 @@ func buildAllocationInfo(min int, min_expandable string, max int, shares string)
 			sharesInfo.Level = types.SharesLevelNormal
 		}
 	}
-	allocation.Shares = sharesInfo
+	return sharesInfo
+}
 
-	return allocation
+// sharesString is the reverse of parseSharesInfo
+func sharesString(sharesInfo *types.SharesInfo) string {
+	if sharesInfo == nil {
+		return ""
+	}
+	switch sharesInfo.Level {
+	case types.SharesLevelNormal, types.SharesLevelLow, types.SharesLevelHigh:
+		return string(sharesInfo.Level)
+	case types.SharesLevelCustom:
+		return fmt.Sprintf("%d", sharesInfo.Shares)
+	}
+	return ""
 }
@@ -0,0 +1,88 @@
+package esxi
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+func resourceGUESTEXEC() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceGUESTEXECCreate,
+		Read:   resourceGUESTEXECRead,
+		Update: resourceGUESTEXECUpdate,
+		Delete: resourceGUESTEXECDelete,
+		Schema: map[string]*schema.Schema{
+			"guest_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The VM ID of the guest to run the command in.  VMware tools must be running.",
+			},
+			"username": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Guest OS user to run the command as.",
+			},
+			"password": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				Sensitive:   true,
+				Description: "Password of the guest OS user.",
+			},
+			"command": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Command to run with /bin/sh -c, or cmd.exe /c on Windows guests.",
+			},
+			"working_directory": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Directory to run the command in.",
+			},
+			"environment": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				ForceNew:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Environment variables of the command.",
+			},
+			"timeout": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      300,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "Seconds to wait for the command to exit.  It's terminated after that.",
+			},
+			"fail_on_error": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Fail if the command exits with a non-zero exit code.",
+			},
+			"triggers": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				ForceNew:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Arbitrary values that run the command again when they change.",
+			},
+			"exit_code": &schema.Schema{
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Exit code of the command.",
+			},
+			"stdout": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Standard output of the command.",
+			},
+			"stderr": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Standard error of the command.",
+			},
+		},
+	}
+}
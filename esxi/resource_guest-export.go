@@ -0,0 +1,90 @@
+package esxi
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+func resourceGUESTEXPORT() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceGUESTEXPORTCreate,
+		Read:   resourceGUESTEXPORTRead,
+		Update: resourceGUESTEXPORTUpdate,
+		Delete: resourceGUESTEXPORTDelete,
+		Schema: map[string]*schema.Schema{
+			"guest_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The VM ID of the guest to export.  It must be powered off.",
+			},
+			"path": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Local directory to export the ovf to, or the ova file to write.",
+			},
+			"format": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      "ovf",
+				ValidateFunc: validation.StringInSlice([]string{"ovf", "ova"}, false),
+				Description:  "Export format: ovf (a directory of files) or ova (a single file).",
+			},
+			"name": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Computed:    true,
+				Description: "Name of the exported guest and its files.  Default is the guest name.",
+			},
+			"manifest": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     false,
+				Description: "Write a manifest with the SHA256 checksums of the exported files.",
+			},
+			"strip_properties": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     false,
+				Description: "Remove the guest's vApp (ovf) properties from the exported ovf.",
+			},
+			"strip_networks": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     false,
+				Description: "Remove the networks and network interfaces from the exported ovf.",
+			},
+			"triggers": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				ForceNew:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Arbitrary values that cause a new export when they change.",
+			},
+			"keep_on_destroy": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Keep the exported files when the resource is destroyed.",
+			},
+			"files": &schema.Schema{
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "The local files written by the export.",
+			},
+			"checksums": &schema.Schema{
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "SHA256 checksums of the exported ovf, manifest and disks, by file name.",
+			},
+		},
+	}
+}
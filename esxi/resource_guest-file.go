@@ -0,0 +1,89 @@
+package esxi
+
+import (
+	"regexp"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+func resourceGUESTFILE() *schema.Resource {
+	return &schema.Resource{
+		Create:        resourceGUESTFILECreate,
+		Read:          resourceGUESTFILERead,
+		Update:        resourceGUESTFILEUpdate,
+		Delete:        resourceGUESTFILEDelete,
+		CustomizeDiff: resourceGUESTFILECustomizeDiff,
+		Schema: map[string]*schema.Schema{
+			"guest_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The VM ID of the guest to copy the file to.  VMware tools must be running.",
+			},
+			"username": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Guest OS user to write the file as.",
+			},
+			"password": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				Sensitive:   true,
+				Description: "Password of the guest OS user.",
+			},
+			"destination": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Absolute path of the file in the guest.",
+			},
+			"content": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"source"},
+				Description:   "Contents of the file.",
+			},
+			"source": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"content"},
+				Description:   "Local file to copy.",
+			},
+			"permissions": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringMatch(regexp.MustCompile(`^[0-7]{3,4}$`), "must be an octal mode, such as 0644"),
+				Description:  "Octal mode of the file on posix guests.",
+			},
+			"create_directories": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Create the missing parent directories of destination.",
+			},
+			"triggers": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Arbitrary values that copy the file again when they change.",
+			},
+			"keep_on_destroy": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Keep the file in the guest when the resource is destroyed.",
+			},
+			"size": &schema.Schema{
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Size of the file in bytes.",
+			},
+			"sha256": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "SHA256 checksum of the copied contents.",
+			},
+		},
+	}
+}
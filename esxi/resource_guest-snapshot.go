@@ -0,0 +1,85 @@
+package esxi
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceGUESTSNAPSHOT() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceGUESTSNAPSHOTCreate,
+		Read:   resourceGUESTSNAPSHOTRead,
+		Update: resourceGUESTSNAPSHOTUpdate,
+		Delete: resourceGUESTSNAPSHOTDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceGUESTSNAPSHOTImport,
+		},
+		Schema: map[string]*schema.Schema{
+			"guest_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The VM ID of the guest to snapshot.",
+			},
+			"snapshot_name": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Snapshot name.",
+			},
+			"description": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Snapshot description.",
+			},
+			"include_memory": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     false,
+				Description: "Include the guest memory, so reverting resumes a running guest.",
+			},
+			"quiesce": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     false,
+				Description: "Quiesce the guest file systems with VMware tools before taking the snapshot.",
+			},
+			"parent_snapshot_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Computed:    true,
+				Description: "The snapshot this snapshot is a child of.  If set, it must be the guest's current snapshot.",
+			},
+			"revert_on_destroy": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Revert the guest to this snapshot before removing it.",
+			},
+			"remove_children": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Also remove the child snapshots when this snapshot is removed.",
+			},
+			"create_time": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Snapshot creation time (RFC3339).",
+			},
+			"power_state": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Guest power state when the snapshot was taken.",
+			},
+			"size": &schema.Schema{
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Snapshot size in bytes.",
+			},
+		},
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net/url"
 	"strconv"
 
 	"github.com/hashicorp/terraform/helper/schema"
@@ -20,6 +19,7 @@ func resourceGUEST() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			State: resourceGUESTImport,
 		},
+		CustomizeDiff: resourceGUESTCustomizeDiff,
 		Schema: map[string]*schema.Schema{
 			"clone_from_vm": &schema.Schema{
 				Type:        schema.TypeString,
@@ -28,6 +28,19 @@ func resourceGUEST() *schema.Resource {
 				Default:     nil,
 				Description: "Source vm path on esxi host to clone.",
 			},
+			"clone_snapshot": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Snapshot (name or ID) of clone_from_vm to clone from, instead of its current disks.",
+			},
+			"linked_clone": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     false,
+				Description: "Create the guest's disks as delta disks over clone_snapshot instead of copying them.",
+			},
 			"host_ovf": &schema.Schema{
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -49,6 +62,47 @@ func resourceGUEST() *schema.Resource {
 				Default:     "bios",
 				Description: "Boot type('efi' is boot uefi mode)",
 			},
+			"boot_order": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Computed:    true,
+				Description: "Boot devices, in order: disk, cdrom, network, floppy, ethernetN or a disk slot such as scsi0:1.",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringMatch(bootDeviceRe, "expected disk, cdrom, network, floppy, ethernetN or a disk slot such as scsi0:1"),
+				},
+			},
+			"boot_delay_ms": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Delay before the boot sequence starts (in ms).",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"efi_secure_boot_enabled": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Computed:    true,
+				Description: "Enable EFI secure boot.  Requires boot_firmware efi.",
+			},
+			"boot_retry_enabled": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Computed:    true,
+				Description: "Retry booting after boot_retry_delay_ms if no boot device is found.",
+			},
+			"boot_retry_delay_ms": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Delay before a boot retry (in ms).",
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"enter_bios_setup_once": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Enter the BIOS/EFI setup on the next boot.",
+			},
 			"disk_store": &schema.Schema{
 				Type:        schema.TypeString,
 				Required:    true,
@@ -68,6 +122,19 @@ func resourceGUEST() *schema.Resource {
 				ForceNew:    true,
 				Description: "esxi guest name.",
 			},
+			"on_conflict": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "fail",
+				Description:  "What to do if a guest with the same name already exists. fail, adopt or replace.",
+				ValidateFunc: validation.StringInSlice([]string{"fail", "adopt", "replace"}, false),
+			},
+			"keep_on_failure": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Keep a partially created guest for inspection if create fails, instead of rolling it back.",
+			},
 			"boot_disk_type": &schema.Schema{
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -97,6 +164,99 @@ func resourceGUEST() *schema.Resource {
 				Computed:    true,
 				Description: "Guest guest number of virtual cpus.",
 			},
+			"cores_per_socket": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Number of cores per virtual cpu socket.  It must divide numvcpus.",
+				ValidateFunc: validation.IntBetween(0, 128),
+			},
+			"nested_hv_enabled": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Computed:    true,
+				Description: "Expose hardware assisted virtualization to the guest, to run nested hypervisors.",
+			},
+			"cpu_performance_counters": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Computed:    true,
+				Description: "Expose cpu performance counters to the guest.",
+			},
+			"latency_sensitivity": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Latency sensitivity (low/normal/medium/high).",
+				ValidateFunc: validation.StringInSlice([]string{"low", "normal", "medium", "high"}, false),
+			},
+			"numa_node_affinity": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Description: "NUMA nodes the guest may run on.",
+			},
+			"cpu_hot_add_enabled": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Computed:    true,
+				Description: "Allow adding cpus while the guest is powered on.",
+			},
+			"memory_hot_add_enabled": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Computed:    true,
+				Description: "Allow adding memory while the guest is powered on.",
+			},
+			"cpu_reservation": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				Description:  "CPU reservation (in MHz).",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"cpu_limit": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				Description:  "CPU limit (in MHz).  0 is unlimited.",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"cpu_shares": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				Description:  "CPU shares (low/normal/high/<custom>).",
+				ValidateFunc: validation.StringMatch(sharesRe, "must be low, normal, high or a number of shares"),
+			},
+			"mem_reservation": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Memory reservation (in MB).",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"mem_limit": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Memory limit (in MB).  0 is unlimited.",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"mem_shares": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Memory shares (low/normal/high/<custom>).",
+				ValidateFunc: validation.StringMatch(sharesRe, "must be low, normal, high or a number of shares"),
+			},
+			"mem_reservation_locked_to_max": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Computed:    true,
+				Description: "Reserve all of the guest's memory, following memsize.",
+			},
 			"virthwver": &schema.Schema{
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -125,10 +285,10 @@ func resourceGUEST() *schema.Resource {
 							Computed: true,
 						},
 						"mac_address": &schema.Schema{
-							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: false,
-							Computed: true,
+							Type:        schema.TypeString,
+							Optional:    true,
+							ForceNew:    false,
+							Description: "Static MAC address.  If not set, a MAC address is generated.",
 						},
 						"nic_type": &schema.Schema{
 							Type:     schema.TypeString,
@@ -136,21 +296,112 @@ func resourceGUEST() *schema.Resource {
 							ForceNew: false,
 							Computed: true,
 						},
+						"connected": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "Connect the network interface while the guest is powered on.",
+						},
+						"start_connected": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "Connect the network interface when the guest powers on.",
+						},
+						"ip_addresses": &schema.Schema{
+							Type:        schema.TypeList,
+							Computed:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: "The IPs of the network interface reported by VMware tools.",
+						},
 					},
 				},
 			},
 			"power": &schema.Schema{
-				Type:        schema.TypeString,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     false,
+				Computed:     true,
+				Description:  "Guest power state (on/off/suspended).",
+				ValidateFunc: validation.StringInSlice([]string{"on", "off", "suspended"}, false),
+			},
+			"reboot_trigger": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "Reboot the powered on guest when any value changes.",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"autostart": &schema.Schema{
+				Type:        schema.TypeList,
 				Optional:    true,
-				ForceNew:    false,
 				Computed:    true,
-				Description: "Guest power state.",
+				MaxItems:    1,
+				Description: "Start the guest when the host starts.  See the esxi_host_autostart resource for the host defaults.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"enabled": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "Start the guest when the host starts.",
+						},
+						"start_order": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      0,
+							Description:  "Start order, from 1.  0 starts the guest in any order after the ordered guests.",
+							ValidateFunc: validation.IntAtLeast(0),
+						},
+						"start_delay": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      -1,
+							Description:  "Delay before the next guest is started (in seconds).  -1 is the host default.",
+							ValidateFunc: validation.IntAtLeast(-1),
+						},
+						"stop_action": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "systemDefault",
+							Description:  "Action when the host stops: systemDefault, none, powerOff, suspend or guestShutdown.",
+							ValidateFunc: validation.StringInSlice(append([]string{"systemDefault"}, autoStartStopActions...), false),
+						},
+						"wait_for_heartbeat": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "systemDefault",
+							Description:  "Start the next guest when VMware tools report a heartbeat: yes, no or systemDefault.",
+							ValidateFunc: validation.StringInSlice([]string{"yes", "no", "systemDefault"}, false),
+						},
+					},
+				},
+			},
+			"shutdown_behavior": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      shutdownBehaviorGuestThenHard,
+				Description:  "How the guest is powered off and rebooted: guest, hard or guest-then-hard.",
+				ValidateFunc: validation.StringInSlice([]string{shutdownBehaviorGuest, shutdownBehaviorHard, shutdownBehaviorGuestThenHard}, false),
 			},
 			//  Calculated only, you cannot overwrite this.
 			"ip_address": &schema.Schema{
 				Type:        schema.TypeString,
 				Computed:    true,
-				Description: "The IP address reported by VMware tools.",
+				Description: "The IP address reported by VMware tools, chosen by preferred_ip_cidrs.",
+			},
+			"ip_addresses": &schema.Schema{
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Every IP address reported by VMware tools, the guest's network interfaces first.",
+			},
+			"preferred_ip_cidrs": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: validation.CIDRNetwork(0, 128)},
+				Description: "Networks to choose ip_address from, in order of preference.  Default is the first IPv4 address.",
 			},
 			"guest_startup_timeout": {
 				Type:         schema.TypeInt,
@@ -159,6 +410,57 @@ func resourceGUEST() *schema.Resource {
 				Description:  "The amount of guest uptime, in seconds, to wait for an available IP address on this virtual machine.",
 				ValidateFunc: validation.IntBetween(0, 600),
 			},
+			"wait_for": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Conditions the powered on guest must meet before create and update return.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"tools_running": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Wait for VMware tools to report running.",
+						},
+						"ip_cidr": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "Wait for an IP of the guest in this network, for example 192.168.1.0/24.",
+							ValidateFunc: validation.CIDRNetwork(0, 128),
+						},
+						"all_nics_have_ip": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Wait for every network interface to have an IP.",
+						},
+						"tcp_port": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Description:  "Wait for this tcp port of the guest to accept connections from the provider machine.",
+							ValidateFunc: validation.IntBetween(1, 65535),
+						},
+						"guestinfo_key": &schema.Schema{
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Wait for the guest to set guestinfo.<guestinfo_key>.",
+						},
+						"guestinfo_value": &schema.Schema{
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Wait for guestinfo_key to be set to this value.  Default is any value.",
+						},
+						"timeout": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      300,
+							Description:  "The amount of time, in seconds, to wait for the conditions.",
+							ValidateFunc: validation.IntBetween(1, 3600),
+						},
+					},
+				},
+			},
 			"guest_shutdown_timeout": {
 				Type:         schema.TypeInt,
 				Optional:     true,
@@ -166,6 +468,13 @@ func resourceGUEST() *schema.Resource {
 				Description:  "The amount of time, in seconds, to wait for a graceful shutdown before doing a forced power off.",
 				ValidateFunc: validation.IntBetween(0, 600),
 			},
+			"vmx_backup_retention": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      3,
+				Description:  "The number of timestamped vmx file backups to keep next to the guest's vmx file.",
+				ValidateFunc: validation.IntBetween(0, 100),
+			},
 			"virtual_disks": &schema.Schema{
 				Type:     schema.TypeList,
 				Optional: true,
@@ -178,10 +487,72 @@ func resourceGUEST() *schema.Resource {
 							Required: true,
 						},
 						"slot": &schema.Schema{
+							Type:             schema.TypeString,
+							Optional:         true,
+							Computed:         true,
+							Description:      "Controller and unit, such as scsi0:1, sata0:2 or nvme0:1.  X:Y is scsiX:Y.",
+							DiffSuppressFunc: diskSlotDiffSuppress,
+						},
+					},
+				},
+			},
+			"cdrom": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Cdrom drives.  If none are configured, the guest's cdroms are left as they are.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"iso_path": &schema.Schema{
+							Type:             schema.TypeString,
+							Optional:         true,
+							Computed:         true,
+							Description:      "ISO on the esxi host, /vmfs/volumes/<datastore>/<path> or [<datastore>] <path>.  Empty for an empty drive.",
+							DiffSuppressFunc: isoPathDiffSuppress,
+						},
+						"local_iso_path": &schema.Schema{
 							Type:        schema.TypeString,
 							Optional:    true,
-							Computed:    true,
-							Description: "SCSI_Ctrl:SCSI_id.    Range  '0:1' to '0:15'.   SCSI_id 7 is not allowed.",
+							Description: "Local ISO that is uploaded to the guest's directory.",
+						},
+						"controller_type": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "ide",
+							Description:  "Controller type, ide or sata.",
+							ValidateFunc: validation.StringInSlice([]string{"ide", "sata"}, false),
+						},
+						"connected": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "Connect the ISO while the guest is powered on.",
+						},
+						"start_connected": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "Connect the ISO when the guest powers on.",
+						},
+					},
+				},
+			},
+			"controllers": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Disk controllers.  Controllers that disks are attached to are created automatically.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": &schema.Schema{
+							Type:         schema.TypeString,
+							Required:     true,
+							Description:  "Controller type, pvscsi, lsilogic, lsilogic-sas, buslogic, sata or nvme.",
+							ValidateFunc: validation.StringInSlice(diskControllerTypeNames, false),
+						},
+						"bus_number": &schema.Schema{
+							Type:         schema.TypeInt,
+							Required:     true,
+							Description:  "Controller number on its bus.",
+							ValidateFunc: validation.IntBetween(0, 3),
 						},
 					},
 				},
@@ -210,9 +581,53 @@ func resourceGUEST() *schema.Resource {
 				Type:         schema.TypeInt,
 				Optional:     true,
 				Computed:     true,
-				Description:  "The amount of time, in seconds, to wait for the guest to boot and run ovf_properties.",
+				Description:  "The amount of time, in seconds, to wait for the guest to boot and run ovf_properties.  With ovf_properties_ready, the longest time to wait.",
 				ValidateFunc: validation.IntBetween(0, 6000),
 			},
+			"ovf_properties_ready": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				ForceNew:    true,
+				MaxItems:    1,
+				Description: "How to tell the guest has processed ovf_properties, instead of waiting for ovf_properties_timer.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"guestinfo_key": &schema.Schema{
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Ready when the guest sets guestinfo.<guestinfo_key>.",
+						},
+						"guestinfo_value": &schema.Schema{
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Ready when guestinfo_key is set to this value.  Default is any value.",
+						},
+						"tools_running": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Ready when VMware tools report running.",
+						},
+						"guest_shutdown": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Ready when the guest powers itself off.",
+						},
+					},
+				},
+			},
+			"requires_reboot": &schema.Schema{
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "The planned update powers the guest off and on.",
+			},
+			"adopt_changes": &schema.Schema{
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "The changes adopting an existing guest makes to it, with on_conflict adopt.",
+			},
 			"notes": &schema.Schema{
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -224,7 +639,31 @@ func resourceGUEST() *schema.Resource {
 				Type:        schema.TypeMap,
 				Optional:    true,
 				Description: "pass data to VM",
-				ForceNew:    true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"sensitive_guestinfo": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "pass data to VM, hidden from plan output and logs",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"guestinfo_encoding": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "",
+				ValidateFunc: validation.StringInSlice([]string{"", "base64", "gzip+base64"}, false),
+				Description:  "Encode the metadata, userdata and vendordata guestinfo keys for cloud-init: base64 or gzip+base64.",
+			},
+			"extra_config": &schema.Schema{
+				Type:         schema.TypeMap,
+				Optional:     true,
+				Description:  "Additional vmx settings. Keys set by other attributes are not allowed.",
+				ValidateFunc: validateExtraConfig,
 				Elem: &schema.Schema{
 					Type: schema.TypeString,
 				},
@@ -238,13 +677,13 @@ func resourceGUESTCreate(d *schema.ResourceData, m interface{}) error {
 
 	log.Printf("[resourceGUESTCreate]\n")
 
-	var virtual_networks [10][3]string
-	var virtual_disks [60][2]string
 	var src_path string
-	var tmpint, i, virtualDiskCount, ovfPropsCount, guest_shutdown_timeout, ovf_properties_timer int
+	var tmpint, i, ovfPropsCount, guest_shutdown_timeout, ovf_properties_timer int
 	var ovf_properties map[string]string
 
 	clone_from_vm := d.Get("clone_from_vm").(string)
+	clone_snapshot := d.Get("clone_snapshot").(string)
+	linked_clone := d.Get("linked_clone").(bool)
 	ovf_source := d.Get("ovf_source").(string)
 	disk_store := d.Get("disk_store").(string)
 	resource_pool_name := d.Get("resource_pool_name").(string)
@@ -258,6 +697,9 @@ func resourceGUESTCreate(d *schema.ResourceData, m interface{}) error {
 	boot_firmware := d.Get("boot_firmware").(string)
 	notes := d.Get("notes").(string)
 	power := d.Get("power").(string)
+	on_conflict := d.Get("on_conflict").(string)
+	vmx_backup_retention := d.Get("vmx_backup_retention").(int)
+	keep_on_failure := d.Get("keep_on_failure").(bool)
 
 	if d.Get("guest_startup_timeout").(int) > 0 {
 		d.Set("guest_startup_timeout", d.Get("guest_startup_timeout").(int))
@@ -278,25 +720,35 @@ func resourceGUESTCreate(d *schema.ResourceData, m interface{}) error {
 		ovf_properties_timer = 90
 	}
 
-	guestinfo, ok := d.Get("guestinfo").(map[string]interface{})
-	if !ok {
-		return errors.New("guestinfo is wrong type")
+	guestinfo, _, err := guestinfoFromResourceData(d)
+	if err != nil {
+		return err
 	}
+	extra_config := d.Get("extra_config").(map[string]interface{})
 
 	// Validations
 	if resource_pool_name == "ha-root-pool" {
 		resource_pool_name = "/"
 	}
 
-	if clone_from_vm != "" {
-		password := url.QueryEscape(c.esxiPassword)
-		src_path = fmt.Sprintf("vi://%s:%s@%s:%s/%s", c.esxiUserName, password, c.esxiHostName, c.esxiHostSSLport, clone_from_vm)
-	} else if ovf_source != "" {
+	//  clone_from_vm is cloned on the esxi host.
+	if ovf_source != "" {
 		src_path = ovf_source
 	} else {
 		src_path = "none"
 	}
 
+	//  Linked clones are built on a snapshot of clone_from_vm.
+	if linked_clone && (clone_from_vm == "" || clone_snapshot == "") {
+		return errors.New("Error: linked_clone requires clone_from_vm and clone_snapshot")
+	}
+	if clone_snapshot != "" && clone_from_vm == "" {
+		return errors.New("Error: clone_snapshot requires clone_from_vm")
+	}
+	if linked_clone && boot_disk_size != "" {
+		return errors.New("Error: boot_disk_size can't be used with linked_clone")
+	}
+
 	//  Validate number of virthwver.
 	// todo
 	//switch virthwver {
@@ -329,60 +781,27 @@ func resourceGUESTCreate(d *schema.ResourceData, m interface{}) error {
 	}
 
 	//  Validate lan adapters
-	lanAdaptersCount := d.Get("network_interfaces.#").(int)
-	if lanAdaptersCount > 10 {
-		lanAdaptersCount = 10
-	}
-	for i = 0; i < lanAdaptersCount; i++ {
-		prefix := fmt.Sprintf("network_interfaces.%d.", i)
-
-		if attr, ok := d.Get(prefix + "virtual_network").(string); ok && attr != "" {
-			virtual_networks[i][0] = d.Get(prefix + "virtual_network").(string)
-		}
-
-		if attr, ok := d.Get(prefix + "mac_address").(string); ok && attr != "" {
-			virtual_networks[i][1] = d.Get(prefix + "mac_address").(string)
-		}
-
-		if attr, ok := d.Get(prefix + "nic_type").(string); ok && attr != "" {
-			virtual_networks[i][2] = d.Get(prefix + "nic_type").(string)
-			//  Validate nictype
-			if validateNICType(virtual_networks[i][2]) == false {
-				errMSG := fmt.Sprintf("Error: invalid nic_type. %s\nMust be vlance flexible e1000 e1000e vmxnet vmxnet2 or vmxnet3", virtual_networks[i][2])
-				return errors.New(errMSG)
-			}
-		}
+	virtual_networks, err := guestNICsFromResourceData(d)
+	if err != nil {
+		return err
 	}
 
-	//  Validate virtual_disks
-	virtualDiskCount, ok = d.Get("virtual_disks.#").(int)
-	if !ok {
-		virtualDiskCount = 0
-		virtual_disks[0][0] = ""
+	//  Validate virtual_disks and controllers
+	virtual_disks, err := guestDisksFromResourceData(d)
+	if err != nil {
+		return err
 	}
-
-	if virtualDiskCount > 59 {
-		virtualDiskCount = 59
+	controllers, err := guestControllersFromResourceData(d)
+	if err != nil {
+		return err
 	}
-	for i = 0; i < virtualDiskCount; i++ {
-		prefix := fmt.Sprintf("virtual_disks.%d.", i)
-
-		if attr, ok := d.Get(prefix + "virtual_disk_id").(string); ok && attr != "" {
-			virtual_disks[i][0] = d.Get(prefix + "virtual_disk_id").(string)
-		}
-
-		if attr, ok := d.Get(prefix + "slot").(string); ok && attr != "" {
-			virtual_disks[i][1] = d.Get(prefix + "slot").(string)
-			validateVirtualDiskSlot(virtual_disks[i][1])
-			result := validateVirtualDiskSlot(virtual_disks[i][1])
-			if result != "ok" {
-				return errors.New(result)
-			}
-		}
+	cdroms, err := guestCdromsFromResourceData(d)
+	if err != nil {
+		return err
 	}
 
 	//  Parse ovf properties, if any
-	ovfPropsCount, ok = d.Get("ovf_properties.#").(int)
+	ovfPropsCount, ok := d.Get("ovf_properties.#").(int)
 	if !ok {
 		ovfPropsCount = 0
 	} else {
@@ -400,9 +819,37 @@ func resourceGUESTCreate(d *schema.ResourceData, m interface{}) error {
 		}
 	}
 
-	vmid, err := guestCREATE(c, guest_name, disk_store, src_path, resource_pool_name, memsize,
-		numvcpus, virthwver, guestos, boot_disk_type, boot_disk_size, virtual_networks, boot_firmware,
-		virtual_disks, guest_shutdown_timeout, ovf_properties_timer, notes, guestinfo, ovf_properties)
+	ovf_properties_ready, err := ovfPropertiesReadyFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	wait_for, err := guestWaitForFromResourceData(d)
+	if err != nil {
+		return err
+	}
+
+	//  The cpu settings of a clone's source are kept if none are set.
+	var cpu *guestCPU
+	if guestCPUConfigured(d) {
+		guest_cpu := guestCPUFromResourceData(d)
+		inumvcpus, _ := strconv.Atoi(numvcpus)
+		err = guestValidateCPU(c, guest_cpu, inumvcpus)
+		if err != nil {
+			return err
+		}
+		cpu = &guest_cpu
+	}
+
+	boot_options := guestBootOptionsFromResourceData(d)
+	ivirthwver, _ := strconv.Atoi(virthwver)
+	err = boot_options.validate(boot_firmware, ivirthwver)
+	if err != nil {
+		return err
+	}
+
+	vmid, err := guestCREATE(c, guest_name, disk_store, src_path, clone_from_vm, clone_snapshot, linked_clone, resource_pool_name, memsize,
+		numvcpus, cpu, virthwver, guestos, boot_disk_type, boot_disk_size, virtual_networks, boot_firmware,
+		virtual_disks, controllers, cdroms, guest_shutdown_timeout, ovf_properties_timer, notes, guestinfo, extra_config, ovf_properties, ovf_properties_ready, on_conflict, vmx_backup_retention, keep_on_failure)
 	if err != nil {
 		tmpint, _ = strconv.Atoi(vmid)
 		if tmpint > 0 {
@@ -414,11 +861,54 @@ func resourceGUESTCreate(d *schema.ResourceData, m interface{}) error {
 	//  set vmid
 	d.SetId(vmid)
 
-	if power == "on" || power == "" {
+	if guestResourceAllocationConfigured(d) {
+		err = guestSetResourceAllocation(c, vmid, guestResourceAllocationFromResourceData(d))
+		if err != nil {
+			return err
+		}
+	}
+
+	if guestBootOptionsConfigured(d) {
+		err = guestSetBootOptions(c, vmid, boot_options)
+		if err != nil {
+			return err
+		}
+	}
+
+	if autostart := guestAutoStartFromResourceData(d); autostart != nil {
+		err = guestSetAutoStart(c, vmid, *autostart)
+		if err != nil {
+			return err
+		}
+	}
+
+	if power == "on" || power == "" || power == "suspended" {
 		_, err = guestPowerOn(c, vmid)
 		if err != nil {
 			return errors.New("Failed to power on.")
 		}
+		err = guestSetNICConnected(c, vmid, virtual_networks)
+		if err != nil {
+			return err
+		}
+		if len(cdroms) > 0 {
+			err = guestSetCdromConnected(c, vmid, cdroms)
+			if err != nil {
+				return err
+			}
+		}
+		if wait_for != nil {
+			err = guestWaitForReady(c, vmid, *wait_for)
+			if err != nil {
+				return fmt.Errorf("Failed to wait for guest: %s\n", err)
+			}
+		}
+		if power == "suspended" {
+			_, err = guestPowerSuspend(c, vmid)
+			if err != nil {
+				return fmt.Errorf("Failed to suspend: %s\n", err)
+			}
+		}
 	}
 	d.Set("power", "on")
 
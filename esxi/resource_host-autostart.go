@@ -0,0 +1,53 @@
+package esxi
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+func resourceHOSTAUTOSTART() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceHOSTAUTOSTARTCreate,
+		Read:   resourceHOSTAUTOSTARTRead,
+		Update: resourceHOSTAUTOSTARTUpdate,
+		Delete: resourceHOSTAUTOSTARTDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceHOSTAUTOSTARTImport,
+		},
+		Schema: map[string]*schema.Schema{
+			"enabled": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Start and stop guests with the host.",
+			},
+			"start_delay": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      120,
+				Description:  "Default delay before the next guest is started (in seconds).",
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"stop_delay": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      120,
+				Description:  "Default delay before the next guest is stopped (in seconds).",
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"stop_action": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "powerOff",
+				Description:  "Default action when the host stops: none, powerOff, suspend or guestShutdown.",
+				ValidateFunc: validation.StringInSlice(autoStartStopActions, false),
+			},
+			"wait_for_heartbeat": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Start the next guest when VMware tools report a heartbeat.",
+			},
+		},
+	}
+}
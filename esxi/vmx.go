@@ -12,6 +12,8 @@ import (
 
 // ParseVMX parses the keys and values from a VMX file and returns
 // them as a Go map.
+//
+// Deprecated: comments, ordering and repeated keys are lost.  Use vmx.Parse.
 func ParseVMX(contents string) map[string]string {
 	results := make(map[string]string)
 
@@ -30,6 +32,8 @@ func ParseVMX(contents string) map[string]string {
 }
 
 // EncodeVMX takes a map and turns it into valid VMX contents.
+//
+// Deprecated: values are not escaped.  Use vmx.Document.
 func EncodeVMX(contents map[string]string) string {
 	var buf bytes.Buffer
 
@@ -0,0 +1,174 @@
+package vmx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Buses are the controller types that disks and cdroms attach to.
+var Buses = []string{"scsi", "sata", "ide", "nvme"}
+
+var (
+	slotRe          = regexp.MustCompile(`(?i)^(scsi|sata|ide|nvme)(\d+):(\d+)$`)
+	slotKeyRe       = regexp.MustCompile(`(?i)^(scsi|sata|ide|nvme)(\d+):(\d+)\.`)
+	ethernetKeyRe   = regexp.MustCompile(`(?i)^ethernet(\d+)\.`)
+	controllerKeyRe = regexp.MustCompile(`(?i)^(scsi|sata|ide|nvme)(\d+)\.`)
+)
+
+// Slot is the position of a disk or cdrom, such as scsi0:1.
+type Slot struct {
+	Bus        string
+	Controller int
+	Unit       int
+}
+
+// ParseSlot parses a slot such as scsi0:1 or sata1:0.
+func ParseSlot(s string) (Slot, error) {
+	m := slotRe.FindStringSubmatch(s)
+	if m == nil {
+		return Slot{}, fmt.Errorf("invalid device slot %q, expected <bus><controller>:<unit> with bus scsi, sata, ide or nvme", s)
+	}
+	controller, _ := strconv.Atoi(m[2])
+	unit, _ := strconv.Atoi(m[3])
+	return Slot{Bus: strings.ToLower(m[1]), Controller: controller, Unit: unit}, nil
+}
+
+// String returns the slot's device name, such as scsi0:1.
+func (s Slot) String() string {
+	return fmt.Sprintf("%s%d:%d", s.Bus, s.Controller, s.Unit)
+}
+
+// ControllerName returns the name of the controller the slot is on, such as scsi0.
+func (s Slot) ControllerName() string {
+	return fmt.Sprintf("%s%d", s.Bus, s.Controller)
+}
+
+// Key returns the key of one of the slot's settings, such as scsi0:1.fileName.
+func (s Slot) Key(setting string) string {
+	return s.String() + "." + setting
+}
+
+// EthernetName returns the device name of a network adapter, such as ethernet0.
+func EthernetName(index int) string {
+	return fmt.Sprintf("ethernet%d", index)
+}
+
+// Disk is a disk or cdrom.
+type Disk struct {
+	Slot           Slot
+	Present        bool
+	DeviceType     string
+	FileName       string
+	StartConnected bool
+}
+
+// Disks returns the disks and cdroms in the document, in the order they first appear.
+func (d *Document) Disks() []Disk {
+	var disks []Disk
+	for _, name := range d.devices(slotKeyRe) {
+		slot, _ := ParseSlot(name)
+		disk := Disk{
+			Slot:           slot,
+			Present:        d.Bool(slot.Key("present"), false),
+			DeviceType:     d.Value(slot.Key("deviceType")),
+			FileName:       d.Value(slot.Key("fileName")),
+			StartConnected: d.Bool(slot.Key("startConnected"), true),
+		}
+		disks = append(disks, disk)
+	}
+	return disks
+}
+
+// IsCdrom reports whether the device is a cdrom rather than a disk.
+func (disk Disk) IsCdrom() bool {
+	return strings.Contains(strings.ToLower(disk.DeviceType), "cdrom")
+}
+
+// Controllers returns the names of the disk controllers in the document, such as scsi0,
+// in the order they first appear.
+func (d *Document) Controllers() []string {
+	return d.devices(controllerKeyRe)
+}
+
+// Ethernet is a network adapter.
+type Ethernet struct {
+	Index            int
+	Present          bool
+	NetworkName      string
+	VirtualDev       string
+	AddressType      string
+	Address          string
+	GeneratedAddress string
+	DVSPortgroupID   string
+	Connected        bool
+	StartConnected   bool
+}
+
+// Ethernets returns the network adapters in the document, in the order they first appear.
+func (d *Document) Ethernets() []Ethernet {
+	var nics []Ethernet
+	for _, name := range d.devices(ethernetKeyRe) {
+		index, _ := strconv.Atoi(name[len("ethernet"):])
+		nic := Ethernet{
+			Index:            index,
+			Present:          d.Bool(name+".present", false),
+			NetworkName:      d.Value(name + ".networkName"),
+			VirtualDev:       d.Value(name + ".virtualDev"),
+			AddressType:      d.Value(name + ".addressType"),
+			Address:          d.Value(name + ".address"),
+			GeneratedAddress: d.Value(name + ".generatedAddress"),
+			DVSPortgroupID:   d.Value(name + ".dvs.portgroupId"),
+			Connected:        d.Bool(name+".connected", true),
+			StartConnected:   d.Bool(name+".startConnected", true),
+		}
+		nics = append(nics, nic)
+	}
+	return nics
+}
+
+// Value returns the value of key, or "" if it isn't set.
+func (d *Document) Value(key string) string {
+	value, _ := d.Get(key)
+	return value
+}
+
+// Bool returns a boolean setting, or def if it isn't set.
+func (d *Document) Bool(key string, def bool) bool {
+	value, ok := d.Get(key)
+	if !ok {
+		return def
+	}
+	return strings.EqualFold(value, "true") || value == "1"
+}
+
+// SetBool sets a boolean setting in the form VMware writes it.
+func (d *Document) SetBool(key string, value bool) {
+	if value {
+		d.Set(key, "TRUE")
+	} else {
+		d.Set(key, "FALSE")
+	}
+}
+
+// devices returns the lower case device names matched by re, in the order they first appear.
+func (d *Document) devices(re *regexp.Regexp) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, l := range d.lines {
+		if l.key == "" {
+			continue
+		}
+		m := re.FindString(l.key)
+		if m == "" {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(m, "."))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
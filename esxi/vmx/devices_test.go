@@ -0,0 +1,84 @@
+package vmx
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// TestParseSlot verifies slot parsing for every bus
+func TestParseSlot(t *testing.T) {
+	tests := map[string]Slot{
+		"scsi0:1":  {Bus: "scsi", Controller: 0, Unit: 1},
+		"SATA1:0":  {Bus: "sata", Controller: 1, Unit: 0},
+		"ide1:0":   {Bus: "ide", Controller: 1, Unit: 0},
+		"nvme3:14": {Bus: "nvme", Controller: 3, Unit: 14},
+	}
+	for in, expected := range tests {
+		slot, err := ParseSlot(in)
+		if err != nil || slot != expected {
+			t.Errorf("ParseSlot(%q) = %+v, %v, expected %+v", in, slot, err, expected)
+		}
+	}
+	if got := (Slot{Bus: "sata", Controller: 1, Unit: 2}).Key("fileName"); got != "sata1:2.fileName" {
+		t.Errorf("unexpected key %q", got)
+	}
+	if got := (Slot{Bus: "nvme", Controller: 0, Unit: 2}).ControllerName(); got != "nvme0" {
+		t.Errorf("unexpected controller name %q", got)
+	}
+
+	for _, in := range []string{"0:1", "scsi0", "usb0:1", "scsi0:1.fileName", ""} {
+		if _, err := ParseSlot(in); err == nil {
+			t.Errorf("ParseSlot(%q) should fail", in)
+		}
+	}
+}
+
+// TestDevices verifies the typed disk, controller and network adapter accessors
+func TestDevices(t *testing.T) {
+	contents, err := ioutil.ReadFile("testdata/esxi-generated.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := Parse(string(contents))
+
+	expectedDisks := []Disk{
+		{Slot: Slot{"scsi", 0, 0}, Present: true, DeviceType: "scsi-hardDisk", FileName: "web01.vmdk", StartConnected: true},
+		{Slot: Slot{"scsi", 0, 1}, Present: true, DeviceType: "scsi-hardDisk", FileName: "/vmfs/volumes/datastore1/data/web01-data.vmdk", StartConnected: true},
+		{Slot: Slot{"sata", 0, 0}, Present: true, DeviceType: "cdrom-image", FileName: "/vmfs/volumes/datastore1/iso/centos.iso", StartConnected: false},
+	}
+	if disks := doc.Disks(); !reflect.DeepEqual(disks, expectedDisks) {
+		t.Errorf("Disks() =\n%+v\nexpected\n%+v", disks, expectedDisks)
+	}
+
+	for i, disk := range expectedDisks {
+		if disk.IsCdrom() != (i == 2) {
+			t.Errorf("%s: IsCdrom() = %v", disk.Slot, disk.IsCdrom())
+		}
+	}
+
+	if controllers := doc.Controllers(); !reflect.DeepEqual(controllers, []string{"scsi0", "sata0"}) {
+		t.Errorf("Controllers() = %q", controllers)
+	}
+
+	expectedNics := []Ethernet{
+		{Index: 0, Present: true, NetworkName: "VM Network", VirtualDev: "vmxnet3", AddressType: "generated", GeneratedAddress: "00:0c:29:e1:e3:a7", Connected: true, StartConnected: true},
+		{Index: 1, Present: true, NetworkName: "Backup", VirtualDev: "e1000", AddressType: "static", Address: "00:50:56:01:02:03", Connected: true, StartConnected: true},
+		{Index: 10, Present: true, NetworkName: "Storage", VirtualDev: "vmxnet3", AddressType: "generated", GeneratedAddress: "00:0c:29:e1:e3:b1", Connected: true, StartConnected: true},
+	}
+	if nics := doc.Ethernets(); !reflect.DeepEqual(nics, expectedNics) {
+		t.Errorf("Ethernets() =\n%+v\nexpected\n%+v", nics, expectedNics)
+	}
+
+	if doc.Value("displayName") != "web #01" {
+		t.Errorf("unexpected displayName %q", doc.Value("displayName"))
+	}
+	if doc.Value("annotation") != "Built by \"terraform\"\nOwner: ops|platform" {
+		t.Errorf("unexpected annotation %q", doc.Value("annotation"))
+	}
+
+	doc.DeleteDevice(EthernetName(1))
+	if nics := doc.Ethernets(); len(nics) != 2 || nics[1].Index != 10 {
+		t.Errorf("removing ethernet1 should keep ethernet10, got %+v", nics)
+	}
+}
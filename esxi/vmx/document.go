@@ -0,0 +1,215 @@
+// Package vmx reads and edits VMware .vmx files.
+//
+// A Document keeps every line of the file in its original order.  Comments, blank
+// lines and lines that can't be parsed are written back unchanged, and entries are
+// only reformatted when they are changed.
+package vmx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	quotedEntryRe   = regexp.MustCompile(`^\s*([^\s=#"]+)\s*=\s*"(.*)"\s*$`)
+	unquotedEntryRe = regexp.MustCompile(`^\s*([^\s=#"]+)\s*=\s*([^"]*?)\s*$`)
+)
+
+// Document is a parsed vmx file.
+type Document struct {
+	lines []line
+}
+
+type line struct {
+	raw   string
+	key   string // empty if the line is not an entry
+	value string // unescaped
+}
+
+// Parse parses the contents of a vmx file.  It never fails; lines that are not
+// key = "value" entries are kept as they are.
+func Parse(contents string) *Document {
+	doc := &Document{}
+	for _, raw := range strings.Split(contents, "\n") {
+		l := line{raw: raw}
+		text := strings.TrimSuffix(raw, "\r")
+		if !strings.HasPrefix(strings.TrimSpace(text), "#") {
+			if m := quotedEntryRe.FindStringSubmatch(text); m != nil {
+				l.key, l.value = m[1], Unescape(m[2])
+			} else if m := unquotedEntryRe.FindStringSubmatch(text); m != nil {
+				l.key, l.value = m[1], Unescape(m[2])
+			}
+		}
+		doc.lines = append(doc.lines, l)
+	}
+	return doc
+}
+
+// String returns the document as vmx file contents.
+func (d *Document) String() string {
+	raw := make([]string, len(d.lines))
+	for i, l := range d.lines {
+		raw[i] = l.raw
+	}
+	return strings.Join(raw, "\n")
+}
+
+// Get returns the value of key.  Keys are case insensitive.  If the key is repeated,
+// the last value wins.
+func (d *Document) Get(key string) (string, bool) {
+	value, ok := "", false
+	for _, l := range d.lines {
+		if l.key != "" && strings.EqualFold(l.key, key) {
+			value, ok = l.value, true
+		}
+	}
+	return value, ok
+}
+
+// Has reports whether key is set.
+func (d *Document) Has(key string) bool {
+	_, ok := d.Get(key)
+	return ok
+}
+
+// Set sets key to value.  An existing entry is updated in place and any repeats of it
+// are removed, otherwise the entry is added to the end of the document.
+func (d *Document) Set(key, value string) {
+	found := false
+	lines := d.lines[:0]
+	for _, l := range d.lines {
+		if l.key != "" && strings.EqualFold(l.key, key) {
+			if found {
+				continue
+			}
+			found = true
+			if l.value != value {
+				l = newLine(l.key, value)
+			}
+		}
+		lines = append(lines, l)
+	}
+	d.lines = lines
+
+	if !found {
+		d.append(newLine(key, value))
+	}
+}
+
+// Delete removes key.  It reports whether the key was set.
+func (d *Document) Delete(key string) bool {
+	return d.deleteFunc(func(k string) bool { return strings.EqualFold(k, key) }) > 0
+}
+
+// DeleteDevice removes every entry of a device, such as ethernet1 or scsi0:1.  Keys
+// of other devices that share the prefix, such as ethernet10, are not removed.  It
+// returns the number of entries removed.
+func (d *Document) DeleteDevice(device string) int {
+	return d.deleteFunc(func(k string) bool { return isDeviceKey(k, device) })
+}
+
+// HasDevice reports whether any entry of a device is set.
+func (d *Document) HasDevice(device string) bool {
+	for _, l := range d.lines {
+		if l.key != "" && isDeviceKey(l.key, device) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the keys in the document in the order they first appear.
+func (d *Document) Keys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, l := range d.lines {
+		if l.key == "" || seen[strings.ToLower(l.key)] {
+			continue
+		}
+		seen[strings.ToLower(l.key)] = true
+		keys = append(keys, l.key)
+	}
+	return keys
+}
+
+func (d *Document) deleteFunc(match func(key string) bool) int {
+	removed := 0
+	lines := d.lines[:0]
+	for _, l := range d.lines {
+		if l.key != "" && match(l.key) {
+			removed++
+			continue
+		}
+		lines = append(lines, l)
+	}
+	d.lines = lines
+	return removed
+}
+
+// append adds a line to the end of the document, keeping a trailing newline.
+func (d *Document) append(l line) {
+	n := len(d.lines)
+	if n > 0 && d.lines[n-1].raw == "" {
+		d.lines = append(d.lines[:n-1], l, d.lines[n-1])
+		return
+	}
+	d.lines = append(d.lines, l)
+}
+
+func newLine(key, value string) line {
+	return line{raw: fmt.Sprintf("%s = \"%s\"", key, Escape(value)), key: key, value: value}
+}
+
+func isDeviceKey(key, device string) bool {
+	return len(key) > len(device) && key[len(device)] == '.' && strings.EqualFold(key[:len(device)], device)
+}
+
+// Escape encodes a value the way VMware does.  Quotes, '|', '#' and control
+// characters are written as |XX, where XX is the hex character code.
+func Escape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '"' || c == '|' || c == '#' || c < 0x20 || c == 0x7f {
+			fmt.Fprintf(&b, "|%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Unescape decodes a value encoded by Escape.  A '|' that isn't followed by two hex
+// digits is kept as it is.
+func Unescape(value string) string {
+	if !strings.Contains(value, "|") {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '|' && i+2 < len(value) && isHex(value[i+1]) && isHex(value[i+2]) {
+			b.WriteByte(unhex(value[i+1])<<4 | unhex(value[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
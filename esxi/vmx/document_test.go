@@ -0,0 +1,161 @@
+package vmx
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var keyRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// TestRoundTrip verifies every file in the corpus is written back unchanged
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.vmx")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no test corpus: %v", err)
+	}
+
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Parse(string(contents)).String(); got != string(contents) {
+			t.Errorf("%s: round trip changed the file:\n%q", file, got)
+		}
+	}
+
+	for _, contents := range []string{"", "\n", "a = \"1\"", "a = \"1\"\n"} {
+		if got := Parse(contents).String(); got != contents {
+			t.Errorf("round trip of %q gave %q", contents, got)
+		}
+	}
+}
+
+// TestDocumentGet verifies values are unescaped and keys are case insensitive
+func TestDocumentGet(t *testing.T) {
+	contents, err := ioutil.ReadFile("testdata/hand-edited.vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := Parse(string(contents))
+
+	expected := map[string]string{
+		"memsize":          "1024",
+		"numvcpus":         "2",
+		"guestOS":          "other|zz",
+		"nvram":            "a|b.nvram",
+		"scsi0:0.fileName": "vm.vmdk",
+		".encoding":        "UTF-8",
+	}
+	for key, value := range expected {
+		if got, ok := doc.Get(key); !ok || got != value {
+			t.Errorf("Get(%q) = %q, %v, expected %q", key, got, ok, value)
+		}
+	}
+	if doc.Has("sched.") || doc.Has("not") {
+		t.Error("invalid lines should not be parsed as entries")
+	}
+
+	expectedKeys := []string{".encoding", "config.version", "memSize", "virtualHW.version", "numvcpus", "guestOS", "nvram", "scsi0:0.fileName"}
+	if keys := doc.Keys(); !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Keys() = %q, expected %q", keys, expectedKeys)
+	}
+}
+
+// TestDocumentEdit verifies edits keep the rest of the file as it was
+func TestDocumentEdit(t *testing.T) {
+	contents := "# comment\n" +
+		".encoding = \"UTF-8\"\n" +
+		"memSize = \"512\"\n" +
+		"numvcpus = \"1\"\n" +
+		"numvcpus = \"1\"\n" +
+		"ethernet1.networkName = \"Backup\"\n" +
+		"ethernet1.present = \"TRUE\"\n" +
+		"ethernet10.networkName = \"Storage\"\n" +
+		"ethernet10.present = \"TRUE\"\n" +
+		"sched.ethernet1.shares = \"normal\"\n"
+
+	doc := Parse(contents)
+	doc.Set("MEMSIZE", "1024")
+	doc.Set("numvcpus", "2")
+	doc.Set("annotation", "say \"hi\"\nline|2 #1")
+	if n := doc.DeleteDevice("ethernet1"); n != 2 {
+		t.Errorf("DeleteDevice removed %d entries, expected 2", n)
+	}
+	if doc.HasDevice("ethernet1") || !doc.HasDevice("ethernet10") {
+		t.Error("DeleteDevice should only remove ethernet1")
+	}
+	if !doc.Delete(".encoding") || doc.Delete(".encoding") {
+		t.Error("Delete should report whether the key was set")
+	}
+
+	expected := "# comment\n" +
+		"memSize = \"1024\"\n" +
+		"numvcpus = \"2\"\n" +
+		"ethernet10.networkName = \"Storage\"\n" +
+		"ethernet10.present = \"TRUE\"\n" +
+		"sched.ethernet1.shares = \"normal\"\n" +
+		"annotation = \"say |22hi|22|0Aline|7C2 |231\"\n"
+	if got := doc.String(); got != expected {
+		t.Errorf("unexpected document:\n got: %q\nwant: %q", got, expected)
+	}
+
+	if got := Parse(doc.String()).Value("annotation"); got != "say \"hi\"\nline|2 #1" {
+		t.Errorf("annotation did not survive a round trip: %q", got)
+	}
+}
+
+// TestEscape verifies VMware value escaping
+func TestEscape(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		"plain text":    "plain text",
+		`"quoted"`:      "|22quoted|22",
+		"a|b":           "a|7Cb",
+		"vm #1":         "vm |231",
+		"line1\nline2":  "line1|0Aline2",
+		"tab\there":     "tab|09here",
+		"/vmfs/volumes": "/vmfs/volumes",
+		"ünïcödé":       "ünïcödé",
+	}
+
+	for in, expected := range tests {
+		if got := Escape(in); got != expected {
+			t.Errorf("Escape(%q) = %q, expected %q", in, got, expected)
+		}
+		if got := Unescape(expected); got != in {
+			t.Errorf("Unescape(%q) = %q, expected %q", expected, got, in)
+		}
+	}
+
+	for _, literal := range []string{"a|b", "|", "a|2", "|zz"} {
+		if got := Unescape(literal); got != literal {
+			t.Errorf("Unescape(%q) = %q, expected it unchanged", literal, got)
+		}
+	}
+}
+
+// FuzzDocument verifies parsing never loses lines and set values read back unchanged
+func FuzzDocument(f *testing.F) {
+	f.Add("memSize = \"512\"\n", "annotation", "a \"note\"\n#1|x")
+	f.Add("# comment\r\nx=1\r\n", "x", "")
+	f.Add("", "guestinfo.userdata", "|22")
+
+	f.Fuzz(func(t *testing.T, contents, key, value string) {
+		if got := Parse(contents).String(); got != contents {
+			t.Fatalf("round trip of %q gave %q", contents, got)
+		}
+
+		if !keyRe.MatchString(key) {
+			t.Skip("not a valid key")
+		}
+		doc := Parse(contents)
+		doc.Set(key, value)
+		if got, ok := Parse(doc.String()).Get(key); !ok || got != value {
+			t.Errorf("Set(%q, %q) read back as %q, %v", key, value, got, ok)
+		}
+	})
+}
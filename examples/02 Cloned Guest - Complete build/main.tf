@@ -34,8 +34,8 @@ resource "esxi_guest" "vmtest02" {
   resource_pool_name = "/"
   power              = "on"
 
-  #  clone_from_vm uses ovftool to clone an existing Guest on your esxi host.  This example will clone a Guest VM named "centos7", located in the "Templates" resource pool.
-  #  ovf_source uses ovftool to produce a clone from an ovf or vmx image. (typically produced using the ovf_tool).
+  #  clone_from_vm clones an existing Guest on your esxi host.  This example will clone a Guest VM named "centos7", located in the "Templates" resource pool.
+  #  ovf_source imports an ovf, ova or vmx image. (typically produced using the ovf_tool).
   #    Basically clone_from_vm clones from sources on the esxi host and ovf_source clones from sources on your local hard disk or a URL.
   #    These two options are mutually exclusive.
   clone_from_vm = "Templates/centos7"
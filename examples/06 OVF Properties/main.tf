@@ -38,7 +38,7 @@ resource "esxi_guest" "vmtest" {
 
   #
   #  Specify ovf_properties specific to the source ovf/ova.
-  #    Use ovftool <filename>.ova to get details of which ovf_properties are available.
+  #    The ProductSection of the ovf lists which ovf_properties are available.
   #
   ovf_properties {
     key = "hostname"